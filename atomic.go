@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nomadicGopher/Convert_HEIC/convert"
+)
+
+// runAtomicConversion runs the conversion into a temporary file beside res.Target and renames it into
+// place only on success, so an interrupted or failed conversion never leaves a truncated output that
+// later runs would skip as done. The output is the last argument, possibly behind a "format:" prefix.
+func runAtomicConversion(ctx context.Context, res *fileResult, name string, args []string) error {
+	if *allFrames {
+		// Every image gets its own output, which ImageMagick names itself, so there is no single file to rename.
+		return runConversion(ctx, res, name, args)
+	}
+	final := res.Target
+	tmp, err := convert.TempOutput(final)
+	if err != nil {
+		return err
+	}
+	args = append([]string(nil), args...)
+	if last := args[len(args)-1]; strings.HasSuffix(last, final) {
+		args[len(args)-1] = strings.TrimSuffix(last, final) + tmp
+	}
+	res.Target = tmp
+	err = runConversion(ctx, res, name, args)
+	res.Target = final
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to move the output of %s into place: %v", res.Source, err)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+)
+
+// Converter is a conversion backend: it checks that its tools are installed and builds the command that
+// converts one file.
+type Converter interface {
+	// Verify checks that the backend's tools are installed and usable.
+	Verify() error
+	// Command returns the program and arguments that convert inFile into outFile.
+	Command(inFile, outFile string) (string, []string, error)
+}
+
+// converter is the backend selected by -backend.
+var converter Converter = magickConverter{}
+
+// newConverter returns the backend with the given -backend name, rejecting flags it cannot honor.
+func newConverter(name string) (Converter, error) {
+	switch name {
+	case "magick":
+		return magickConverter{}, nil
+	case "libheif":
+		if err := checkMagickOnlyFlags(name); err != nil {
+			return nil, err
+		}
+		if !*autoOrient {
+			return nil, fmt.Errorf("-no-auto-orient is not supported with -backend %s, which always rotates outputs upright", name)
+		}
+		if !isJpegType(*outType) && *outType != "png" {
+			return nil, fmt.Errorf("-backend libheif cannot write %s output", *outType)
+		}
+		if *outputExt != "" {
+			return nil, errors.New("-output-ext is not supported with -backend libheif, which picks the format from the extension")
+		}
+		return &libheifConverter{}, nil
+	case "sips":
+		if err := checkMagickOnlyFlags(name); err != nil {
+			return nil, err
+		}
+		if !*autoOrient {
+			return nil, fmt.Errorf("-no-auto-orient is not supported with -backend %s, which always rotates outputs upright", name)
+		}
+		if !isJpegType(*outType) && *outType != "png" && *outType != "tiff" && *outType != "bmp" {
+			return nil, fmt.Errorf("-backend sips cannot write %s output", *outType)
+		}
+		return sipsConverter{}, nil
+	case "native":
+		if err := checkMagickOnlyFlags(name); err != nil {
+			return nil, err
+		}
+		if !isJpegType(*outType) && *outType != "png" {
+			return nil, fmt.Errorf("-backend native cannot write %s output", *outType)
+		}
+		if *dumpCommands != "" {
+			return nil, errors.New("-dump-commands is not supported with -backend native, which runs no external commands")
+		}
+		return nativeConverter{}, nil
+	default:
+		return nil, fmt.Errorf("invalid -backend %q. Use 'magick', 'libheif', 'sips' or 'native'", name)
+	}
+}
+
+// magickConverter converts with ImageMagick's convert, or montage for -montage contact sheets.
+type magickConverter struct{}
+
+// Verify checks for ImageMagick with HEIC support.
+func (magickConverter) Verify() error {
+	return verifyImageMagick()
+}
+
+// Command builds the ImageMagick invocation for one file.
+func (magickConverter) Command(inFile, outFile string) (string, []string, error) {
+	return buildCommand(inFile, outFile)
+}
+
+// libheifConverter converts with libheif's heif-convert, or heif-dec as newer libheif releases call it.
+// It writes PNG and JPEG natively, choosing the format from the output extension.
+type libheifConverter struct {
+	bin string
+}
+
+// libheifBinaries are the names libheif's decoder tool has shipped under, newest first.
+var libheifBinaries = []string{"heif-dec", "heif-convert"}
+
+// Verify resolves the libheif decoder binary.
+func (c *libheifConverter) Verify() error {
+	for _, bin := range libheifBinaries {
+		if _, err := exec.LookPath(bin); err == nil {
+			c.bin = bin
+			return nil
+		}
+	}
+	return errors.New("neither 'heif-dec' nor 'heif-convert' exists, please install libheif's example tools (e.g. libheif-examples)")
+}
+
+// Command builds the heif-convert invocation for one file.
+func (c *libheifConverter) Command(inFile, outFile string) (string, []string, error) {
+	return c.bin, buildLibheifArgs(inFile, outFile), nil
+}
+
+// buildLibheifArgs assembles the heif-convert arguments for one file.
+func buildLibheifArgs(inFile, outFile string) []string {
+	if *jpegQuality > 0 && isJpegType(*outType) {
+		return []string{"-q", strconv.Itoa(*jpegQuality), inFile, outFile}
+	}
+	return []string{inFile, outFile}
+}
+
+// checkMagickOnlyFlags rejects flags that rely on ImageMagick processing, which the given backend cannot perform.
+func checkMagickOnlyFlags(backend string) error {
+	unsupported := []struct {
+		name string
+		set  bool
+	}{
+		{"-montage", *montageMode},
+		{"-io-workers", *ioWorkers > 0},
+		{"-split-timing", *splitTiming},
+		{"-target-size", *targetSize != ""},
+		{"-quality-scale", *qualityScale != ""},
+		{"-with-thumbnail", *withThumbnail},
+		{"-tone-map", *toneMap},
+		{"-auto-grayscale", *autoGrayscale},
+		{"-vendor-fixups", *vendorFix},
+		{"-verify-tiles", *verifyTiles},
+		{"-strip", *stripMeta},
+		{"-strip-profile", *stripProfile},
+		{"-convert-to-srgb", *convertToSRGB},
+		{"-limit", len(*magickLimits) > 0},
+		{"-magick-config", *magickConfig != ""},
+		{"-all-frames", *allFrames},
+		{"-frame", *frameIndex > 0},
+		{"-png-compression", *pngCompression != -1},
+		{"-resize", *resizeGeom != ""},
+		{"-max-dimension", *maxDimension > 0},
+		{"-sizes", *sizesList != ""},
+		{"-dedupe-pixels", *dedupePixels},
+	}
+	for _, f := range unsupported {
+		if f.set {
+			return fmt.Errorf("%s is not supported with -backend %s", f.name, backend)
+		}
+	}
+	return nil
+}
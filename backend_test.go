@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNewConverter(t *testing.T) {
+	tests := []struct {
+		name      string
+		backend   string
+		outType   string
+		noOrient  bool
+		toneMap   bool
+		outputExt string
+		dump      string
+		wantType  Converter
+		wantErr   string
+	}{
+		{name: "magick", backend: "magick", outType: "webp", toneMap: true, wantType: magickConverter{}},
+		{name: "libheif jpeg", backend: "libheif", outType: "jpg", wantType: &libheifConverter{}},
+		{name: "libheif png", backend: "libheif", outType: "png", wantType: &libheifConverter{}},
+		{name: "libheif webp", backend: "libheif", outType: "webp", wantErr: "-backend libheif cannot write webp output"},
+		{name: "libheif without auto-orient", backend: "libheif", outType: "jpg", noOrient: true, wantErr: "-no-auto-orient is not supported"},
+		{name: "libheif with -output-ext", backend: "libheif", outType: "jpg", outputExt: "jpeg", wantErr: "-output-ext is not supported"},
+		{name: "libheif with an ImageMagick-only flag", backend: "libheif", outType: "jpg", toneMap: true, wantErr: "-tone-map is not supported with -backend libheif"},
+		{name: "sips tiff", backend: "sips", outType: "tiff", wantType: sipsConverter{}},
+		{name: "sips webp", backend: "sips", outType: "webp", wantErr: "-backend sips cannot write webp output"},
+		{name: "native png", backend: "native", outType: "png", wantType: nativeConverter{}},
+		{name: "native with -dump-commands", backend: "native", outType: "png", dump: "cmds.sh", wantErr: "-dump-commands is not supported"},
+		{name: "unknown", backend: "gimp", outType: "jpg", wantErr: `invalid -backend "gimp"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, outType, tt.outType)
+			setFlag(t, autoOrient, !tt.noOrient)
+			setFlag(t, toneMap, tt.toneMap)
+			setFlag(t, outputExt, tt.outputExt)
+			setFlag(t, dumpCommands, tt.dump)
+			got, err := newConverter(tt.backend)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("newConverter(%q) = %T, %v, want an error containing %q", tt.backend, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || reflect.TypeOf(got) != reflect.TypeOf(tt.wantType) {
+				t.Errorf("newConverter(%q) = %T, %v, want %T", tt.backend, got, err, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestBuildLibheifArgs(t *testing.T) {
+	tests := []struct {
+		outType string
+		quality int
+		want    []string
+	}{
+		{outType: "jpg", want: []string{"in.heic", "out.jpg"}},
+		{outType: "jpg", quality: 85, want: []string{"-q", "85", "in.heic", "out.jpg"}},
+		{outType: "jpeg", quality: 70, want: []string{"-q", "70", "in.heic", "out.jpg"}},
+		// heif-dec writes PNG losslessly, so -quality does not apply.
+		{outType: "png", quality: 85, want: []string{"in.heic", "out.jpg"}},
+	}
+	for _, tt := range tests {
+		setFlag(t, outType, tt.outType)
+		setFlag(t, jpegQuality, tt.quality)
+		if got := buildLibheifArgs("in.heic", "out.jpg"); !slices.Equal(got, tt.want) {
+			t.Errorf("buildLibheifArgs() with %s at quality %d = %q, want %q", tt.outType, tt.quality, got, tt.want)
+		}
+	}
+}
+
+func TestLibheifVerify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the libheif stubs are shell scripts")
+	}
+	tests := []struct {
+		name      string
+		installed []string
+		want      string
+	}{
+		{name: "newer release", installed: []string{"heif-dec", "heif-convert"}, want: "heif-dec"},
+		{name: "older release", installed: []string{"heif-convert"}, want: "heif-convert"},
+		{name: "not installed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, bin := range tt.installed {
+				if err := os.WriteFile(filepath.Join(dir, bin), []byte("#!/bin/sh\n"), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("PATH", dir)
+			setFlag(t, outType, "jpg")
+			setFlag(t, jpegQuality, 90)
+			c := &libheifConverter{}
+			err := c.Verify()
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("Verify() succeeded with %s", c.bin)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			name, args, err := c.Command("in.heic", "out.jpg")
+			if err != nil || name != tt.want || !slices.Equal(args, []string{"-q", "90", "in.heic", "out.jpg"}) {
+				t.Errorf("Command() = %s %q, %v, want %s -q 90 in.heic out.jpg", name, args, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// benchSampleSize is the size of the sample generated when bench is given none, that of a 12 MP photo.
+const benchSampleSize = "4032x3024"
+
+// benchRecord is what the bench subcommand reports for one backend and worker count.
+type benchRecord struct {
+	Backend     string  `json:"backend"`
+	Workers     int     `json:"workers"`
+	Files       int     `json:"files"`
+	DurationMS  int64   `json:"duration_ms"`
+	FilesPerSec float64 `json:"files_per_second"`
+	PeakRSS     int64   `json:"peak_rss_bytes,omitempty"`
+	OutputBytes int64   `json:"output_bytes"`
+	// SizeDelta is the output size relative to the first run that succeeded, in percent.
+	SizeDelta float64 `json:"size_delta_percent"`
+	// PSNR compares the first output with the first run's, in dB; "inf" means identical pixels.
+	PSNR  string `json:"psnr,omitempty"`
+	Error string `json:"error,omitempty"`
+
+	outDir string
+}
+
+// runBench runs the bench subcommand: it converts copies of the sample files with every backend and
+// worker count, each in a separate run of this program, and reports how fast each went, the peak memory
+// and how the outputs compare. Without samples it generates one with ImageMagick.
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	backends := fs.String("backends", "magick,libheif,native", "Comma-separated backends to compare; those that are not installed are reported as unavailable")
+	workerList := fs.String("workers", "1,2,4,auto", "Comma-separated worker counts to try, auto meaning one per CPU")
+	copies := fs.Int("copies", 8, "Copies of each sample converted per run, so that several workers have work to share")
+	format := fs.String("format", "jpg", "Output format to convert to")
+	asJSON := fs.Bool("json", false, "Print one JSON object per run instead of a table")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s bench [flags] [sample.heic...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	if *copies < 1 {
+		return withExitCode(exitUsage, errors.New("-copies must be at least 1"))
+	}
+	counts, err := parseWorkerList(*workerList)
+	if err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find this program: %v", err)
+	}
+
+	dir, err := os.MkdirTemp("", "convert_heic_bench_")
+	if err != nil {
+		return fmt.Errorf("failed to create bench directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	samples := fs.Args()
+	if len(samples) == 0 {
+		sample := filepath.Join(dir, "sample.heic")
+		fmt.Fprintf(infoOut, "INFO: Generating a %s sample HEIC.\n", benchSampleSize)
+		if out, err := magickCommand("convert", "-seed", "1", "-size", benchSampleSize, "plasma:", sample).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to generate a sample HEIC, pass one instead: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		samples = []string{sample}
+	}
+	inDir := filepath.Join(dir, "in")
+	files, err := copyBenchSamples(samples, inDir, *copies)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(infoOut, "INFO: Benchmarking %d files per run.\n", files)
+
+	ctx := interruptContext()
+	enc := json.NewEncoder(os.Stdout)
+	if !*asJSON {
+		fmt.Fprintf(os.Stdout, "%-8s %7s %6s %9s %8s %10s %10s %7s %7s\n", "BACKEND", "WORKERS", "FILES", "TIME", "FILES/S", "PEAK RSS", "OUTPUT", "SIZE", "PSNR")
+	}
+	var reference *benchRecord
+	for _, backend := range strings.Split(*backends, ",") {
+		backend = strings.TrimSpace(backend)
+		for i, w := range counts {
+			rec := benchRun(ctx, self, backend, w, inDir, filepath.Join(dir, fmt.Sprintf("out_%s_%d", backend, w)), *format)
+			if ctx.Err() != nil {
+				return errInterrupted
+			}
+			switch {
+			case rec.Error != "":
+			case reference == nil:
+				reference = &rec
+			default:
+				if reference.OutputBytes > 0 {
+					rec.SizeDelta = 100 * (float64(rec.OutputBytes) - float64(reference.OutputBytes)) / float64(reference.OutputBytes)
+				}
+				rec.PSNR = comparePSNR(reference.outDir, rec.outDir)
+			}
+			if *asJSON {
+				if err := enc.Encode(rec); err != nil {
+					return fmt.Errorf("failed to write JSON: %v", err)
+				}
+			} else {
+				printBenchRecord(rec)
+			}
+			if rec.Error != "" && i == 0 {
+				// A backend that failed its first run is missing or unusable; the other counts would fail too.
+				break
+			}
+		}
+	}
+	if reference == nil {
+		return errors.New("no backend completed a run")
+	}
+	return nil
+}
+
+// parseWorkerList parses a comma-separated -workers list, resolving auto to the CPU count and dropping
+// repeats.
+func parseWorkerList(list string) ([]int, error) {
+	var counts []int
+	seen := make(map[int]bool)
+	for _, item := range strings.Split(list, ",") {
+		var n int
+		if err := (workerCount{n: &n}).Set(strings.TrimSpace(item)); err != nil {
+			return nil, fmt.Errorf("invalid -workers %q: %v", item, err)
+		}
+		if n == 0 {
+			n = runtime.NumCPU()
+		}
+		if !seen[n] {
+			seen[n] = true
+			counts = append(counts, n)
+		}
+	}
+	return counts, nil
+}
+
+// copyBenchSamples copies each sample into dir copies times and returns the number of files written.
+// The copies are real files rather than hard links, which a directory run would convert only once.
+func copyBenchSamples(samples []string, dir string, copies int) (int, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return 0, fmt.Errorf("failed to create bench directory: %v", err)
+	}
+	n := 0
+	for i, sample := range samples {
+		data, err := os.ReadFile(sample)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read sample: %v", err)
+		}
+		base := strings.TrimSuffix(filepath.Base(sample), filepath.Ext(sample))
+		for c := 1; c <= copies; c++ {
+			name := fmt.Sprintf("%02d_%s_%02d%s", i+1, base, c, filepath.Ext(sample))
+			if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+				return 0, fmt.Errorf("failed to copy sample: %v", err)
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// benchRun converts inDir into outDir with one backend and worker count in a child run of self, isolated
+// from config files and CONVERT_HEIC_ variables, and reads the result from its JSON summary.
+func benchRun(ctx context.Context, self, backend string, workers int, inDir, outDir, format string) benchRecord {
+	rec := benchRecord{Backend: backend, Workers: workers, outDir: outDir}
+	cmd := exec.CommandContext(ctx, self, "convert",
+		"-input", inDir, "-output", format, "-outdir", outDir,
+		"-backend", backend, "-workers", strconv.Itoa(workers), "-json")
+	cmd.Dir = filepath.Dir(inDir)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, envPrefix) {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	start := time.Now()
+	runErr := cmd.Run()
+	elapsed := time.Since(start)
+	if cmd.ProcessState != nil {
+		rec.PeakRSS, _ = peakRSS(cmd.ProcessState)
+	}
+
+	var sum summaryRecord
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		var line summaryRecord
+		if json.Unmarshal(scanner.Bytes(), &line) == nil && line.Type == "summary" {
+			sum = line
+		}
+	}
+	if runErr != nil && sum.Converted == 0 {
+		rec.Error = lastErrorLine(&stderr, runErr)
+		return rec
+	}
+	rec.Files = sum.Converted
+	rec.DurationMS = elapsed.Milliseconds()
+	rec.FilesPerSec = float64(sum.Converted) / elapsed.Seconds()
+	rec.OutputBytes = sum.TargetBytes
+	if sum.Failed > 0 {
+		rec.Error = fmt.Sprintf("%d files failed: %s", sum.Failed, lastErrorLine(&stderr, runErr))
+	}
+	return rec
+}
+
+// lastErrorLine returns the last ERROR message a child run logged, or err.
+func lastErrorLine(stderr io.Reader, err error) string {
+	var last string
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		if _, msg, ok := strings.Cut(scanner.Text(), "ERROR: "); ok {
+			last = msg
+		}
+	}
+	if last == "" && err != nil {
+		return err.Error()
+	}
+	return last
+}
+
+// comparePSNR compares the first output in dir with the same file in refDir using ImageMagick, returning
+// the PSNR in dB, "inf" for identical pixels, or "" when the comparison could not run.
+func comparePSNR(refDir, dir string) string {
+	entries, err := os.ReadDir(refDir)
+	if err != nil || len(entries) == 0 {
+		return ""
+	}
+	name := entries[0].Name()
+	// compare exits with 1 when the images differ, which is the usual case, so only its output counts.
+	out, _ := magickCommand("compare", "-metric", "PSNR", filepath.Join(refDir, name), filepath.Join(dir, name), "null:").CombinedOutput()
+	fields := strings.Fields(string(out))
+	if len(fields) == 0 {
+		return ""
+	}
+	value := fields[0]
+	if value == "inf" {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err != nil {
+		return ""
+	}
+	return value
+}
+
+// printBenchRecord prints a record as one row of the bench table.
+func printBenchRecord(rec benchRecord) {
+	if rec.Error != "" && rec.Files == 0 {
+		fmt.Fprintf(os.Stdout, "%-8s %7d  unavailable: %s\n", rec.Backend, rec.Workers, rec.Error)
+		return
+	}
+	rss := "-"
+	if rec.PeakRSS > 0 {
+		rss = formatBytes(rec.PeakRSS)
+	}
+	psnr := "-"
+	if rec.PSNR != "" {
+		psnr = rec.PSNR
+	}
+	fmt.Fprintf(os.Stdout, "%-8s %7d %6d %9s %8.2f %10s %10s %+6.1f%% %7s\n",
+		rec.Backend, rec.Workers, rec.Files, (time.Duration(rec.DurationMS) * time.Millisecond).String(), rec.FilesPerSec,
+		rss, formatBytes(rec.OutputBytes), rec.SizeDelta, psnr)
+	if rec.Error != "" {
+		fmt.Fprintf(os.Stdout, "         %s\n", rec.Error)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// circuitBreaker trips when too many of the first files in a run fail, which usually means every
+// conversion is failing for the same reason and the rest of the batch is not worth attempting.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold float64
+	window    int
+	completed int
+	failed    int
+	tripped   bool
+}
+
+// newCircuitBreaker returns a breaker that trips when more than threshold of the first window files fail.
+// A zero threshold disables it.
+func newCircuitBreaker(threshold float64, window, total int) *circuitBreaker {
+	if window > total {
+		window = total
+	}
+	return &circuitBreaker{threshold: threshold, window: window}
+}
+
+// record counts one finished conversion and reports whether the breaker is now tripped.
+func (b *circuitBreaker) record(failed bool) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 || b.tripped || b.completed >= b.window {
+		return b.tripped
+	}
+	b.completed++
+	if failed {
+		b.failed++
+	}
+	// Trip as soon as the window's failure rate is guaranteed to exceed the threshold, rather than
+	// waiting for the whole window to finish.
+	if float64(b.failed) > b.threshold*float64(b.window) {
+		b.tripped = true
+	}
+	return b.tripped
+}
+
+// open reports whether the breaker has tripped and remaining files should be skipped.
+func (b *circuitBreaker) open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.tripped
+}
+
+// err describes why the breaker tripped.
+func (b *circuitBreaker) err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return fmt.Errorf("aborting: likely systemic failure, %d of the first %d files failed", b.failed, b.completed)
+}
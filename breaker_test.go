@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold float64
+		window    int
+		total     int
+		// results are the outcomes in order, true for a failure.
+		results []bool
+		// tripsAt is the index of the result that trips the breaker, or -1.
+		tripsAt int
+	}{
+		{name: "disabled", threshold: 0, window: 4, total: 10, results: []bool{true, true, true, true}, tripsAt: -1},
+		{name: "all failing", threshold: 0.5, window: 4, total: 10, results: []bool{true, true, true}, tripsAt: 2},
+		{name: "at the threshold", threshold: 0.5, window: 4, total: 10, results: []bool{true, false, true, false}, tripsAt: -1},
+		{name: "over the threshold", threshold: 0.5, window: 4, total: 10, results: []bool{false, true, true, true}, tripsAt: 3},
+		{name: "failures after the window", threshold: 0.5, window: 2, total: 10, results: []bool{false, false, true, true, true}, tripsAt: -1},
+		{name: "window shrunk to a small batch", threshold: 0.5, window: 10, total: 2, results: []bool{true, true}, tripsAt: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newCircuitBreaker(tt.threshold, tt.window, tt.total)
+			tripsAt := -1
+			for i, failed := range tt.results {
+				if b.record(failed) && tripsAt < 0 {
+					tripsAt = i
+				}
+			}
+			if tripsAt != tt.tripsAt {
+				t.Errorf("tripped at result %d, want %d", tripsAt, tt.tripsAt)
+			}
+			if b.open() != (tt.tripsAt >= 0) {
+				t.Errorf("open() = %v after %d results", b.open(), len(tt.results))
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerError(t *testing.T) {
+	b := newCircuitBreaker(0.5, 4, 10)
+	for _, failed := range []bool{true, false, true, true} {
+		b.record(failed)
+	}
+	if err := b.err(); !strings.Contains(err.Error(), "3 of the first 4 files failed") {
+		t.Errorf("err() = %v", err)
+	}
+}
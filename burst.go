@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// collectBursts groups the HEIC files under root by leaf directory, i.e. directories without subdirectories.
+func collectBursts(root string) (map[string][]string, error) {
+	bursts := make(map[string][]string)
+	hasSubdir := make(map[string]bool)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root {
+				hasSubdir[filepath.Dir(path)] = true
+			}
+			return nil
+		}
+		if isHeicSource(path) {
+			dir := filepath.Dir(path)
+			bursts[dir] = append(bursts[dir], path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk directory: %v", err)
+	}
+	for dir := range bursts {
+		if hasSubdir[dir] {
+			delete(bursts, dir)
+		}
+	}
+	return bursts, nil
+}
+
+// sharpness scores the primary image of inFile by the standard deviation of its Laplacian; higher is sharper.
+func sharpness(inFile string) (float64, error) {
+	output, err := magickCommand("convert", inFile+"[0]",
+		"-resize", "1024x1024>",
+		"-colorspace", "Gray",
+		"-define", "convolve:scale=!",
+		"-morphology", "Convolve", "Laplacian:0",
+		"-format", "%[fx:standard_deviation]", "info:").Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure sharpness of %s: %v", inFile, err)
+	}
+	score, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse sharpness of %s from %q: %v", inFile, output, err)
+	}
+	return score, nil
+}
+
+// pickSharpest returns the file with the highest sharpness score, preferring the first file on ties.
+func pickSharpest(files []string, score func(string) (float64, error)) (string, error) {
+	best, bestScore := "", -1.0
+	for _, file := range files {
+		s, err := score(file)
+		if err != nil {
+			return "", err
+		}
+		if s > bestScore {
+			best, bestScore = file, s
+		}
+	}
+	return best, nil
+}
+
+// processBursts converts one representative image from every burst directory under root.
+func processBursts(ctx context.Context, root string) error {
+	bursts, err := collectBursts(root)
+	if err != nil {
+		return err
+	}
+	if len(bursts) == 0 {
+		return errors.New("no HEIC files found in any leaf directory")
+	}
+
+	dirs := make([]string, 0, len(bursts))
+	for dir := range bursts {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var picks []string
+	for _, dir := range dirs {
+		files := bursts[dir]
+		sort.Strings(files)
+		pick, err := pickSharpest(files, sharpness)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(infoOut, "INFO: Picked %s from %d images in %s.\n", filepath.Base(pick), len(files), dir)
+		picks = append(picks, pick)
+	}
+	return processFileList(ctx, picks)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestPickSharpest(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []float64
+		want   int
+	}{
+		{name: "sharpest in the middle", scores: []float64{0.01, 0.08, 0.03}, want: 1},
+		{name: "first on ties", scores: []float64{0.05, 0.05, 0.02}, want: 0},
+		{name: "all zero", scores: []float64{0, 0}, want: 0},
+		{name: "single image", scores: []float64{0.4}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var files []string
+			scores := make(map[string]float64)
+			for i, s := range tt.scores {
+				file := filepath.Join("burst", string(rune('a'+i))+".heic")
+				files = append(files, file)
+				scores[file] = s
+			}
+			got, err := pickSharpest(files, func(file string) (float64, error) { return scores[file], nil })
+			if err != nil || got != files[tt.want] {
+				t.Errorf("pickSharpest() = %q, %v, want %q", got, err, files[tt.want])
+			}
+		})
+	}
+
+	failing := func(string) (float64, error) { return 0, errors.New("identify failed") }
+	if _, err := pickSharpest([]string{"a.heic"}, failing); err == nil {
+		t.Error("pickSharpest() ignored a scoring error")
+	}
+}
+
+func TestSharpnessStubbedBurst(t *testing.T) {
+	log := stubMagick(t)
+	dir := t.TempDir()
+	var files []string
+	for name, score := range map[string]string{"1.heic": "0.0213\n", "2.heic": "0.0871\n", "3.heic": "0.0402\n"} {
+		file := writeFile(t, dir, "burst/"+name, "x")
+		writeFile(t, dir, "burst/"+name+".info", score)
+		files = append(files, file)
+	}
+	slices.Sort(files)
+
+	got, err := pickSharpest(files, sharpness)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "burst", "2.heic"); got != want {
+		t.Errorf("pickSharpest() = %s, want %s", got, want)
+	}
+	if n := len(stubCommands(t, log)); n != len(files) {
+		t.Errorf("ran %d commands, want one sharpness measurement per image", n)
+	}
+
+	writeFile(t, dir, "burst/1.heic.info", "nan?\n")
+	if _, err := sharpness(files[0]); err == nil {
+		t.Error("sharpness() parsed an invalid score")
+	}
+}
+
+func TestCollectBursts(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"top.heic", "trip/day1/a.heic", "trip/day1/b.heic", "trip/day2/c.heic", "trip/notes.heic", "trip/day2/d.jpg"} {
+		writeFile(t, dir, name, "x")
+	}
+	bursts, err := collectBursts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		filepath.Join(dir, "trip", "day1"): {filepath.Join(dir, "trip", "day1", "a.heic"), filepath.Join(dir, "trip", "day1", "b.heic")},
+		filepath.Join(dir, "trip", "day2"): {filepath.Join(dir, "trip", "day2", "c.heic")},
+	}
+	if len(bursts) != len(want) {
+		t.Fatalf("collectBursts() = %q, want only the leaf directories %q", bursts, want)
+	}
+	for dir, files := range want {
+		if !slices.Equal(bursts[dir], files) {
+			t.Errorf("burst %s = %q, want %q", dir, bursts[dir], files)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cacheKey hashes the source content together with a canonical form of the conversion command, so any
+// option that changes the output (format, quality, processing flags, backend) yields a different key.
+// The input and output paths are replaced by placeholders so identical content converted the same way
+// hits the cache wherever it lives.
+func cacheKey(inFile, name string, args []string, outFile string) (string, error) {
+	h := sha256.New()
+	f, err := os.Open(inFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %v", inFile, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %v", inFile, err)
+	}
+
+	canonical := []string{name}
+	for _, arg := range args {
+		arg = strings.ReplaceAll(arg, outFile, "{out}")
+		arg = strings.ReplaceAll(arg, inFile, "{in}")
+		canonical = append(canonical, arg)
+	}
+	canonical = append(canonical, "target="+strconv.FormatInt(targetBytes, 10), "shrink="+strconv.FormatBool(*shrinkToFit))
+	for _, part := range canonical {
+		h.Write([]byte{0})
+		io.WriteString(h, part)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// cachePath returns where the cached output for key is stored.
+func cachePath(key string) string {
+	return filepath.Join(*cacheDir, key+"."+*outType)
+}
+
+// restoreFromCache copies the cached output for key to outFile, reporting whether there was one.
+func restoreFromCache(key, outFile string) (bool, error) {
+	src := cachePath(key)
+	if _, err := os.Stat(src); err != nil {
+		return false, nil
+	}
+	if err := copyFile(src, outFile); err != nil {
+		return false, fmt.Errorf("failed to restore %s from cache: %v", outFile, err)
+	}
+	return true, nil
+}
+
+// storeInCache saves outFile as the cached output for key. It writes to a temporary name first so a
+// concurrent reader never sees a partial entry.
+func storeInCache(key, outFile string) error {
+	dst := cachePath(key)
+	tmp := dst + ".tmp" + strconv.Itoa(os.Getpid())
+	if err := copyFile(outFile, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to cache %s: %v", outFile, err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to cache %s: %v", outFile, err)
+	}
+	return nil
+}
+
+// copyFile copies the contents of src to dst, replacing dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
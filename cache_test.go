@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	in := writeFile(t, dir, "a/IMG_1.heic", "pixels")
+	moved := writeFile(t, dir, "b/copy.heic", "pixels")
+	edited := writeFile(t, dir, "c/IMG_1.heic", "other pixels")
+	out := filepath.Join(dir, "a", "IMG_1.jpg")
+	args := []string{in + "[0]", "-auto-orient", "-quality", "85", out}
+	base, err := cacheKey(in, "convert", args, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		in     string
+		cmd    string
+		args   []string
+		out    string
+		target int64
+		same   bool
+	}{
+		{name: "same conversion", in: in, cmd: "convert", args: args, out: out, same: true},
+		{name: "same content elsewhere", in: moved, cmd: "convert", args: []string{moved + "[0]", "-auto-orient", "-quality", "85", "/elsewhere/copy.jpg"}, out: "/elsewhere/copy.jpg", same: true},
+		{name: "different content", in: edited, cmd: "convert", args: []string{edited + "[0]", "-auto-orient", "-quality", "85", out}, out: out},
+		{name: "different quality", in: in, cmd: "convert", args: []string{in + "[0]", "-auto-orient", "-quality", "90", out}, out: out},
+		{name: "different option order", in: in, cmd: "convert", args: []string{in + "[0]", "-quality", "85", "-auto-orient", out}, out: out},
+		{name: "different backend", in: in, cmd: "heif-convert", args: args, out: out},
+		{name: "different -target-size", in: in, cmd: "convert", args: args, out: out, target: 500 << 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, &targetBytes, tt.target)
+			key, err := cacheKey(tt.in, tt.cmd, tt.args, tt.out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (key == base) != tt.same {
+				t.Errorf("cacheKey() = %s, base key %s, want same = %v", key, base, tt.same)
+			}
+		})
+	}
+
+	if _, err := cacheKey(filepath.Join(dir, "missing.heic"), "convert", args, out); err == nil {
+		t.Error("cacheKey() of a missing source succeeded")
+	}
+}
+
+func TestCacheRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, cacheDir, filepath.Join(dir, "cache"))
+	setFlag(t, outType, "jpg")
+	if err := os.Mkdir(*cacheDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	out := writeFile(t, dir, "a.jpg", "encoded")
+	restored := filepath.Join(dir, "b.jpg")
+
+	if ok, err := restoreFromCache("k", restored); ok || err != nil {
+		t.Fatalf("restoreFromCache() on an empty cache = %v, %v", ok, err)
+	}
+	if err := storeInCache("k", out); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := restoreFromCache("k", restored); !ok || err != nil {
+		t.Fatalf("restoreFromCache() = %v, %v, want a hit", ok, err)
+	}
+	if data, err := os.ReadFile(restored); err != nil || string(data) != "encoded" {
+		t.Errorf("restored %q, %v, want the cached output", data, err)
+	}
+}
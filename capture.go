@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// exifTimeLayout is the layout of EXIF date-time tags such as DateTimeOriginal.
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// parseCaptureTime parses identify output of the form "DateTimeOriginal|OffsetTimeOriginal". Without an
+// offset the capture time is taken as local time, which is how cameras without one record it.
+func parseCaptureTime(output string) (time.Time, bool) {
+	date, offset, _ := strings.Cut(strings.TrimSpace(output), "|")
+	if date == "" {
+		return time.Time{}, false
+	}
+	if offset != "" {
+		if t, err := time.Parse(exifTimeLayout+"-07:00", date+offset); err == nil {
+			return t, true
+		}
+	}
+	t, err := time.ParseInLocation(exifTimeLayout, date, time.Local)
+	return t, err == nil
+}
+
+// captureTime reads the EXIF capture time of inFile. The boolean is false when the source has none. The
+// time comes from the EXIF item parsed in-process, so no command runs per file; only ImageMagick is asked,
+// through identify, for sources the parser cannot read.
+func captureTime(ctx context.Context, inFile string) (time.Time, bool, error) {
+	if info, err := parseHEIF(inFile); err == nil {
+		captured, _ := exifFields(info.Exif)
+		t, ok := parseCaptureTime(captured)
+		return t, ok, nil
+	}
+	if _, ok := converter.(magickConverter); !ok {
+		return time.Time{}, false, nil
+	}
+	output, err := magickCommandContext(ctx, "identify", "-format", "%[EXIF:DateTimeOriginal]|%[EXIF:OffsetTimeOriginal]", inFile+"[0]").Output()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to read the capture time of %s: %v", inFile, err)
+	}
+	t, ok := parseCaptureTime(string(output))
+	return t, ok, nil
+}
+
+// keepCaptureTime reports whether outputs get their source's capture time as modification time. It is
+// off when metadata is stripped, and with -skip-if-output-newer or -skip-existing mtime, which rely on
+// outputs being newer than their sources.
+func keepCaptureTime() bool {
+	return !*stripMeta && !*skipIfNewer && *skipExisting != "mtime"
+}
+
+// warnDroppedMetadata warns once when the backend in use writes outputs without the source's EXIF and
+// XMP metadata, which every other backend carries over unless -strip is set.
+func warnDroppedMetadata() {
+	if _, ok := converter.(nativeConverter); ok {
+		fmt.Fprintln(os.Stdout, "WARN: -backend native writes outputs without the EXIF and XMP metadata of their sources; only the capture time is kept, as the modification time.")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// srgbProfileLocations are where common systems install an sRGB ICC profile, tried in order when
+// -convert-to-srgb is set without -srgb-profile.
+var srgbProfileLocations = []string{
+	"/usr/share/color/icc/sRGB.icc",
+	"/usr/share/color/icc/colord/sRGB.icc",
+	"/usr/share/color/icc/ghostscript/srgb.icc",
+	"/usr/share/ghostscript/iccprofiles/srgb.icc",
+	"/System/Library/ColorSync/Profiles/sRGB Profile.icc",
+	`C:\Windows\System32\spool\drivers\color\sRGB Color Space Profile.icm`,
+}
+
+// validateColorFlags resolves the sRGB profile for -convert-to-srgb.
+func validateColorFlags() error {
+	if *srgbProfile != "" && !*convertToSRGB {
+		return errors.New("-srgb-profile requires -convert-to-srgb")
+	}
+	if !*convertToSRGB {
+		return nil
+	}
+	if *srgbProfile != "" {
+		if _, err := os.Stat(*srgbProfile); err != nil {
+			return fmt.Errorf("invalid -srgb-profile: %v", err)
+		}
+		return nil
+	}
+	for _, path := range srgbProfileLocations {
+		if _, err := os.Stat(path); err == nil {
+			*srgbProfile = path
+			return nil
+		}
+	}
+	return errors.New("-convert-to-srgb found no sRGB ICC profile on this system, pass one with -srgb-profile")
+}
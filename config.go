@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultConfigName is the config file loaded from the working directory when -config is not provided,
+// so a project directory can carry its own profile.
+const defaultConfigName = "convert_heic.yaml"
+
+// envPrefix starts the environment variables that override flag defaults, such as CONVERT_HEIC_WORKERS.
+const envPrefix = "CONVERT_HEIC_"
+
+// configUsage describes subcommands and the sources of flag defaults for the usage text.
+const configUsage = `
+Subcommands:
+  convert  convert the -input sources (the default without a subcommand)
+  watch    convert, then keep watching the -input directory (same as -watch)
+  serve    run an HTTP API that converts uploaded HEICs
+  inspect  print what a HEIC contains without converting it
+  bench    compare the speed, memory and output of backends and worker counts
+
+Defaults:
+  Flags not given on the command line are read from CONVERT_HEIC_<FLAG> environment
+  variables (e.g. CONVERT_HEIC_WORKERS, CONVERT_HEIC_SERVE_ADDR for serve), then from the
+  -config file. Its keys are flag names; a serve: or watch: section applies to that
+  subcommand only.
+`
+
+// subcommands are the commands accepted as the first argument. Without one, the arguments are convert flags.
+var subcommands = map[string]bool{
+	"convert": true,
+	"watch":   true,
+	"serve":   true,
+	"inspect": true,
+	"bench":   true,
+}
+
+// splitSubcommand returns the subcommand named by the first argument, or convert, and the remaining arguments.
+func splitSubcommand(args []string) (string, []string) {
+	if len(args) > 0 && subcommands[args[0]] {
+		return args[0], args[1:]
+	}
+	return "convert", args
+}
+
+// configSettings holds a parsed config file: flag values by flag name, per section. Top-level keys are in
+// the "" section and apply to convert and watch; a section named after a subcommand applies to it alone.
+type configSettings map[string]map[string][]string
+
+// applySettings fills in the flags of set that were not given on the command line, first from the
+// environment and then from the config file, so the command line wins over both and the environment over
+// the file. section names the subcommand whose settings apply, and path is -config. It returns the config
+// file that was read, if any.
+func applySettings(set *flag.FlagSet, section, path string) (string, error) {
+	given := make(map[string]bool)
+	set.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	prefix := envPrefix
+	if section == "serve" {
+		prefix += "SERVE_"
+	}
+	var err error
+	set.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] || f.Name == "config" {
+			return
+		}
+		name := prefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(name); ok {
+			if setErr := set.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid %s=%q: %v", name, value, setErr)
+			}
+			given[f.Name] = true
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if path == "" {
+		if _, statErr := os.Stat(defaultConfigName); statErr != nil {
+			return "", nil
+		}
+		path = defaultConfigName
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("config file %s does not exist", path)
+		}
+		return "", fmt.Errorf("failed to read config file: %v", err)
+	}
+	settings, err := parseConfig(string(data))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	var layers []map[string][]string
+	if section != "serve" {
+		layers = append(layers, settings[""])
+	}
+	layers = append(layers, settings[section])
+	merged := make(map[string][]string)
+	for _, layer := range layers {
+		for name, values := range layer {
+			merged[name] = values
+		}
+	}
+	for name, values := range merged {
+		if set.Lookup(name) == nil {
+			return "", fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if given[name] {
+			continue
+		}
+		for _, value := range values {
+			if err := set.Set(name, value); err != nil {
+				return "", fmt.Errorf("%s: invalid %s %q: %v", path, name, value, err)
+			}
+		}
+	}
+	return path, nil
+}
+
+// parseConfig parses the small YAML subset config files use: "key: value" lines, inline "[a, b]" or
+// "- item" lists for repeatable flags, one level of indented sections, quoted strings and # comments.
+func parseConfig(data string) (configSettings, error) {
+	settings := configSettings{"": {}}
+	var section, listKey string
+	for i, raw := range strings.Split(data, "\n") {
+		line := stripComment(strings.TrimRight(raw, " \r"))
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", i+1)
+		}
+		indented := line[0] == ' '
+		line = strings.TrimSpace(line)
+
+		if item, ok := strings.CutPrefix(line, "- "); ok || line == "-" {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", i+1)
+			}
+			target := settings[section]
+			if !indented && section != "" {
+				return nil, fmt.Errorf("line %d: list item outside its section", i+1)
+			}
+			target[listKey] = append(target[listKey], unquote(strings.TrimSpace(item)))
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", i+1)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !indented {
+			section = ""
+		} else if section == "" {
+			return nil, fmt.Errorf("line %d: unexpected indentation", i+1)
+		}
+		if value == "" {
+			if !indented && subcommands[key] {
+				// A subcommand's own section of settings.
+				section, listKey = key, ""
+				if settings[section] == nil {
+					settings[section] = map[string][]string{}
+				}
+				continue
+			}
+			listKey = key
+			continue
+		}
+		listKey = ""
+		settings[section][key] = parseConfigValue(value)
+	}
+	return settings, nil
+}
+
+// parseConfigValue returns the values of a setting: the items of an inline [a, b] list, or the value itself.
+func parseConfigValue(value string) []string {
+	inner, ok := strings.CutPrefix(value, "[")
+	if !ok || !strings.HasSuffix(inner, "]") {
+		return []string{unquote(value)}
+	}
+	var values []string
+	for _, item := range strings.Split(strings.TrimSuffix(inner, "]"), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, unquote(item))
+		}
+	}
+	return values
+}
+
+// stripComment removes a # comment that starts the line or follows a space outside quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" :[,", line[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquote removes matching single or double quotes around a value.
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		if s, err := strconv.Unquote(value); err == nil {
+			return s
+		}
+	}
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	}
+	return value
+}
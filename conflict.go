@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// claimedOutputs holds the renamed output paths handed out by -rename-on-conflict during this run,
+// so two workers never pick the same free name.
+var claimedOutputs struct {
+	sync.Mutex
+	paths map[string]struct{}
+}
+
+// validateConflictFlags checks -skip-existing against -overwrite and -rename-on-conflict.
+func validateConflictFlags() error {
+	switch *skipExisting {
+	case "any", "mtime":
+	case "checksum":
+		if *registryPath == "" {
+			return errors.New("-skip-existing checksum requires -output-registry, which records the source checksum of every output")
+		}
+	default:
+		return fmt.Errorf("invalid -skip-existing %q. Use 'any', 'mtime' or 'checksum'", *skipExisting)
+	}
+	if *overwrite && *renameOnConflict {
+		return errors.New("-overwrite and -rename-on-conflict cannot be used together")
+	}
+	if flagSet("skip-existing") && (*overwrite || *renameOnConflict) {
+		return errors.New("-skip-existing cannot be used with -overwrite or -rename-on-conflict")
+	}
+	return nil
+}
+
+// resolveExisting decides what to do when outFile may already exist. It returns the path to write, which
+// differs from outFile under -rename-on-conflict, and a skip status when the source should not be converted.
+func resolveExisting(inFile, outFile string, inInfo os.FileInfo) (string, string, error) {
+	if *overwrite {
+		return outFile, "", nil
+	}
+	if *renameOnConflict {
+		free, err := freeOutputPath(outFile)
+		return free, "", err
+	}
+	outInfo, err := statOutput(outFile)
+	if err != nil {
+		return outFile, "", nil
+	}
+	switch *skipExisting {
+	case "mtime":
+		if inInfo != nil && inInfo.ModTime().After(outInfo.ModTime()) {
+			fmt.Fprintf(infoOut, "INFO: Reconverting %s, it changed after %s was written.\n", inFile, outFile)
+			return outFile, "", nil
+		}
+	case "checksum":
+		sum, err := hashFile(inFile)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to hash %s: %v", inFile, err)
+		}
+		outputRegistry.Lock()
+		prev, ok := outputRegistry.entries[targetName(outFile)]
+		outputRegistry.Unlock()
+		if !ok || prev.SHA256 != sum {
+			fmt.Fprintf(infoOut, "INFO: Reconverting %s, its checksum does not match the one %s was written from.\n", inFile, outFile)
+			return outFile, "", nil
+		}
+	default:
+		fmt.Fprintf(infoOut, "INFO: Skipping %s, output %s already exists.\n", inFile, outFile)
+		return outFile, statusExists, nil
+	}
+	fmt.Fprintf(infoOut, "INFO: Skipping %s, it is unchanged since %s was written.\n", inFile, outFile)
+	return outFile, statusUpToDate, nil
+}
+
+// freeOutputPath returns outFile, or when it exists or was already handed out, the first free
+// <base>_N<ext> next to it that planOutputNames did not give to another source.
+func freeOutputPath(outFile string) (string, error) {
+	claimedOutputs.Lock()
+	defer claimedOutputs.Unlock()
+	if claimedOutputs.paths == nil {
+		claimedOutputs.paths = make(map[string]struct{})
+	}
+	for n := 0; n < 10000; n++ {
+		candidate := outFile
+		if n > 0 {
+			candidate = numberedPath(outFile, n)
+		}
+		if _, claimed := claimedOutputs.paths[candidate]; claimed {
+			continue
+		}
+		if _, planned := claimedNames[strings.ToLower(candidate)]; planned && n > 0 {
+			// planOutputNames gave this name to another source.
+			continue
+		}
+		if _, err := os.Lstat(candidate); err == nil {
+			continue
+		}
+		if _, uploaded := uploadedOutput(candidate); uploaded {
+			continue
+		}
+		claimedOutputs.paths[candidate] = struct{}{}
+		if n > 0 {
+			fmt.Fprintf(infoOut, "INFO: Output %s already exists, writing %s instead.\n", outFile, candidate)
+		}
+		return candidate, nil
+	}
+	return "", fmt.Errorf("failed to find a free name for %s", outFile)
+}
@@ -0,0 +1,21 @@
+package convert
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TempOutput creates an empty, hidden temporary file next to outFile for a conversion to write to before
+// it is renamed into place. It keeps outFile's extension, which ImageMagick picks the output format from.
+func TempOutput(outFile string) (string, error) {
+	ext := filepath.Ext(outFile)
+	stem := strings.TrimSuffix(filepath.Base(outFile), ext)
+	f, err := os.CreateTemp(filepath.Dir(outFile), "."+stem+".*.tmp"+ext)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary output for %s: %v", outFile, err)
+	}
+	f.Close()
+	return f.Name(), nil
+}
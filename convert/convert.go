@@ -0,0 +1,254 @@
+// Package convert converts HEIC images with ImageMagick. It is the engine behind the Convert_HEIC
+// command, for programs that want to convert HEIC files without running the command itself.
+package convert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values of Options.PNGCompression other than the levels 1 to 9, following image/png's CompressionLevel.
+const (
+	// DefaultPNGCompression keeps ImageMagick's default level.
+	DefaultPNGCompression = 0
+	// NoPNGCompression selects level 0, the fastest, which stores the pixels uncompressed.
+	NoPNGCompression = -1
+)
+
+// Options controls how a Converter encodes its outputs. The zero value of every field except Format
+// keeps ImageMagick's default behavior. The Convert_HEIC command builds its command lines from the same
+// Options, so a Converter encodes like the command given the matching flags.
+type Options struct {
+	// Format is the output format: png, jpg, jpeg, webp, avif, tiff or bmp.
+	Format string
+	// Quality is the JPEG or HEIC quality from 1 to 100, or 0 for ImageMagick's default.
+	Quality int
+	// PNGCompression is the zlib compression level from 1 to 9 for PNG output, NoPNGCompression for
+	// level 0, or DefaultPNGCompression.
+	PNGCompression int
+	// Resize fits outputs within a WxH box keeping the aspect ratio, e.g. "1920x1080" or "1920x".
+	Resize string
+	// MaxDimension shrinks outputs whose longer side exceeds it.
+	MaxDimension int
+	// AutoOrient rotates the pixels to match the EXIF orientation. The command does this by default.
+	AutoOrient bool
+	// SRGBProfile is the path of an sRGB ICC profile to convert the pixels into, for viewers that ignore
+	// color profiles. Empty keeps the source's color space and profile, Display P3 on iPhones.
+	SRGBProfile string
+	// Strip removes EXIF, IPTC and XMP metadata, keeping the ICC color profile so colors still show
+	// correctly.
+	Strip bool
+	// StripProfile removes the ICC color profile; with Strip, outputs carry no metadata at all.
+	StripProfile bool
+	// Workers is the number of parallel conversions run by ConvertFiles; below 1 means one.
+	Workers int
+}
+
+// Result describes the outcome of converting one file.
+type Result struct {
+	Source     string
+	Target     string
+	Duration   time.Duration
+	SourceSize int64
+	TargetSize int64
+	// Err is nil when the conversion succeeded.
+	Err error
+}
+
+// Converter converts HEIC files with the installed ImageMagick. It is safe for concurrent use.
+type Converter struct {
+	opts    Options
+	bin     string
+	version string
+}
+
+// validFormats are the output formats a Converter accepts.
+var validFormats = map[string]struct{}{
+	"png":  {},
+	"jpg":  {},
+	"jpeg": {},
+	"webp": {},
+	"avif": {},
+	"tiff": {},
+	"bmp":  {},
+}
+
+// New checks opts and that ImageMagick is installed, can read HEIC and can write opts.Format.
+func New(opts Options) (*Converter, error) {
+	opts.Format = strings.ToLower(opts.Format)
+	if _, ok := validFormats[opts.Format]; !ok {
+		return nil, fmt.Errorf("unsupported output format %q", opts.Format)
+	}
+	if opts.Quality < 0 || opts.Quality > 100 {
+		return nil, fmt.Errorf("invalid quality %d, must be between 1 and 100, or 0 for the default", opts.Quality)
+	}
+	if opts.PNGCompression < NoPNGCompression || opts.PNGCompression > 9 {
+		return nil, fmt.Errorf("invalid PNG compression %d, must be between 1 and 9, NoPNGCompression or DefaultPNGCompression", opts.PNGCompression)
+	}
+	if opts.Resize != "" && opts.MaxDimension > 0 {
+		return nil, errors.New("Resize and MaxDimension cannot be used together")
+	}
+
+	bin, err := FindMagick()
+	if err != nil {
+		return nil, err
+	}
+	c := &Converter{opts: opts, bin: bin}
+	output, err := c.command(context.Background(), "convert", "--version").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run '%s --version': %v", bin, err)
+	}
+	if !strings.Contains(strings.ToLower(string(output)), "heic") {
+		return nil, fmt.Errorf("ImageMagick '%s' does not support HEIC", bin)
+	}
+	c.version = ParseVersion(string(output))
+	formats, err := c.command(context.Background(), "convert", "-list", "format").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run '%s -list format': %v", bin, err)
+	}
+	if !ParseFormatList(string(formats))[FormatName(opts.Format)].Write {
+		return nil, fmt.Errorf("ImageMagick cannot write %s output", opts.Format)
+	}
+	return c, nil
+}
+
+// Version returns the ImageMagick release the Converter runs, such as "6.9.12-98", or "" if unknown.
+func (c *Converter) Version() string {
+	return c.version
+}
+
+// command returns a cancelable command running an ImageMagick tool.
+func (c *Converter) command(ctx context.Context, tool string, args ...string) *exec.Cmd {
+	name, args := Tool(c.bin, tool, args...)
+	return CommandContext(ctx, name, args...)
+}
+
+// Args returns the convert arguments that turn inFile into outFile with the Converter's options.
+func (c *Converter) Args(inFile, outFile string) []string {
+	args := append([]string{inFile}, c.opts.Args()...)
+	// The format prefix keeps the output format independent of outFile's extension.
+	return append(args, c.opts.Format+":"+outFile)
+}
+
+// Args returns the convert options that apply o to a decoded image, to be placed between the source and
+// the output.
+func (o Options) Args() []string {
+	var args []string
+	if o.AutoOrient {
+		// Rotate first, so every later option sees the upright image.
+		args = append(args, "-auto-orient")
+	}
+	if o.SRGBProfile != "" {
+		// Untagged sources are taken to be sRGB already, so the profile is simply assigned to them.
+		args = append(args, "-intent", "Perceptual", "-profile", o.SRGBProfile)
+	}
+	switch {
+	case o.Strip && o.StripProfile:
+		args = append(args, "-strip")
+	case o.Strip:
+		// Drop every profile except the ICC one, which is needed to show the colors correctly.
+		args = append(args, "+profile", "!icc,*")
+	case o.StripProfile:
+		args = append(args, "+profile", "icc")
+	}
+	switch {
+	case o.Resize != "":
+		args = append(args, "-resize", o.Resize)
+	case o.MaxDimension > 0:
+		args = append(args, "-resize", fmt.Sprintf("%dx%d>", o.MaxDimension, o.MaxDimension))
+	}
+	if o.PNGCompression != DefaultPNGCompression && o.Format == "png" {
+		args = append(args, "-define", "png:compression-level="+strconv.Itoa(max(o.PNGCompression, 0)))
+	}
+	if o.Quality > 0 && (FormatName(o.Format) == "JPEG" || o.Format == "heic") {
+		args = append(args, "-quality", strconv.Itoa(o.Quality))
+	}
+	return args
+}
+
+// OutputPath returns the default output for inFile: the same path with the output format's extension.
+func (c *Converter) OutputPath(inFile string) string {
+	return strings.TrimSuffix(inFile, filepath.Ext(inFile)) + "." + c.opts.Format
+}
+
+// Convert converts inFile to outFile. The output is written to a temporary file and renamed into place
+// on success, so a failed or canceled conversion never leaves a truncated outFile behind.
+func (c *Converter) Convert(ctx context.Context, inFile, outFile string) Result {
+	start := time.Now()
+	res := Result{Source: inFile, Target: outFile}
+	res.Err = c.convert(ctx, &res)
+	res.Duration = time.Since(start)
+	return res
+}
+
+func (c *Converter) convert(ctx context.Context, res *Result) error {
+	info, err := os.Stat(res.Source)
+	if err != nil {
+		return err
+	}
+	res.SourceSize = info.Size()
+	tmp, err := TempOutput(res.Target)
+	if err != nil {
+		return err
+	}
+	cmd := c.command(ctx, "convert", c.Args(res.Source, tmp)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(tmp)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to convert %s: %v: %s", res.Source, err, strings.TrimSpace(stderr.String()))
+	}
+	if err := os.Rename(tmp, res.Target); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to move the output of %s into place: %v", res.Source, err)
+	}
+	if info, err := os.Stat(res.Target); err == nil {
+		res.TargetSize = info.Size()
+	}
+	return nil
+}
+
+// ConvertFiles converts every file next to its source, running Options.Workers conversions at a time,
+// and calls onResult as each one finishes. onResult may be nil and is never called concurrently. Files
+// not yet started when ctx is done are skipped; the returned error is ctx's error in that case.
+func (c *Converter) ConvertFiles(ctx context.Context, files []string, onResult func(Result)) error {
+	workers := max(c.opts.Workers, 1)
+	fileCh := make(chan string)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range fileCh {
+				res := c.Convert(ctx, file, c.OutputPath(file))
+				if onResult != nil {
+					mu.Lock()
+					onResult(res)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		fileCh <- file
+	}
+	close(fileCh)
+	wg.Wait()
+	return ctx.Err()
+}
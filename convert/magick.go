@@ -0,0 +1,106 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// StopGracePeriod is how long a canceled command gets to exit after SIGTERM before it is killed.
+const StopGracePeriod = 5 * time.Second
+
+// FindMagick returns the ImageMagick entry point: "magick" for ImageMagick 7, where convert, identify and
+// montage are subcommands, or "convert" for ImageMagick 6. magick is preferred because ImageMagick 7's
+// legacy convert warns that it is deprecated on every run. On Windows only magick is considered, as
+// convert.exe there is the system's FAT to NTFS conversion tool.
+func FindMagick() (string, error) {
+	candidates := []string{"magick", "convert"}
+	if runtime.GOOS == "windows" {
+		candidates = []string{"magick"}
+	}
+	for _, bin := range candidates {
+		if _, err := exec.LookPath(bin); err == nil {
+			return bin, nil
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return "", errors.New("the 'magick' command does not exist, please ensure that ImageMagick 7 is installed and accessible via PATH")
+	}
+	return "", errors.New("neither the 'magick' nor the 'convert' command exists, please ensure that ImageMagick is installed and accessible via PATH")
+}
+
+// Tool returns the program and arguments that run an ImageMagick tool such as convert or identify
+// through the entry point bin returned by FindMagick.
+func Tool(bin, tool string, args ...string) (string, []string) {
+	if bin == "magick" {
+		return "magick", append([]string{tool}, args...)
+	}
+	return tool, args
+}
+
+// ParseVersion extracts the release, such as "6.9.12-98", from 'convert --version' output.
+func ParseVersion(output string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(line), "Version: ImageMagick "); ok {
+			if fields := strings.Fields(rest); len(fields) > 0 {
+				return fields[0]
+			}
+		}
+	}
+	return ""
+}
+
+// FormatMode is the read/write support ImageMagick reports for one format in `-list format`.
+type FormatMode struct {
+	Read, Write bool
+}
+
+// modePattern matches the mode column of `-list format`, e.g. "rw+" or "r--".
+var modePattern = regexp.MustCompile(`^[r-][w-][+-]$`)
+
+// ParseFormatList extracts per-format read/write support from `convert -list format` output, keyed by
+// upper-case format name.
+func ParseFormatList(output string) map[string]FormatMode {
+	formats := make(map[string]FormatMode)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if modePattern.MatchString(field) {
+				name := strings.ToUpper(strings.TrimSuffix(fields[0], "*"))
+				formats[name] = FormatMode{Read: field[0] == 'r', Write: field[1] == 'w'}
+				break
+			}
+		}
+	}
+	return formats
+}
+
+// FormatName returns the name ImageMagick lists an output format, such as "jpg", under in '-list format'.
+func FormatName(format string) string {
+	if format == "jpg" || format == "jpeg" {
+		return "JPEG"
+	}
+	return strings.ToUpper(format)
+}
+
+// CommandContext is like exec.CommandContext, but a canceled command first gets SIGTERM, letting
+// ImageMagick remove its temporary files and any delegate processes it started, and is only killed when
+// it is still running StopGracePeriod later. Windows has no SIGTERM, so there it is killed right away.
+func CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if runtime.GOOS != "windows" {
+		cmd.Cancel = func() error {
+			return cmd.Process.Signal(syscall.SIGTERM)
+		}
+	}
+	cmd.WaitDelay = StopGracePeriod
+	return cmd
+}
@@ -0,0 +1,62 @@
+package convert
+
+import "testing"
+
+func TestParseFormatList(t *testing.T) {
+	output := `   Format  Module    Mode  Description
+-------------------------------------------------------------------------------
+      AVIF  HEIC      rw+   AV1 Image File Format (1.17.6)
+      HEIC* HEIC      r--   High Efficiency Image Format (1.17.6)
+      JPEG* JPEG      rw-   Joint Photographic Experts Group JFIF format (80)
+       png* PNG       rw+   Portable Network Graphics (libpng 1.6.43)
+      WEBP  WEBP      -w+   WebP Image Format (libwebp 1.4.0 [020F])
+
+See 'identify -list coder' to list the coders.
+`
+	formats := ParseFormatList(output)
+	tests := []struct {
+		name string
+		want FormatMode
+		ok   bool
+	}{
+		{"AVIF", FormatMode{Read: true, Write: true}, true},
+		{"HEIC", FormatMode{Read: true}, true},
+		{"JPEG", FormatMode{Read: true, Write: true}, true},
+		{"PNG", FormatMode{Read: true, Write: true}, true},
+		{"WEBP", FormatMode{Write: true}, true},
+		{"TIFF", FormatMode{}, false},
+		{"FORMAT", FormatMode{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := formats[tt.name]
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("formats[%q] = %+v, %v, want %+v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFormatName(t *testing.T) {
+	for format, want := range map[string]string{"jpg": "JPEG", "jpeg": "JPEG", "png": "PNG", "avif": "AVIF"} {
+		if got := FormatName(format); got != want {
+			t.Errorf("FormatName(%q) = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"Version: ImageMagick 6.9.12-98 Q16 x86_64 18038 https://legacy.imagemagick.org\nCopyright: (C) 1999 ImageMagick Studio LLC\n", "6.9.12-98"},
+		{"Version: ImageMagick 7.1.1-29 Q16-HDRI aarch64 21991 https://imagemagick.org\nFeatures: Cipher DPC HDRI\n", "7.1.1-29"},
+		{"  Version: ImageMagick 7.1.0-62\r\n", "7.1.0-62"},
+		{"GraphicsMagick 1.3.42 2023-09-23 Q16\n", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := ParseVersion(tt.output); got != tt.want {
+			t.Errorf("ParseVersion(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
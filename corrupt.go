@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// errCorruptInput marks a source that could not be read or decoded, as opposed to other conversion failures.
+var errCorruptInput = errors.New("input file is unreadable or corrupt")
+
+// corruptMarkers are lowercase fragments of ImageMagick and libheif diagnostics that indicate a damaged
+// or unreadable source rather than a problem with the options or the output location.
+var corruptMarkers = []string{
+	"corrupt",
+	"improper image header",
+	"insufficient image data",
+	"unexpected end-of-file",
+	"unexpected end of file",
+	"premature end",
+	"invalid input",
+	"input/output error",
+	"read error",
+	"no images defined",
+}
+
+// isCorruptRead reports whether a failed conversion's stderr describes an unreadable source.
+func isCorruptRead(stderr string) bool {
+	stderr = strings.ToLower(stderr)
+	for _, marker := range corruptMarkers {
+		if strings.Contains(stderr, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// corruptLog is the open -collect-corrupt file, or nil when corrupt sources are not collected.
+var corruptLog struct {
+	sync.Mutex
+	file  *os.File
+	count int
+}
+
+// openCorruptLog creates the -collect-corrupt file.
+func openCorruptLog(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create corrupt file list: %v", err)
+	}
+	corruptLog.file = f
+	return nil
+}
+
+// closeCorruptLog closes the -collect-corrupt file and reports how many sources it lists.
+func closeCorruptLog() {
+	if corruptLog.file == nil {
+		return
+	}
+	corruptLog.file.Close()
+	if corruptLog.count > 0 {
+		fmt.Fprintf(infoOut, "INFO: %d unreadable sources listed in %s.\n", corruptLog.count, corruptLog.file.Name())
+	}
+}
+
+// writeCorrupt appends inFile to the -collect-corrupt file. It is safe for concurrent use by workers.
+func writeCorrupt(inFile string) error {
+	if corruptLog.file == nil {
+		return nil
+	}
+	corruptLog.Lock()
+	defer corruptLog.Unlock()
+	if _, err := fmt.Fprintln(corruptLog.file, inFile); err != nil {
+		return fmt.Errorf("failed to record corrupt source %s: %v", inFile, err)
+	}
+	corruptLog.count++
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsCorruptRead(t *testing.T) {
+	tests := []struct {
+		stderr string
+		want   bool
+	}{
+		{"convert: improper image header `IMG_1.heic' @ error/heic.c/ReadHEICImage/511.", true},
+		{"convert: Invalid input: No 'ftyp' box (2.101) `IMG_1.heic'", true},
+		{"convert: Corrupt image `IMG_1.heic' @ error/heic.c/IsHEIFSuccess/139.", true},
+		{"convert: unexpected end-of-file `IMG_1.heic': No such file or directory", true},
+		{"convert: no images defined `IMG_1.jpg' @ error/convert.c/ConvertImageCommand/3342.", true},
+		{"Input/output error", true},
+		{"convert: unable to open image `/out/IMG_1.jpg': Permission denied @ error/blob.c/OpenBlob/3596.", false},
+		{"convert: unrecognized option `-qualty' @ error/convert.c/ConvertImageCommand/2998.", false},
+		{"convert: memory allocation failed `IMG_1.heic' @ error/heic.c/ReadHEICImage/620.", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isCorruptRead(tt.stderr); got != tt.want {
+			t.Errorf("isCorruptRead(%q) = %v, want %v", tt.stderr, got, tt.want)
+		}
+	}
+}
+
+func TestCollectCorrupt(t *testing.T) {
+	stubMagick(t)
+	dir := t.TempDir()
+	setFlag(t, outType, "jpg")
+	good := writeFile(t, dir, "good.heic", "x")
+	corrupt := writeFile(t, dir, "corrupt.heic", "x")
+	writeFile(t, dir, "corrupt.heic.fail", "convert: improper image header `corrupt.heic' @ error/heic.c/ReadHEICImage/511.\n")
+	denied := writeFile(t, dir, "denied.heic", "x")
+	writeFile(t, dir, "denied.heic.fail", "convert: unable to open image `denied.jpg': Permission denied @ error/blob.c/OpenBlob/3596.\n")
+	list := filepath.Join(dir, "corrupt.txt")
+	if err := openCorruptLog(list); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		closeCorruptLog()
+		corruptLog.file, corruptLog.count = nil, 0
+	})
+
+	tests := []struct {
+		file    string
+		fails   bool
+		corrupt bool
+	}{
+		{file: good},
+		{file: corrupt, fails: true, corrupt: true},
+		{file: denied, fails: true},
+	}
+	for _, tt := range tests {
+		res := fileResult{Source: tt.file, Target: strings.TrimSuffix(tt.file, ".heic") + ".jpg", Status: statusConverted}
+		name, args, err := converter.Command(res.Source, res.Target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := runConversion(context.Background(), &res, name, args); err != nil {
+			res.Status, res.Err = statusFailed, err
+		}
+		if (res.Err != nil) != tt.fails || errors.Is(res.Err, errCorruptInput) != tt.corrupt {
+			t.Errorf("runConversion(%s) = %v, want failure = %v, corrupt = %v", filepath.Base(tt.file), res.Err, tt.fails, tt.corrupt)
+		}
+		if err := recordResult(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if corruptLog.count != 1 {
+		t.Errorf("listed %d corrupt sources, want 1", corruptLog.count)
+	}
+	if err := corruptLog.file.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(list); err != nil || string(data) != corrupt+"\n" {
+		t.Errorf("corrupt list = %q, %v, want only %s", data, err, corrupt)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "good.jpg")); err != nil {
+		t.Errorf("the readable source was not converted: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// duplicate is a source left out of the run because it has the same content as canonical.
+type duplicate struct {
+	source    string
+	canonical string
+	// match says how the two relate, such as "identical to", for the INFO line.
+	match string
+}
+
+// dedupeFiles removes sources whose content matches another source in files, keeping the first of each
+// group in sorted order so the same file is converted on every run. Files are compared by size and then
+// SHA-256; with -dedupe-pixels the remaining files are also compared by the dimensions and signature of
+// their decoded pixels, which catches re-exports with different metadata. It returns the files to
+// convert and the duplicates left out.
+func dedupeFiles(ctx context.Context, files []string) ([]string, []duplicate) {
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+
+	bySize := make(map[int64][]string)
+	for _, file := range sorted {
+		info, err := os.Stat(file)
+		if err != nil {
+			// The source's own conversion reports this error.
+			continue
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], file)
+	}
+	var candidates []string
+	for _, group := range bySize {
+		if len(group) > 1 {
+			candidates = append(candidates, group...)
+		}
+	}
+	sums := hashAll(candidates, func(file string) (string, error) {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		sum, err := hashFile(file)
+		return fmt.Sprintf("%d:%s", info.Size(), sum), err
+	})
+
+	var dupes []duplicate
+	isDupe := make(map[string]bool)
+	canonical := make(map[string]string)
+	for _, file := range sorted {
+		sum, ok := sums[file]
+		if !ok {
+			continue
+		}
+		if first, seen := canonical[sum]; seen {
+			dupes = append(dupes, duplicate{source: file, canonical: first, match: "identical to"})
+			isDupe[file] = true
+			continue
+		}
+		canonical[sum] = file
+	}
+
+	if *dedupePixels {
+		var remaining []string
+		for _, file := range sorted {
+			if !isDupe[file] {
+				remaining = append(remaining, file)
+			}
+		}
+		signatures := hashAll(remaining, func(file string) (string, error) {
+			return pixelSignature(ctx, file)
+		})
+		canonical = make(map[string]string)
+		for _, file := range remaining {
+			sig, ok := signatures[file]
+			if !ok {
+				continue
+			}
+			if first, seen := canonical[sig]; seen {
+				dupes = append(dupes, duplicate{source: file, canonical: first, match: "the same image as"})
+				isDupe[file] = true
+				continue
+			}
+			canonical[sig] = file
+		}
+	}
+
+	if len(dupes) == 0 {
+		return files, nil
+	}
+	kept := files[:0:0]
+	for _, file := range files {
+		if !isDupe[file] {
+			kept = append(kept, file)
+		}
+	}
+	fmt.Fprintf(infoOut, "INFO: Dedupe: skipping %d duplicates of other sources.\n", len(dupes))
+	return kept, dupes
+}
+
+// hashAll runs hash on every file across -workers goroutines and returns the results by file. Files
+// that fail to hash are left out with a warning and converted as usual.
+func hashAll(files []string, hash func(string) (string, error)) map[string]string {
+	sums := make(map[string]string, len(files))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	fileCh := make(chan string)
+	for i := 0; i < max(*workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range fileCh {
+				sum, err := hash(file)
+				if err != nil {
+					fmt.Fprintf(os.Stdout, "WARN: Dedupe could not read %s, converting it anyway: %v\n", file, err)
+					continue
+				}
+				mu.Lock()
+				sums[file] = sum
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, file := range files {
+		fileCh <- file
+	}
+	close(fileCh)
+	wg.Wait()
+	return sums
+}
+
+// pixelSignature returns ImageMagick's signature of the decoded primary image of file, prefixed with its
+// dimensions. The decode is bounded by the conversion timeout for the file's size.
+func pixelSignature(ctx context.Context, file string) (string, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := conversionContext(ctx, info.Size())
+	defer cancel()
+	output, err := magickCommandContext(ctx, "identify", "-format", "%wx%h:%#", file+"[0]").Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("failed to compute pixel signature: %v", ctx.Err())
+		}
+		return "", fmt.Errorf("failed to compute pixel signature: %v", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// canonicalResults holds the result of every source that duplicates stand in for, so recordDuplicates
+// can tell whether it produced an output. A canonical without a result was not converted in this run.
+var canonicalResults struct {
+	sync.Mutex
+	results map[string]fileResult
+}
+
+// trackCanonicals starts keeping the results of the canonical sources of dupes.
+func trackCanonicals(dupes []duplicate) {
+	canonicalResults.Lock()
+	defer canonicalResults.Unlock()
+	canonicalResults.results = make(map[string]fileResult, len(dupes))
+	for _, d := range dupes {
+		canonicalResults.results[d.canonical] = fileResult{}
+	}
+}
+
+// noteCanonicalResult keeps res when its source is the canonical of a duplicate.
+func noteCanonicalResult(res fileResult) {
+	canonicalResults.Lock()
+	defer canonicalResults.Unlock()
+	if _, ok := canonicalResults.results[res.Source]; ok {
+		canonicalResults.results[res.Source] = res
+	}
+}
+
+// duplicatesOf returns the duplicates in dupes whose canonical source is in files.
+func duplicatesOf(dupes []duplicate, files []string) []duplicate {
+	in := make(map[string]bool, len(files))
+	for _, file := range files {
+		in[file] = true
+	}
+	kept := dupes[:0:0]
+	for _, d := range dupes {
+		if in[d.canonical] {
+			kept = append(kept, d)
+		}
+	}
+	return kept
+}
+
+// canonicalOutput returns the output standing in for the duplicates of canonical, and whether there is
+// one: the canonical was converted or skipped for an existing output in this run, or, when it was left
+// out as already converted, its output is still there.
+func canonicalOutput(canonical string) (string, bool) {
+	canonicalResults.Lock()
+	res := canonicalResults.results[canonical]
+	canonicalResults.Unlock()
+	target := res.Target
+	if target == "" {
+		target, _ = outputPath(canonical)
+	}
+	switch res.Status {
+	case statusConverted, statusUpToDate, statusExists, statusDiscarded, statusMetadata, statusDumped, statusDryRun:
+		return target, true
+	case "":
+		if target == "" {
+			return "", false
+		}
+		_, err := statOutput(target)
+		return target, err == nil
+	}
+	return target, false
+}
+
+// recordDuplicates reports every duplicate as skipped, pointing at the canonical source's output. A
+// duplicate whose canonical produced no output, because it failed or its earlier output is gone, is
+// converted after all, with its error sent to errCh.
+func recordDuplicates(ctx context.Context, dupes []duplicate, errCh chan<- error) {
+	for _, d := range dupes {
+		if ctx.Err() != nil {
+			return
+		}
+		target, ok := canonicalOutput(d.canonical)
+		if !ok {
+			fmt.Fprintf(infoOut, "INFO: Converting %s, %s %s, which produced no output.\n", d.source, d.match, d.canonical)
+			if err := processSingleFile(ctx, d.source); err != nil {
+				errCh <- err
+			}
+			continue
+		}
+		res := fileResult{Source: d.source, Status: statusDuplicate, DuplicateOf: d.canonical, Target: target}
+		if info, err := os.Stat(d.source); err == nil {
+			res.SourceSize = info.Size()
+		}
+		fmt.Fprintf(infoOut, "INFO: Skipped %s, %s %s (%s).\n", d.source, d.match, d.canonical, res.Target)
+		countResult(res)
+		if err := recordResult(res); err != nil {
+			fmt.Fprintf(os.Stderr, "WARN: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/nomadicGopher/Convert_HEIC/convert"
+)
+
+// capability is one row of the -list-delegates table.
+type capability struct {
+	label  string
+	format string
+	write  bool
+}
+
+// reportedCapabilities are the capabilities that matter for HEIC conversion, in display order.
+var reportedCapabilities = []capability{
+	{"HEIC read", "HEIC", false},
+	{"HEIF read", "HEIF", false},
+	{"PNG write", "PNG", true},
+	{"JPEG write", "JPEG", true},
+	{"WebP write", "WEBP", true},
+	{"AVIF write", "AVIF", true},
+	{"TIFF write", "TIFF", true},
+	{"BMP write", "BMP", true},
+}
+
+// parseDelegateList extracts the delegate names from `convert -list delegate` output.
+func parseDelegateList(output string) []string {
+	seen := make(map[string]struct{})
+	for _, line := range strings.Split(output, "\n") {
+		name, _, ok := strings.Cut(line, "=>")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(name), "<="))
+		if name != "" {
+			seen[name] = struct{}{}
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runListDelegates prints a yes/no table of the conversion capabilities of the installed ImageMagick.
+func runListDelegates() error {
+	if err := findMagick(); err != nil {
+		return err
+	}
+	formatOut, err := exec.Command(magickBin, "-list", "format").Output()
+	if err != nil {
+		return fmt.Errorf("failed to run '%s -list format': %v", magickBin, err)
+	}
+	delegateOut, err := exec.Command(magickBin, "-list", "delegate").Output()
+	if err != nil {
+		return fmt.Errorf("failed to run '%s -list delegate': %v", magickBin, err)
+	}
+
+	formats := convert.ParseFormatList(string(formatOut))
+	for _, c := range reportedCapabilities {
+		mode := formats[c.format]
+		supported := mode.Read
+		if c.write {
+			supported = mode.Write
+		}
+		answer := "no"
+		if supported {
+			answer = "yes"
+		}
+		fmt.Fprintf(os.Stdout, "%-12s %s\n", c.label, answer)
+	}
+	fmt.Fprintln(os.Stdout, "Delegates:", strings.Join(parseDelegateList(string(delegateOut)), ", "))
+	return nil
+}
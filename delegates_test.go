@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDelegateList(t *testing.T) {
+	output := `Path: /etc/ImageMagick-6/delegates.xml
+
+Delegate                Command
+-------------------------------------------------------------------------------
+        bpg =>          "bpgdec" -b 16 -o "%o.png" "%i"; /bin/mv "%o.png" "%o"
+   blender =>          "blender" -b "%i" -F PNG -o "%o""\n"magick" composite -geometry ...
+   png<=bmp=>          "magick" "%i" "%o"
+        dng:decode =>   "ufraw-batch" --silent "%i"
+        bpg =>          "bpgdec" -o "%o" "%i"
+`
+	want := []string{"blender", "bpg", "dng:decode", "png<=bmp"}
+	if got := parseDelegateList(output); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("parseDelegateList() = %q, want %q", got, want)
+	}
+}
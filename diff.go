@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// validateDiff checks the -diff flags and mirrors the source tree into -outdir unless -relative-to is set.
+func validateDiff(inPathInfo os.FileInfo) error {
+	if inPathInfo == nil || !inPathInfo.IsDir() {
+		return errors.New("-diff requires a directory -input")
+	}
+	if *outDir == "" {
+		return errors.New("-diff requires -outdir for the output tree")
+	}
+	if *burstPick != "" {
+		return errors.New("-diff cannot be used with -burst-pick")
+	}
+	if *relativeTo == "" {
+		*relativeTo = *inPath
+	}
+	return nil
+}
+
+// diffSources lists the HEIC files under dirPath, its whole tree with -recursive and only its top level
+// otherwise, and splits them by whether the output they map to already exists, ignoring modification
+// times.
+func diffSources(dirPath string) (missing []string, existing int, err error) {
+	sources, err := scanHeicFiles(dirPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, path := range sources {
+		out, err := outputPath(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, err := statOutput(out); err == nil {
+			existing++
+		} else {
+			missing = append(missing, path)
+		}
+	}
+	return missing, existing, nil
+}
+
+// processDiff converts only the sources under dirPath that have no output in the -outdir tree.
+func processDiff(ctx context.Context, dirPath string) error {
+	missing, existing, err := diffSources(dirPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(infoOut, "INFO: %d new sources to convert, %d already have outputs.\n", len(missing), existing)
+	if len(missing) == 0 {
+		return nil
+	}
+	return processFileList(ctx, missing)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDiffSources(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	out := filepath.Join(dir, "out")
+	for _, name := range []string{"a.heic", "b.HEIC", "c.heic", "2024/d.heic", "2024/e.heic", "notes.txt"} {
+		writeFile(t, src, name, "x")
+	}
+	// Outputs count by mapped name alone, however old they are.
+	writeFile(t, out, "a.jpg", "encoded")
+	writeFile(t, out, "2024/d.jpg", "encoded")
+	writeFile(t, out, "c.png", "other format")
+	setFlag(t, outType, "jpg")
+	setFlag(t, outDir, out)
+	setFlag(t, relativeTo, src)
+
+	tests := []struct {
+		name      string
+		recursive bool
+		missing   []string
+		existing  int
+	}{
+		{name: "top level", missing: []string{"b.HEIC", "c.heic"}, existing: 1},
+		{name: "recursive", recursive: true, missing: []string{"2024/e.heic", "b.HEIC", "c.heic"}, existing: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, recursive, tt.recursive)
+			missing, existing, err := diffSources(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, path := range missing {
+				rel, _ := filepath.Rel(src, path)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.missing) || existing != tt.existing {
+				t.Errorf("diffSources() = %q, %d existing, want %q, %d", got, existing, tt.missing, tt.existing)
+			}
+		})
+	}
+}
+
+func TestValidateDiff(t *testing.T) {
+	dir := t.TempDir()
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := os.Stat(writeFile(t, dir, "a.heic", "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, inPath, dir)
+
+	tests := []struct {
+		name   string
+		info   os.FileInfo
+		outDir string
+		burst  string
+		err    string
+	}{
+		{name: "file input", info: fileInfo, outDir: "/out", err: "requires a directory -input"},
+		{name: "no output tree", info: dirInfo, err: "requires -outdir"},
+		{name: "burst pick", info: dirInfo, outDir: "/out", burst: "sharpest", err: "cannot be used with -burst-pick"},
+		{name: "mirrors the input", info: dirInfo, outDir: "/out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, outDir, tt.outDir)
+			setFlag(t, burstPick, tt.burst)
+			setFlag(t, relativeTo, "")
+			err := validateDiff(tt.info)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("validateDiff() = %v, want an error mentioning %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || *relativeTo != dir {
+				t.Errorf("validateDiff() = %v with -relative-to %q, want it set to the input %q", err, *relativeTo, dir)
+			}
+		})
+	}
+}
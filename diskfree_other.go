@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import "errors"
+
+// volumeFree is not implemented here, as the statfs fields differ in name and type between the BSDs and
+// other systems; -preflight skips the free space check.
+func volumeFree(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
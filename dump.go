@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// commandDump is the open -dump-commands script, or nil when commands are executed normally.
+var commandDump struct {
+	sync.Mutex
+	file *os.File
+}
+
+// openCommandDump creates the -dump-commands script and writes its header.
+func openCommandDump(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create command script: %v", err)
+	}
+	if _, err := f.WriteString("#!/bin/sh\nset -e\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write command script: %v", err)
+	}
+	commandDump.file = f
+	return nil
+}
+
+// closeCommandDump flushes and closes the -dump-commands script, if one is open.
+func closeCommandDump() error {
+	if commandDump.file == nil {
+		return nil
+	}
+	if err := commandDump.file.Close(); err != nil {
+		return fmt.Errorf("failed to close command script: %v", err)
+	}
+	fmt.Fprintln(infoOut, "INFO: Commands written to", commandDump.file.Name())
+	return nil
+}
+
+// dumpCommand appends one fully quoted command line to the script. It is safe for concurrent use by workers.
+func dumpCommand(name string, args []string) error {
+	commandDump.Lock()
+	defer commandDump.Unlock()
+	if _, err := fmt.Fprintln(commandDump.file, shellCommand(name, args)); err != nil {
+		return fmt.Errorf("failed to write command script: %v", err)
+	}
+	return nil
+}
+
+// shellCommand renders name and args as one quoted shell command line.
+func shellCommand(name string, args []string) string {
+	quoted := make([]string, 0, len(args)+1)
+	quoted = append(quoted, shellQuote(name))
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes s for POSIX sh, leaving plain words untouched for readability.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-+=.,/:%@") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"convert", "convert"},
+		{"/photos/IMG_0001.HEIC", "/photos/IMG_0001.HEIC"},
+		{"-resize", "-resize"},
+		{"jpg:-", "jpg:-"},
+		{"", "''"},
+		{"My Photos/a.heic", "'My Photos/a.heic'"},
+		{"1600x1600>", "'1600x1600>'"},
+		{"it's.heic", `'it'\''s.heic'`},
+		{"$HOME;rm -rf /", "'$HOME;rm -rf /'"},
+		{"a[0]", "'a[0]'"},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDumpCommandScript(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh to run the script with")
+	}
+	path := filepath.Join(t.TempDir(), "convert.sh")
+	if err := openCommandDump(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { commandDump.file = nil }()
+	args := []string{"My Photos/it's.heic[0]", "-resize", "1600x1600>", "$HOME/out.jpg"}
+	if err := dumpCommand("printf", append([]string{`%s\n`}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	if err := closeCommandDump(); err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(script), "#!/bin/sh\nset -e\n") {
+		t.Errorf("script does not start with the sh header:\n%s", script)
+	}
+	// Running the script must hand printf every argument back unchanged.
+	out, err := exec.Command(sh, path).Output()
+	if err != nil {
+		t.Fatalf("running the script failed: %v", err)
+	}
+	if got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"); strings.Join(got, "|") != strings.Join(args, "|") {
+		t.Errorf("script passed %q, want %q", got, args)
+	}
+}
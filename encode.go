@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// encodeInExts are the accepted source extensions with -output heic, which encodes JPEG and PNG files
+// into HEIC instead of decoding HEIC sources.
+var encodeInExts = map[string]struct{}{
+	".jpg":  {},
+	".jpeg": {},
+	".png":  {},
+}
+
+// validHeicChroma are the chroma subsampling modes ImageMagick's HEIC encoder accepts with -heic-chroma.
+var validHeicChroma = map[string]struct{}{
+	"420": {},
+	"422": {},
+	"444": {},
+}
+
+// encodingHEIC reports whether this run encodes into HEIC rather than converting out of it.
+func encodingHEIC() bool {
+	return *outType == "heic"
+}
+
+// validateEncodeFlags checks the HEIC encoder flags and, for -output heic, switches source detection to
+// JPEG and PNG files.
+func validateEncodeFlags() error {
+	if !encodingHEIC() {
+		if *heicChroma != "" {
+			return errors.New("-heic-chroma requires -output heic")
+		}
+		return nil
+	}
+	if *heicChroma != "" {
+		if _, ok := validHeicChroma[*heicChroma]; !ok {
+			return fmt.Errorf("invalid -heic-chroma %q. Use '420', '422' or '444'", *heicChroma)
+		}
+	}
+	if *montageMode || *burstPick != "" || *livePhotos {
+		return errors.New("-montage, -burst-pick and -live-photos only apply to HEIC sources, not -output heic")
+	}
+	validInExts = encodeInExts
+	fmt.Fprintln(infoOut, "INFO: Encoding JPEG and PNG sources into HEIC.")
+	return nil
+}
+
+// heicEncodeArgs returns the HEIC encoder options for -output heic.
+func heicEncodeArgs() []string {
+	if !encodingHEIC() || *heicChroma == "" {
+		return nil
+	}
+	return []string{"-define", "heic:chroma=" + *heicChroma}
+}
+
+// sourceExtensions lists the accepted source extensions for messages, such as ".heic, .heif or .hif".
+func sourceExtensions() string {
+	exts := []string{".heic", ".heif", ".hif"}
+	if encodingHEIC() {
+		exts = []string{".jpg", ".jpeg", ".png"}
+	}
+	return strings.Join(exts[:len(exts)-1], ", ") + " or " + exts[len(exts)-1]
+}
+
+// checkEncodeSource returns nil when path starts with the JPEG or PNG signature, for -sniff with -output heic.
+func checkEncodeSource(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	defer f.Close()
+	head := make([]byte, 8)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	head = head[:n]
+	for _, format := range []string{"jpg", "png"} {
+		if bytes.HasPrefix(head, outputSignatures[format].magic) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a JPEG or PNG file", path)
+}
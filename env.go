@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// envSidecarSuffix is appended to a source path to find its per-file environment sidecar.
+const envSidecarSuffix = ".env"
+
+// globalEnv holds the KEY=VALUE pairs loaded from -env-file.
+var globalEnv []string
+
+// parseEnvFile reads KEY=VALUE lines from path, ignoring blank lines and # comments.
+func parseEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		env = append(env, key+"="+strings.TrimSpace(value))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return env, nil
+}
+
+// commandEnv returns the environment for converting inFile: the process environment, then -env-file,
+// then the file's own <source>.env sidecar if present. Later entries win, as exec.Cmd uses the last
+// value of a duplicated key.
+func commandEnv(inFile string) ([]string, error) {
+	env := append(os.Environ(), globalEnv...)
+	sidecar, err := parseEnvFile(inFile + envSidecarSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return env, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load environment sidecar for %s: %v", inFile, err)
+	}
+	return append(env, sidecar...), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+		err  string
+	}{
+		{
+			name: "pairs, comments and blank lines",
+			data: "# tuning\nMAGICK_THREAD_LIMIT=2\n\n  MAGICK_MEMORY_LIMIT = 1GiB  \nEMPTY=\nURL=http://x/?a=b\n",
+			want: []string{"MAGICK_THREAD_LIMIT=2", "MAGICK_MEMORY_LIMIT=1GiB", "EMPTY=", "URL=http://x/?a=b"},
+		},
+		{name: "missing equals sign", data: "A=1\nNOVALUE\n", err: ":2: expected KEY=VALUE"},
+		{name: "missing key", data: "=1\n", err: ":1: expected KEY=VALUE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEnvFile(writeFile(t, t.TempDir(), "tuning.env", tt.data))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("parseEnvFile() error = %v, want it to mention %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("parseEnvFile() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("CONVERT_HEIC_TEST", "process")
+	t.Setenv("CONVERT_HEIC_TEST_PROCESS", "kept")
+	plain := writeFile(t, dir, "plain.heic", "x")
+	tuned := writeFile(t, dir, "tuned.heic", "x")
+	writeFile(t, dir, "tuned.heic.env", "CONVERT_HEIC_TEST=sidecar\n")
+	broken := writeFile(t, dir, "broken.heic", "x")
+	writeFile(t, dir, "broken.heic.env", "NOVALUE\n")
+
+	tests := []struct {
+		name      string
+		file      string
+		global    []string
+		want      string
+		wantError bool
+	}{
+		{name: "process environment", file: plain, want: "process"},
+		{name: "-env-file over the process", file: plain, global: []string{"CONVERT_HEIC_TEST=global"}, want: "global"},
+		{name: "sidecar over -env-file", file: tuned, global: []string{"CONVERT_HEIC_TEST=global"}, want: "sidecar"},
+		{name: "invalid sidecar", file: broken, wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, &globalEnv, tt.global)
+			env, err := commandEnv(tt.file)
+			if tt.wantError {
+				if err == nil {
+					t.Fatal("commandEnv() succeeded with an invalid sidecar")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Contains(env, "CONVERT_HEIC_TEST_PROCESS=kept") {
+				t.Error("commandEnv() dropped the process environment")
+			}
+			// exec.Cmd passes the last value of a duplicated key, which is what the command sees.
+			sh, err := exec.LookPath("sh")
+			if err != nil {
+				t.Skip("no sh to check the effective value with")
+			}
+			cmd := exec.Command(sh, "-c", "printf %s \"$CONVERT_HEIC_TEST\"")
+			cmd.Env = env
+			out, err := cmd.Output()
+			if err != nil || string(out) != tt.want {
+				t.Errorf("command saw CONVERT_HEIC_TEST=%q, %v, want %q", out, err, tt.want)
+			}
+		})
+	}
+}
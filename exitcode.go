@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Exit codes, documented in the usage text. They are stable so scripts can rely on them.
+const (
+	// exitUsage covers invalid flags, config files and flag combinations.
+	exitUsage = 1
+	// exitEnvironment covers problems with the system rather than the command line, such as a missing
+	// ImageMagick, an unreadable input or an unwritable directory.
+	exitEnvironment = 2
+	// exitPartial means the run finished but some files failed while others converted.
+	exitPartial = 3
+	// exitFailed means files failed and none converted.
+	exitFailed = 4
+	// exitInterrupted follows the shell convention for termination by Ctrl-C.
+	exitInterrupted = 130
+)
+
+// exitCodeUsage describes the exit codes for the usage text.
+const exitCodeUsage = `
+Exit codes:
+  0    every file was converted or skipped
+  1    usage error: invalid flags, config or flag combination
+  2    environment error, such as missing ImageMagick or an unreadable input
+  3    some files failed while the others converted
+  4    files failed and none converted
+  130  interrupted by Ctrl-C or SIGTERM
+`
+
+// exitStatuses names the exit codes in the RESULT line.
+var exitStatuses = map[int]string{
+	0:               "ok",
+	exitUsage:       "usage",
+	exitEnvironment: "environment",
+	exitPartial:     "partial",
+	exitFailed:      "failed",
+	exitInterrupted: "interrupted",
+}
+
+// exitCodeError carries the exit code for an error that ends the run when it is not the one its call
+// site would pick, such as an unreadable input found while validating flags.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e exitCodeError) Error() string { return e.err.Error() }
+
+func (e exitCodeError) Unwrap() error { return e.err }
+
+// withExitCode marks err to end the run with code.
+func withExitCode(code int, err error) error {
+	return exitCodeError{code: code, err: err}
+}
+
+// exitCodeOf returns the exit code err was marked with, or fallback.
+func exitCodeOf(err error, fallback int) int {
+	var coded exitCodeError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return fallback
+}
+
+// fatal logs err, prints the RESULT line and exits with the code err was marked with, or fallback. The
+// temporary stream and spool files are removed first, as deferred cleanups don't run on exit.
+func fatal(fallback int, err error) {
+	cleanupStreams()
+	cleanupRemote()
+	code := exitCodeOf(err, fallback)
+	log.Printf("ERROR: %v\n", err)
+	printResultLine(code)
+	os.Exit(code)
+}
+
+// processingExitCode picks the exit code for a run whose processing step failed with err.
+func processingExitCode(err error) int {
+	if errors.Is(err, errInterrupted) {
+		return exitInterrupted
+	}
+	statusCounts.Lock()
+	defer statusCounts.Unlock()
+	failed := statusCounts.counts[statusFailed]
+	var succeeded int
+	for status, n := range statusCounts.counts {
+		if status != statusFailed && status != statusMissing {
+			succeeded += n
+		}
+	}
+	switch {
+	case failed > 0 && succeeded > 0:
+		return exitPartial
+	case failed > 0:
+		return exitFailed
+	}
+	// Nothing failed to convert, so the run stopped on its inputs, such as missing files under
+	// -fail-on-missing or a directory that could not be listed.
+	return exitCodeOf(err, exitEnvironment)
+}
+
+// printResultLine prints the last line of every run, meant for scripts:
+//
+//	RESULT: exit=3 status=partial converted=10 skipped=2 failed=1
+func printResultLine(code int) {
+	statusCounts.Lock()
+	counts := statusCounts.counts
+	line := fmt.Sprintf("RESULT: exit=%d status=%s converted=%d skipped=%d failed=%d",
+		code, exitStatuses[code], counts[statusConverted], counts[statusExists]+counts[statusUpToDate], counts[statusFailed])
+	statusCounts.Unlock()
+	fmt.Fprintln(os.Stdout, line)
+}
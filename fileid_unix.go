@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileIdentity returns a key for the file behind info, the same for every hard link to it.
+func fileIdentity(info os.FileInfo) (string, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%d:%d", st.Dev, st.Ino), true
+}
+
+// fileSystemKey identifies the filesystem holding the existing path, so directories sharing free space
+// can be counted together. It falls back to the path itself.
+func fileSystemKey(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return path
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprint(st.Dev)
+	}
+	return path
+}
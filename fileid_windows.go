@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileIdentity reports no identity on Windows, whose file index os.Stat does not expose. Hard links are
+// then converted like separate files, and directory cycles are found by resolved path instead.
+func fileIdentity(info os.FileInfo) (string, bool) {
+	return "", false
+}
+
+// fileSystemKey identifies the volume holding path, so directories sharing free space can be counted
+// together.
+func fileSystemKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return strings.ToUpper(filepath.VolumeName(path))
+}
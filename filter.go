@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// validatePatterns checks the -include and -exclude patterns for syntax errors.
+func validatePatterns() error {
+	for _, list := range []*stringList{includes, excludes} {
+		for _, p := range *list {
+			if _, err := filepath.Match(p, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q: %v", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether rel, a slash-separated path relative to the input directory, matches one
+// of patterns. Patterns without a slash match the base name at any depth; others match the whole
+// relative path, with "**" standing for any number of directories.
+func matchesAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if !strings.Contains(p, "/") {
+			if ok, _ := filepath.Match(p, filepath.Base(rel)); ok {
+				return true
+			}
+			continue
+		}
+		if matchSegments(strings.Split(p, "/"), strings.Split(rel, "/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// relativeSlash returns path relative to root in slash form, for matching against patterns.
+func relativeSlash(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// selectedSource reports whether the source at path under root passes -include and -exclude.
+func selectedSource(root, path string) bool {
+	rel := relativeSlash(root, path)
+	if len(*includes) > 0 && !matchesAny(*includes, rel) {
+		return false
+	}
+	return !matchesAny(*excludes, rel)
+}
+
+// excludedDir reports whether the directory at path under root matches -exclude, so the walk can skip it.
+func excludedDir(root, path string) bool {
+	rel := relativeSlash(root, path)
+	return rel != "." && matchesAny(*excludes, rel)
+}
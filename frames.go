@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// firstFrameSuffix is what -all-frames appends to the output name of the first image, e.g. IMG_1234_001.jpg.
+const firstFrameSuffix = "_001"
+
+// validateFrameFlags checks -frame and -all-frames against each other and the features that assume a
+// single output per source.
+func validateFrameFlags() error {
+	if *frameIndex < 0 {
+		return errors.New("-frame must be at least 1")
+	}
+	if !*allFrames {
+		return nil
+	}
+	switch {
+	case *frameIndex > 0:
+		return errors.New("-frame and -all-frames cannot be used together")
+	case *montageMode:
+		return errors.New("-all-frames and -montage cannot be used together")
+	case *targetSize != "" || *ioWorkers > 0 || *cacheDir != "":
+		return errors.New("-all-frames cannot be used with -target-size, -io-workers or -cache-dir, which handle a single output per source")
+	}
+	return nil
+}
+
+// sourceSpec returns how the source is named to ImageMagick: with -frame N, the Nth image (counting from 1)
+// using ImageMagick's zero-based frame index; with -all-frames, the file itself. Otherwise the primary image
+// is selected by its frame index, so ImageMagick reads the full grid-assembled image the container declares
+// rather than whichever image, tile or not, its build reads first. Sources the parser cannot read fall back
+// to the first frame.
+func sourceSpec(inFile string) string {
+	switch {
+	case *frameIndex > 0:
+		return fmt.Sprintf("%s[%d]", inFile, *frameIndex-1)
+	case *allFrames:
+		return inFile
+	}
+	primary := 0
+	if info, err := parseHEIF(inFile); err == nil {
+		primary = info.Primary
+	}
+	return fmt.Sprintf("%s[%d]", inFile, primary)
+}
+
+// firstFrameOutput returns the name -all-frames gives the first image's output, which stands in for all of
+// them when checking whether the source was already converted.
+func firstFrameOutput(outFile string) string {
+	ext := filepath.Ext(outFile)
+	return strings.TrimSuffix(outFile, ext) + firstFrameSuffix + ext
+}
+
+// framePattern turns a firstFrameOutput name back into the ImageMagick filename pattern that numbers
+// every image from 001 when used with -scene 1.
+func framePattern(firstOutput string) string {
+	ext := filepath.Ext(firstOutput)
+	return strings.TrimSuffix(strings.TrimSuffix(firstOutput, ext), firstFrameSuffix) + "_%03d" + ext
+}
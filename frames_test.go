@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestSourceSpec(t *testing.T) {
+	dir := t.TempDir()
+	plain := writeFile(t, dir, "plain.heic", string(buildHEIF(heifFile{primary: 1, items: []string{"hvc1"}})))
+	// The primary is the second top-level image, a grid assembled from the two tiles that follow it.
+	grid := writeFile(t, dir, "grid.heic", string(buildHEIF(heifFile{
+		primary: 2,
+		items:   []string{"hvc1", "grid", "hvc1", "hvc1"},
+		refs:    [][]byte{box("dimg", u16(2), u16(2), u16(3), u16(4))},
+	})))
+	broken := writeFile(t, dir, "broken.heic", "not a HEIF file")
+
+	tests := []struct {
+		name  string
+		file  string
+		frame int
+		all   bool
+		want  string
+	}{
+		{name: "single image", file: plain, want: plain + "[0]"},
+		{name: "grid primary", file: grid, want: grid + "[1]"},
+		{name: "-frame", file: grid, frame: 1, want: grid + "[0]"},
+		{name: "-all-frames", file: grid, all: true, want: grid},
+		{name: "unparsable source", file: broken, want: broken + "[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, frameIndex, tt.frame)
+			setFlag(t, allFrames, tt.all)
+			if got := sourceSpec(tt.file); got != tt.want {
+				t.Errorf("sourceSpec() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
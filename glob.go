@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// expandedInputs holds the sources matched when -input is a glob pattern or resolved from several -input
+// values, or nil otherwise.
+var expandedInputs []string
+
+// hasGlobMeta reports whether path contains glob metacharacters.
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// globRoot returns the longest leading directory of pattern without glob metacharacters, which is
+// where the walk for matches starts.
+func globRoot(pattern string) string {
+	segs := strings.Split(pattern, string(filepath.Separator))
+	for i, seg := range segs {
+		if hasGlobMeta(seg) {
+			root := strings.Join(segs[:i], string(filepath.Separator))
+			if root == "" {
+				return string(filepath.Separator)
+			}
+			return root
+		}
+	}
+	return filepath.Dir(pattern)
+}
+
+// matchSegments matches path segments against pattern segments, where a "**" segment matches zero or
+// more whole path segments and every other segment follows filepath.Match.
+func matchSegments(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchSegments(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	if ok, err := filepath.Match(pattern[0], path[0]); err != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], path[1:])
+}
+
+// globPrunes reports whether nothing below the directory split into dirSegs can match patternSegs. Without
+// "**" a match has exactly as many segments as the pattern, so directories at that depth or deeper are
+// not walked.
+func globPrunes(patternSegs, dirSegs []string) bool {
+	return !slices.Contains(patternSegs, "**") && len(dirSegs) >= len(patternSegs)
+}
+
+// expandGlob returns the HEIC files matching an absolute pattern, which may use "**" for recursion.
+func expandGlob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid input pattern %q: %v", pattern, err)
+	}
+	sep := string(filepath.Separator)
+	patternSegs := strings.Split(strings.TrimPrefix(pattern, sep), sep)
+
+	var matches []string
+	err := filepath.WalkDir(globRoot(pattern), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		segs := strings.Split(strings.TrimPrefix(path, sep), sep)
+		if d.IsDir() {
+			if path != sep && globPrunes(patternSegs, segs) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !matchSegments(patternSegs, segs) {
+			return nil
+		}
+		if err := checkHeicSource(path); err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: Skipping source: %v.\n", err)
+			return nil
+		}
+		matches = append(matches, path)
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to expand input pattern: %v", err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("input pattern %q matched no HEIC files", pattern)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
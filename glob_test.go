@@ -0,0 +1,113 @@
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"photos/*.heic", "photos/a.heic", true},
+		{"photos/*.heic", "photos/sub/a.heic", false},
+		{"photos/**/*.heic", "photos/a.heic", true},
+		{"photos/**/*.heic", "photos/2024/06/a.heic", true},
+		{"photos/**/*.heic", "other/a.heic", false},
+		{"photos/**", "photos/a/b/c.heic", true},
+		{"photos/**/raw/*.heic", "photos/2024/raw/a.heic", true},
+		{"photos/**/raw/*.heic", "photos/2024/edited/a.heic", false},
+		{"photos/IMG_[0-9]*.heic", "photos/IMG_1.heic", true},
+		{"photos/IMG_[0-9]*.heic", "photos/IMG_x.heic", false},
+		{"photos/?.heic", "photos/ab.heic", false},
+		{"photos/[.heic", "photos/[.heic", false},
+	}
+	for _, tt := range tests {
+		if got := matchSegments(strings.Split(tt.pattern, "/"), strings.Split(tt.path, "/")); got != tt.want {
+			t.Errorf("matchSegments(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestGlobRoot(t *testing.T) {
+	sep := string(filepath.Separator)
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{filepath.Join(sep, "photos", "2024", "*.heic"), filepath.Join(sep, "photos", "2024")},
+		{filepath.Join(sep, "photos", "**", "*.heic"), filepath.Join(sep, "photos")},
+		{filepath.Join(sep, "*", "a.heic"), sep},
+	}
+	for _, tt := range tests {
+		if got := globRoot(tt.pattern); got != tt.want {
+			t.Errorf("globRoot(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestGlobPrunes(t *testing.T) {
+	tests := []struct {
+		pattern string
+		dir     string
+		want    bool
+	}{
+		{"photos/*.heic", "photos", false},
+		{"photos/*.heic", "photos/sub", true},
+		{"photos/*.heic", "photos/sub/deeper", true},
+		{"photos/*/*.heic", "photos/2024", false},
+		{"photos/*/*.heic", "photos/2024/06", true},
+		{"photos/**/*.heic", "photos/2024/06/01", false},
+		{"photos/**", "photos/a/b", false},
+	}
+	for _, tt := range tests {
+		if got := globPrunes(strings.Split(tt.pattern, "/"), strings.Split(tt.dir, "/")); got != tt.want {
+			t.Errorf("globPrunes(%q, %q) = %v, want %v", tt.pattern, tt.dir, got, tt.want)
+		}
+	}
+}
+
+func TestExpandGlob(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("patterns are split on the separator after a leading one")
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"a.heic", "b.HEIC", "notes.txt", "2024/c.heic", "2024/06/d.heic", "raw/e.jpg"} {
+		writeFile(t, dir, name, "x")
+	}
+	tests := []struct {
+		pattern string
+		want    []string
+		err     string
+	}{
+		{pattern: "*.heic", want: []string{"a.heic"}},
+		{pattern: "*", want: []string{"a.heic", "b.HEIC"}},
+		{pattern: "*/*.heic", want: []string{"2024/c.heic"}},
+		{pattern: "**/*.heic", want: []string{"2024/06/d.heic", "2024/c.heic", "a.heic"}},
+		{pattern: "2024/**/*", want: []string{"2024/06/d.heic", "2024/c.heic"}},
+		{pattern: "raw/*", err: "matched no HEIC files"},
+		{pattern: "missing/*.heic", err: "matched no HEIC files"},
+		{pattern: "[.heic", err: "invalid input pattern"},
+	}
+	for _, tt := range tests {
+		got, err := expandGlob(filepath.Join(dir, tt.pattern))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expandGlob(%q) = %q, %v, want an error mentioning %q", tt.pattern, got, err, tt.err)
+			}
+			continue
+		}
+		var want []string
+		for _, name := range tt.want {
+			want = append(want, filepath.Join(dir, name))
+		}
+		if err != nil || !slices.Equal(got, want) {
+			t.Errorf("expandGlob(%q) = %q, %v, want %q", tt.pattern, got, err, want)
+		}
+	}
+}
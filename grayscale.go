@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// grayscaleSaturation is the mean HSL saturation (0-1) below which -auto-grayscale treats an image as grayscale.
+const grayscaleSaturation = 0.05
+
+// meanSaturation returns the mean HSL saturation of a downscaled copy of inFile's primary image.
+func meanSaturation(inFile string) (float64, error) {
+	output, err := magickCommand("convert", inFile+"[0]",
+		"-resize", "256x256>",
+		"-colorspace", "HSL",
+		"-channel", "G", "-separate", "+channel",
+		"-format", "%[fx:mean]", "info:").Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to measure saturation of %s: %v", inFile, err)
+	}
+	sat, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse saturation of %s from %q: %v", inFile, output, err)
+	}
+	return sat, nil
+}
+
+// isNearGrayscale reports whether a mean saturation is low enough to store the image as grayscale.
+func isNearGrayscale(saturation float64) bool {
+	return saturation < grayscaleSaturation
+}
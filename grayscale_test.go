@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIsNearGrayscale(t *testing.T) {
+	tests := []struct {
+		saturation float64
+		want       bool
+	}{
+		{0, true},
+		{0.012, true},
+		{0.049, true},
+		{0.05, false},
+		{0.34, false},
+		{1, false},
+	}
+	for _, tt := range tests {
+		if got := isNearGrayscale(tt.saturation); got != tt.want {
+			t.Errorf("isNearGrayscale(%v) = %v, want %v", tt.saturation, got, tt.want)
+		}
+	}
+}
+
+func TestAutoGrayscale(t *testing.T) {
+	tests := []struct {
+		name       string
+		saturation string
+		wantGray   bool
+		wantErr    string
+	}{
+		{name: "scanned document", saturation: "0.0123", wantGray: true},
+		{name: "color photo", saturation: "0.3417"},
+		{name: "unreadable measurement", saturation: "nan%", wantErr: "failed to parse saturation"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := stubMagick(t)
+			setFlag(t, outType, "jpg")
+			setFlag(t, autoGrayscale, true)
+			dir := t.TempDir()
+			in := writeFile(t, dir, "IMG_1.heic", "source")
+			writeFile(t, dir, "IMG_1.heic.info", tt.saturation+"\n")
+
+			args, err := buildConvertArgs(in, "out.jpg")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("buildConvertArgs() = %q, %v, want an error containing %q", args, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			gray := slices.Contains(args, "Gray")
+			if gray != tt.wantGray {
+				t.Errorf("buildConvertArgs() = %q, want grayscale %v", args, tt.wantGray)
+			}
+			if commands := stubCommands(t, log); len(commands) != 1 || !strings.HasSuffix(commands[0], "-format %[fx:mean] info:") {
+				t.Errorf("ran %q, want one saturation measurement", commands)
+			}
+		})
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// heifImageTypes are the item types of HEIF items that hold an image, as opposed to metadata.
+var heifImageTypes = map[string]bool{
+	"hvc1": true,
+	"av01": true,
+	"grid": true,
+	"iovl": true,
+	"iden": true,
+	"jpeg": true,
+	"unci": true,
+}
+
+// maxMetaBoxSize bounds how much of a file is read as the meta box, which holds only item descriptions.
+const maxMetaBoxSize = 16 << 20
+
+// maxExifSize bounds the EXIF item read by parseHEIF.
+const maxExifSize = 1 << 20
+
+// heifInfo is what parseHEIF reads from a HEIF container without decoding any pixels.
+type heifInfo struct {
+	Brand      string
+	Compatible []string
+	Width      int
+	Height     int
+	// BitDepth is the bits per channel of the primary image, or 0 when the file does not say.
+	BitDepth int
+	// Transfer is the ITU-T H.273 transfer characteristics code in the primary image's nclx colr
+	// property, or 0 when it has none.
+	Transfer int
+	// Images counts the top-level images: neither grid tiles, thumbnails nor auxiliary images such as depth maps.
+	Images int
+	// Primary is the zero-based position of the primary image among the top-level images, in item order,
+	// which is the frame index ImageMagick reads it by.
+	Primary int
+	// Exif is the EXIF payload of the primary image, starting at its TIFF header, or nil.
+	Exif []byte
+}
+
+// heifBox is one ISO BMFF box: its type and its payload after the header.
+type heifBox struct {
+	typ     string
+	payload []byte
+}
+
+// readBoxes splits data into the boxes it contains.
+func readBoxes(data []byte) ([]heifBox, error) {
+	var boxes []heifBox
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("truncated box header")
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errors.New("truncated box header")
+			}
+			size, header = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, fmt.Errorf("box %q has an invalid size", typ)
+		}
+		boxes = append(boxes, heifBox{typ: typ, payload: data[header:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// heifReader reads big-endian fields from a box payload, recording the first out-of-range read.
+type heifReader struct {
+	data []byte
+	err  error
+}
+
+func (r *heifReader) take(n int) []byte {
+	if r.err != nil || n > len(r.data) {
+		r.err = errors.New("truncated box")
+		return make([]byte, n)
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+// uint reads an unsigned field of n bytes, where n is 0, 2, 4 or 8 as in iloc.
+func (r *heifReader) uint(n int) uint64 {
+	var v uint64
+	for _, c := range r.take(n) {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// fieldSize returns the byte size of item ID and count fields, which later box versions widen to 32 bits.
+func fieldSize(wide bool) int {
+	if wide {
+		return 4
+	}
+	return 2
+}
+
+// parseHEIF reads the brand, primary image properties, image count and EXIF of the HEIF file at path.
+func parseHEIF(path string) (heifInfo, error) {
+	var info heifInfo
+	f, err := os.Open(path)
+	if err != nil {
+		return info, err
+	}
+	defer f.Close()
+
+	// Walk the top-level box headers, reading only ftyp and meta; mdat can be many megabytes.
+	var meta []byte
+	for offset := int64(0); ; {
+		header := make([]byte, 16)
+		n, err := f.ReadAt(header, offset)
+		if n < 8 {
+			if err == io.EOF || err == nil {
+				break
+			}
+			return info, err
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		typ := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			stat, err := f.Stat()
+			if err != nil {
+				return info, err
+			}
+			size = stat.Size() - offset
+		case 1:
+			if n < 16 {
+				return info, fmt.Errorf("truncated %q box", typ)
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(header[8:])), 16
+		}
+		if size < headerSize {
+			return info, fmt.Errorf("box %q has an invalid size", typ)
+		}
+		switch typ {
+		case "ftyp", "meta":
+			if size > maxMetaBoxSize {
+				return info, fmt.Errorf("%q box is too large", typ)
+			}
+			payload := make([]byte, size-headerSize)
+			if _, err := f.ReadAt(payload, offset+headerSize); err != nil {
+				return info, fmt.Errorf("truncated %q box", typ)
+			}
+			if typ == "ftyp" {
+				r := &heifReader{data: payload}
+				info.Brand = string(r.take(4))
+				r.take(4)
+				for len(r.data) >= 4 {
+					info.Compatible = append(info.Compatible, string(r.take(4)))
+				}
+			} else {
+				meta = payload
+			}
+		}
+		offset += size
+	}
+	if info.Brand == "" {
+		return info, errors.New("not an ISO media file, it has no ftyp box")
+	}
+	if meta == nil {
+		// Image sequences keep their frames in tracks rather than items; there is no primary image to describe.
+		return info, nil
+	}
+	exifLoc, err := info.parseMeta(meta)
+	if err != nil {
+		return info, err
+	}
+	if exifLoc.length > 0 {
+		if exifLoc.length > maxExifSize {
+			return info, errors.New("the EXIF item is too large")
+		}
+		data := make([]byte, exifLoc.length)
+		if _, err := f.ReadAt(data, int64(exifLoc.offset)); err != nil {
+			return info, fmt.Errorf("failed to read the EXIF item: %v", err)
+		}
+		// The payload starts with the offset of the TIFF header after this field.
+		if len(data) >= 4 {
+			if skip := uint64(binary.BigEndian.Uint32(data)) + 4; skip < uint64(len(data)) {
+				info.Exif = data[skip:]
+			}
+		}
+	}
+	return info, nil
+}
+
+// heifExtent is where an item's data lies in the file.
+type heifExtent struct {
+	offset, length uint64
+}
+
+// parseMeta fills in the primary image properties and image count from the meta box and returns where
+// the EXIF item of the primary image is stored.
+func (info *heifInfo) parseMeta(meta []byte) (heifExtent, error) {
+	// meta is a full box: skip its version and flags.
+	if len(meta) < 4 {
+		return heifExtent{}, errors.New("truncated meta box")
+	}
+	boxes, err := readBoxes(meta[4:])
+	if err != nil {
+		return heifExtent{}, fmt.Errorf("invalid meta box: %v", err)
+	}
+	var primary uint64
+	itemTypes := make(map[uint64]string)
+	var itemOrder []uint64
+	locations := make(map[uint64]heifExtent)
+	// hidden are items that are parts of or additions to another image rather than images of their own.
+	hidden := make(map[uint64]bool)
+	cdsc := make(map[uint64][]uint64)
+	var properties []heifBox
+	associations := make(map[uint64][]int)
+
+	for _, box := range boxes {
+		r := &heifReader{data: box.payload}
+		switch box.typ {
+		case "pitm":
+			version := r.uint(1)
+			r.take(3)
+			primary = r.uint(fieldSize(version != 0))
+		case "iinf":
+			version := r.uint(1)
+			r.take(3)
+			r.uint(fieldSize(version != 0))
+			entries, err := readBoxes(r.data)
+			if err != nil {
+				return heifExtent{}, fmt.Errorf("invalid iinf box: %v", err)
+			}
+			for _, e := range entries {
+				er := &heifReader{data: e.payload}
+				v := er.uint(1)
+				er.take(3)
+				if e.typ != "infe" || v < 2 {
+					continue
+				}
+				id := er.uint(fieldSize(v > 2))
+				er.take(2)
+				itemTypes[id] = string(er.take(4))
+				itemOrder = append(itemOrder, id)
+			}
+		case "iloc":
+			if err := parseIloc(r, locations); err != nil {
+				return heifExtent{}, err
+			}
+		case "iref":
+			version := r.uint(1)
+			r.take(3)
+			idSize := fieldSize(version != 0)
+			refs, err := readBoxes(r.data)
+			if err != nil {
+				return heifExtent{}, fmt.Errorf("invalid iref box: %v", err)
+			}
+			for _, ref := range refs {
+				rr := &heifReader{data: ref.payload}
+				from := rr.uint(idSize)
+				count := int(rr.uint(2))
+				for i := 0; i < count && rr.err == nil; i++ {
+					to := rr.uint(idSize)
+					switch ref.typ {
+					case "dimg":
+						hidden[to] = true
+					case "thmb", "auxl":
+						hidden[from] = true
+					case "cdsc":
+						cdsc[to] = append(cdsc[to], from)
+					}
+				}
+			}
+		case "iprp":
+			children, err := readBoxes(box.payload)
+			if err != nil {
+				return heifExtent{}, fmt.Errorf("invalid iprp box: %v", err)
+			}
+			for _, child := range children {
+				switch child.typ {
+				case "ipco":
+					if properties, err = readBoxes(child.payload); err != nil {
+						return heifExtent{}, fmt.Errorf("invalid ipco box: %v", err)
+					}
+				case "ipma":
+					parseIpma(&heifReader{data: child.payload}, associations)
+				}
+			}
+		}
+		if r.err != nil {
+			return heifExtent{}, fmt.Errorf("invalid %s box: %v", box.typ, r.err)
+		}
+	}
+
+	for _, id := range itemOrder {
+		if heifImageTypes[itemTypes[id]] && !hidden[id] {
+			if id == primary {
+				info.Primary = info.Images
+			}
+			info.Images++
+		}
+	}
+	for _, index := range associations[primary] {
+		if index < 1 || index > len(properties) {
+			continue
+		}
+		prop := properties[index-1]
+		r := &heifReader{data: prop.payload}
+		// The version and flags of full boxes, or the colour type of colr.
+		head := r.take(4)
+		switch prop.typ {
+		case "ispe":
+			info.Width, info.Height = int(r.uint(4)), int(r.uint(4))
+		case "colr":
+			if string(head) == "nclx" {
+				r.uint(2) // colour primaries
+				info.Transfer = int(r.uint(2))
+			}
+		case "pixi":
+			if channels := r.uint(1); channels > 0 {
+				info.BitDepth = int(r.uint(1))
+			}
+		}
+	}
+	for _, id := range cdsc[primary] {
+		if itemTypes[id] == "Exif" {
+			return locations[id], nil
+		}
+	}
+	return heifExtent{}, nil
+}
+
+// parseIloc records the first extent of every item stored directly in the file.
+func parseIloc(r *heifReader, locations map[uint64]heifExtent) error {
+	version := r.uint(1)
+	r.take(3)
+	sizes := r.uint(2)
+	offsetSize, lengthSize := int(sizes>>12&0xF), int(sizes>>8&0xF)
+	baseOffsetSize, indexSize := int(sizes>>4&0xF), int(sizes&0xF)
+	if version == 0 {
+		indexSize = 0
+	}
+	count := r.uint(fieldSize(version == 2))
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		id := r.uint(fieldSize(version == 2))
+		method := uint64(0)
+		if version > 0 {
+			method = r.uint(2) & 0xF
+		}
+		r.take(2)
+		base := r.uint(baseOffsetSize)
+		extents := r.uint(2)
+		for j := uint64(0); j < extents && r.err == nil; j++ {
+			r.uint(indexSize)
+			offset, length := r.uint(offsetSize), r.uint(lengthSize)
+			// Only file offsets are supported; idat-stored items are small and never EXIF in practice.
+			if j == 0 && method == 0 {
+				locations[id] = heifExtent{offset: base + offset, length: length}
+			}
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("invalid iloc box: %v", r.err)
+	}
+	return nil
+}
+
+// parseIpma records the 1-based ipco indexes of the properties associated with each item.
+func parseIpma(r *heifReader, associations map[uint64][]int) {
+	version := r.uint(1)
+	flags := r.uint(3)
+	count := r.uint(4)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		id := r.uint(fieldSize(version >= 1))
+		n := int(r.uint(1))
+		for j := 0; j < n && r.err == nil; j++ {
+			if flags&1 != 0 {
+				associations[id] = append(associations[id], int(r.uint(2)&0x7FFF))
+			} else {
+				associations[id] = append(associations[id], int(r.uint(1)&0x7F))
+			}
+		}
+	}
+}
+
+// exifFields reads the capture time, as identify formats it for parseCaptureTime, and whether GPS
+// coordinates are present from a TIFF-structured EXIF payload.
+func exifFields(exif []byte) (captured string, gps bool) {
+	if len(exif) < 8 {
+		return "", false
+	}
+	var order binary.ByteOrder
+	switch string(exif[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return "", false
+	}
+	// ifd returns the tags of the IFD at offset with their raw 4-byte value fields.
+	ifd := func(offset uint32) map[uint16][]byte {
+		tags := make(map[uint16][]byte)
+		if uint64(offset)+2 > uint64(len(exif)) {
+			return tags
+		}
+		n := int(order.Uint16(exif[offset:]))
+		for i := 0; i < n; i++ {
+			entry := uint64(offset) + 2 + uint64(i)*12
+			if entry+12 > uint64(len(exif)) {
+				break
+			}
+			tags[order.Uint16(exif[entry:])] = exif[entry : entry+12]
+		}
+		return tags
+	}
+	// ascii returns the string value of an ASCII tag entry.
+	ascii := func(entry []byte) string {
+		count := order.Uint32(entry[4:])
+		value := entry[8:12]
+		if count > 4 {
+			offset := order.Uint32(entry[8:])
+			if uint64(offset)+uint64(count) > uint64(len(exif)) {
+				return ""
+			}
+			value = exif[offset : offset+count]
+		} else {
+			value = value[:count]
+		}
+		for i, c := range value {
+			if c == 0 {
+				return string(value[:i])
+			}
+		}
+		return string(value)
+	}
+
+	ifd0 := ifd(order.Uint32(exif[4:]))
+	if entry, ok := ifd0[0x8825]; ok {
+		gpsTags := ifd(order.Uint32(entry[8:]))
+		_, gps = gpsTags[0x0002] // GPSLatitude
+	}
+	if entry, ok := ifd0[0x8769]; ok {
+		exifTags := ifd(order.Uint32(entry[8:]))
+		if date, ok := exifTags[0x9003]; ok { // DateTimeOriginal
+			captured = ascii(date)
+			if offset, ok := exifTags[0x9011]; ok { // OffsetTimeOriginal
+				captured += "|" + ascii(offset)
+			}
+		}
+	}
+	return captured, gps
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// box assembles an ISO BMFF box from its type and payload parts.
+func box(typ string, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	return bytes.Join([][]byte{u32(uint32(8 + len(payload))), []byte(typ), payload}, nil)
+}
+
+// fullBox assembles a box whose payload starts with a version and zero flags.
+func fullBox(typ string, version byte, parts ...[]byte) []byte {
+	return box(typ, append([][]byte{{version, 0, 0, 0}}, parts...)...)
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+// infe returns a version 2 item info entry.
+func infe(id uint16, typ string) []byte {
+	return fullBox("infe", 2, u16(id), u16(0), []byte(typ), []byte{0})
+}
+
+// testExif is an EXIF item payload whose DateTimeOriginal is 2021:05:04 10:11:12.
+func testExif() []byte {
+	tiff := bytes.Join([][]byte{
+		[]byte("MM\x00\x2a"), u32(8),
+		// IFD0 at 8: the ExifIFD pointer.
+		u16(1), u16(0x8769), u16(4), u32(1), u32(26), u32(0),
+		// ExifIFD at 26: DateTimeOriginal, stored at 44.
+		u16(1), u16(0x9003), u16(2), u32(20), u32(44), u32(0),
+		[]byte("2021:05:04 10:11:12\x00"),
+	}, nil)
+	return append(u32(0), tiff...)
+}
+
+// heifFile describes a crafted HEIF file for buildHEIF.
+type heifFile struct {
+	primary uint16
+	// items are the item types, numbered from 1.
+	items []string
+	// refs are extra iref boxes.
+	refs [][]byte
+	// exif adds an Exif item describing the primary image, stored in mdat.
+	exif bool
+	// exifLength overrides the length iloc records for the Exif item.
+	exifLength uint32
+	// depth is the primary image's bits per channel, 10 when zero.
+	depth uint8
+	// transfer adds an nclx colr property with these transfer characteristics to the primary image.
+	transfer uint16
+}
+
+// buildHEIF returns the bytes of a HEIF file with a 4000x3000 primary image, 10-bit unless f.depth says.
+func buildHEIF(f heifFile) []byte {
+	ftyp := box("ftyp", []byte("heic"), u32(0), []byte("mif1heic"))
+	exif := testExif()
+	exifID := uint16(len(f.items) + 1)
+	meta := func(exifOffset uint32) []byte {
+		var entries [][]byte
+		for i, typ := range f.items {
+			entries = append(entries, infe(uint16(i+1), typ))
+		}
+		refs := append([][]byte(nil), f.refs...)
+		var iloc []byte
+		if f.exif {
+			entries = append(entries, infe(exifID, "Exif"))
+			refs = append(refs, box("cdsc", u16(exifID), u16(1), u16(f.primary)))
+			length := uint32(len(exif))
+			if f.exifLength > 0 {
+				length = f.exifLength
+			}
+			iloc = fullBox("iloc", 0, []byte{0x44, 0x00}, u16(1), u16(exifID), u16(0), u16(1), u32(exifOffset), u32(length))
+		}
+		depth := f.depth
+		if depth == 0 {
+			depth = 10
+		}
+		props := [][]byte{fullBox("ispe", 0, u32(4000), u32(3000)), fullBox("pixi", 0, []byte{3, depth, depth, depth})}
+		assoc := []byte{2, 0x81, 0x02}
+		if f.transfer > 0 {
+			props = append(props, box("colr", []byte("nclx"), u16(9), u16(f.transfer), u16(9), []byte{0x80}))
+			assoc = []byte{3, 0x81, 0x02, 0x83}
+		}
+		ipco := box("ipco", props...)
+		ipma := fullBox("ipma", 0, u32(1), u16(f.primary), assoc)
+		return fullBox("meta", 0,
+			fullBox("pitm", 0, u16(f.primary)),
+			fullBox("iinf", 0, append([][]byte{u16(uint16(len(entries)))}, entries...)...),
+			iloc,
+			fullBox("iref", 0, refs...),
+			box("iprp", ipco, ipma),
+		)
+	}
+	offset := uint32(len(ftyp) + len(meta(0)) + 8)
+	return bytes.Join([][]byte{ftyp, meta(offset), box("mdat", exif)}, nil)
+}
+
+// writeTemp writes data to a file in a test directory and returns its path.
+func writeTemp(t testing.TB, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.heic")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseHEIF(t *testing.T) {
+	plain := buildHEIF(heifFile{primary: 1, items: []string{"hvc1"}, exif: true})
+	ftyp := box("ftyp", []byte("heic"), u32(0), []byte("mif1heic"))
+	tests := []struct {
+		name     string
+		data     []byte
+		want     heifInfo
+		captured string
+		err      string
+	}{
+		{
+			name:     "single image with EXIF",
+			data:     plain,
+			want:     heifInfo{Brand: "heic", Width: 4000, Height: 3000, BitDepth: 10, Images: 1},
+			captured: "2021:05:04 10:11:12",
+		},
+		{
+			name: "primary is the second image",
+			data: buildHEIF(heifFile{primary: 2, items: []string{"hvc1", "hvc1"}}),
+			want: heifInfo{Brand: "heic", Width: 4000, Height: 3000, BitDepth: 10, Images: 2, Primary: 1},
+		},
+		{
+			name: "nclx transfer characteristics",
+			data: buildHEIF(heifFile{primary: 1, items: []string{"hvc1"}, depth: 8, transfer: 16}),
+			want: heifInfo{Brand: "heic", Width: 4000, Height: 3000, BitDepth: 8, Transfer: 16, Images: 1},
+		},
+		{
+			name: "grid tiles are not images of their own",
+			data: buildHEIF(heifFile{primary: 1, items: []string{"grid", "hvc1", "hvc1"}, refs: [][]byte{box("dimg", u16(1), u16(2), u16(2), u16(3))}}),
+			want: heifInfo{Brand: "heic", Width: 4000, Height: 3000, BitDepth: 10, Images: 1},
+		},
+		{
+			name: "thumbnail before the primary",
+			data: buildHEIF(heifFile{primary: 2, items: []string{"hvc1", "hvc1"}, refs: [][]byte{box("thmb", u16(1), u16(1), u16(2))}}),
+			want: heifInfo{Brand: "heic", Width: 4000, Height: 3000, BitDepth: 10, Images: 1},
+		},
+		{
+			name: "image sequence without meta",
+			data: box("ftyp", []byte("msf1"), u32(0), []byte("msf1")),
+			want: heifInfo{Brand: "msf1", Compatible: []string{"msf1"}},
+		},
+		{
+			name: "no ftyp",
+			data: box("mdat", []byte("pixels")),
+			err:  "no ftyp box",
+		},
+		{
+			name: "truncated meta box",
+			data: append(ftyp, plain[len(ftyp):len(ftyp)+40]...),
+			err:  `truncated "meta" box`,
+		},
+		{
+			name: "box smaller than its header",
+			data: append(append([]byte(nil), ftyp...), append(u32(4), []byte("meta")...)...),
+			err:  "invalid size",
+		},
+		{
+			name: "oversized meta box",
+			data: append(append([]byte(nil), ftyp...), append(u32(maxMetaBoxSize+16), []byte("meta")...)...),
+			err:  "too large",
+		},
+		{
+			name: "oversized child box",
+			data: append(append([]byte(nil), ftyp...), fullBox("meta", 0, u32(1<<20), []byte("iinf"))...),
+			err:  "invalid size",
+		},
+		{
+			name: "truncated iloc",
+			data: append(append([]byte(nil), ftyp...), fullBox("meta", 0, fullBox("pitm", 0, u16(1)), fullBox("iloc", 0, []byte{0x44, 0x00}, u16(3)))...),
+			err:  "invalid iloc box",
+		},
+		{
+			name: "oversized EXIF item",
+			data: buildHEIF(heifFile{primary: 1, items: []string{"hvc1"}, exif: true, exifLength: maxExifSize + 1}),
+			err:  "EXIF item is too large",
+		},
+		{
+			name: "EXIF item past the end of the file",
+			data: buildHEIF(heifFile{primary: 1, items: []string{"hvc1"}, exif: true, exifLength: 4096}),
+			err:  "failed to read the EXIF item",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parseHEIF(writeTemp(t, tt.data))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("parseHEIF() error = %v, want it to mention %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseHEIF() error = %v", err)
+			}
+			captured, _ := exifFields(info.Exif)
+			if captured != tt.captured {
+				t.Errorf("captured = %q, want %q", captured, tt.captured)
+			}
+			info.Exif = nil
+			if tt.want.Compatible == nil {
+				tt.want.Compatible = []string{"mif1", "heic"}
+			}
+			if info.Brand != tt.want.Brand || strings.Join(info.Compatible, ",") != strings.Join(tt.want.Compatible, ",") ||
+				info.Width != tt.want.Width || info.Height != tt.want.Height || info.BitDepth != tt.want.BitDepth ||
+				info.Images != tt.want.Images || info.Primary != tt.want.Primary {
+				t.Errorf("parseHEIF() = %+v, want %+v", info, tt.want)
+			}
+		})
+	}
+}
+
+func FuzzParseHEIF(f *testing.F) {
+	f.Add(buildHEIF(heifFile{primary: 1, items: []string{"hvc1"}, exif: true}))
+	f.Add(buildHEIF(heifFile{primary: 1, items: []string{"grid", "hvc1", "hvc1"}, refs: [][]byte{box("dimg", u16(1), u16(2), u16(2), u16(3))}}))
+	f.Add(box("ftyp", []byte("msf1"), u32(0), []byte("msf1")))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		info, err := parseHEIF(writeTemp(t, data))
+		if err != nil {
+			return
+		}
+		exifFields(info.Exif)
+		if info.Primary < 0 || (info.Primary > 0 && info.Primary >= info.Images) {
+			t.Errorf("Primary = %d outside the %d images", info.Primary, info.Images)
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rebuildIndex walks the output tree, the -outdir or otherwise the input directory, and matches each
+// output back to the source under dirPath that maps to it, returning a state entry marked done for
+// every matched source and the number of outputs that match none. Both trees are descended only with
+// -recursive.
+func rebuildIndex(dirPath string) (map[string]stateEntry, int, error) {
+	sources, err := scanHeicFiles(dirPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	// Naming is planned as a conversion run would, so numbered names from collisions match too.
+	planOutputNames(sources)
+	bySource := make(map[string]string, len(sources))
+	for _, source := range sources {
+		out, err := outputPath(source)
+		if err != nil {
+			return nil, 0, err
+		}
+		bySource[out] = source
+	}
+
+	root := *outDir
+	if root == "" {
+		root = dirPath
+	}
+	ext := "." + strings.ToLower(outputExtension())
+	entries := make(map[string]stateEntry)
+	unmatched := 0
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && !*recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.ToLower(filepath.Ext(path)) != ext {
+			return nil
+		}
+		source, ok := bySource[path]
+		if !ok {
+			unmatched++
+			return nil
+		}
+		sum, err := hashFile(source)
+		if err != nil {
+			return err
+		}
+		entries[source] = stateEntry{Source: source, SHA256: sum, Output: path, Status: stateDone}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to walk output directory: %v", err)
+	}
+	return entries, unmatched, nil
+}
+
+// runRebuildIndex regenerates the -state file that -resume reads from the outputs already present for
+// the input directory, replacing whatever it held.
+func runRebuildIndex(inPathInfo os.FileInfo) error {
+	if !inPathInfo.IsDir() {
+		return errors.New("-rebuild-index requires a directory input")
+	}
+	if remote.target != "" {
+		return errors.New("-rebuild-index requires a local -outdir")
+	}
+	entries, unmatched, err := rebuildIndex(*inPath)
+	if err != nil {
+		return err
+	}
+	runState.path = resolveStatePath(inPathInfo)
+	runState.entries = entries
+	if err := saveState(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "INFO: Rebuilt state file %s with %d entries.\n", runState.path, len(entries))
+	if unmatched > 0 {
+		fmt.Fprintf(os.Stdout, "INFO: %d outputs match no source and were left out.\n", unmatched)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRebuildIndex(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	out := filepath.Join(dir, "out")
+	a := writeFile(t, src, "a.heic", "a")
+	b := writeFile(t, src, "b.heic", "b")
+	c := writeFile(t, src, "2024/c.heic", "c")
+	writeFile(t, out, "a.jpg", "encoded")
+	writeFile(t, out, "2024/c.jpg", "encoded")
+	writeFile(t, out, "stray.jpg", "no source")
+	writeFile(t, out, "a.jpg.json", "sidecar")
+	setFlag(t, outType, "jpg")
+	setFlag(t, outDir, out)
+	setFlag(t, relativeTo, src)
+
+	tests := []struct {
+		name      string
+		recursive bool
+		want      []string
+	}{
+		{name: "top level", want: []string{a}},
+		{name: "recursive", recursive: true, want: []string{c, a}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, recursive, tt.recursive)
+			entries, unmatched, err := rebuildIndex(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for source, e := range entries {
+				if e.Status != stateDone || e.SHA256 == "" || filepath.Dir(e.Output) == src {
+					t.Errorf("entry %+v, want a done entry with its hash and output", e)
+				}
+				got = append(got, source)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) || unmatched != 1 {
+				t.Errorf("rebuildIndex() = %q, %d unmatched, want %q, 1", got, unmatched, tt.want)
+			}
+		})
+	}
+
+	// The rebuilt state file is the one -resume reads.
+	setFlag(t, recursive, true)
+	setFlag(t, resume, true)
+	setFlag(t, statePath, filepath.Join(dir, "state.json"))
+	setFlag(t, inPath, src)
+	t.Cleanup(func() { runState.path, runState.entries = "", nil })
+	writeFile(t, dir, "state.json", "corrupt{")
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runRebuildIndex(info); err != nil {
+		t.Fatal(err)
+	}
+	runState.entries = nil
+	if err := loadState(info); err != nil {
+		t.Fatal(err)
+	}
+	if got := excludeResumed([]string{a, b, c}); !slices.Equal(got, []string{b}) {
+		t.Errorf("excludeResumed() after the rebuild = %q, want only %s", got, b)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stringList collects every value of a repeatable flag such as -input, in order.
+type stringList []string
+
+// newStringList registers a repeatable string flag.
+func newStringList(name, usage string) *stringList {
+	p := new(stringList)
+	flag.Var(p, name, usage)
+	return p
+}
+
+func (p *stringList) String() string {
+	return strings.Join(*p, ", ")
+}
+
+func (p *stringList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// resolveInputs expands several -input values into one list of HEIC sources: patterns are matched,
+// directories are listed (recursively with -recursive) and files are taken as-is. Inputs that yield
+// no HEIC files are skipped with a warning, and the paths are made absolute in place.
+func resolveInputs(paths stringList) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	for i, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %v", err)
+		}
+		paths[i] = abs
+
+		if hasGlobMeta(abs) {
+			matches, err := expandGlob(abs)
+			if err != nil {
+				fmt.Fprintf(os.Stdout, "WARN: Skipping input %s: %v\n", abs, err)
+				continue
+			}
+			for _, m := range matches {
+				add(m)
+			}
+			continue
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return nil, fmt.Errorf("input path error: %v", err)
+		}
+		if info.IsDir() {
+			dirFiles, err := collectHeicFiles(abs)
+			if err != nil {
+				fmt.Fprintf(os.Stdout, "WARN: Skipping input %s: %v\n", abs, err)
+				continue
+			}
+			for _, f := range dirFiles {
+				add(f)
+			}
+			continue
+		}
+		if err := checkHeicSource(abs); err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: Skipping input: %v.\n", err)
+			continue
+		}
+		add(abs)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("none of the -input paths contain HEIC files")
+	}
+	return files, nil
+}
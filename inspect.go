@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// inspectRecord is what the inspect subcommand reports for one file.
+type inspectRecord struct {
+	Source     string   `json:"source"`
+	Brand      string   `json:"brand,omitempty"`
+	Compatible []string `json:"compatible_brands,omitempty"`
+	HEIC       bool     `json:"heic"`
+	Width      int      `json:"width,omitempty"`
+	Height     int      `json:"height,omitempty"`
+	BitDepth   int      `json:"bit_depth,omitempty"`
+	Images     int      `json:"images"`
+	Captured   string   `json:"captured,omitempty"`
+	GPS        bool     `json:"gps"`
+	LivePhoto  string   `json:"live_photo,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// runInspect runs the inspect subcommand: it reads the container of each file, or of each HEIC file in
+// each directory, and prints what it finds without converting anything or needing ImageMagick.
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print one JSON object per file instead of text")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s inspect [-json] <file|dir>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return withExitCode(exitUsage, errors.New("inspect needs at least one file or directory"))
+	}
+
+	var files []string
+	for _, arg := range fs.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return fmt.Errorf("input path error: %v", err)
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		entries, err := os.ReadDir(arg)
+		if err != nil {
+			return fmt.Errorf("failed to read directory: %v", err)
+		}
+		for _, e := range entries {
+			if !e.IsDir() && isHeicFile(e.Name()) {
+				files = append(files, filepath.Join(arg, e.Name()))
+			}
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	failed := 0
+	for _, file := range files {
+		rec := inspectFile(file)
+		if rec.Error != "" {
+			failed++
+		}
+		if *asJSON {
+			if err := enc.Encode(rec); err != nil {
+				return fmt.Errorf("failed to write JSON: %v", err)
+			}
+			continue
+		}
+		printInspectRecord(rec)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to inspect %d of %d files", failed, len(files))
+	}
+	return nil
+}
+
+// inspectFile describes one file. Problems reading it are reported in the record's Error.
+func inspectFile(file string) inspectRecord {
+	rec := inspectRecord{Source: file, LivePhoto: findLiveSidecar(file)}
+	info, err := parseHEIF(file)
+	rec.Brand, rec.Compatible = info.Brand, info.Compatible
+	for _, brand := range append([]string{info.Brand}, info.Compatible...) {
+		if _, ok := heifBrands[brand]; ok {
+			rec.HEIC = true
+		}
+	}
+	if err != nil {
+		rec.Error = err.Error()
+		return rec
+	}
+	rec.Width, rec.Height, rec.BitDepth, rec.Images = info.Width, info.Height, info.BitDepth, info.Images
+	var captured string
+	captured, rec.GPS = exifFields(info.Exif)
+	rec.Captured = strings.Replace(captured, "|", " ", 1)
+	if !rec.HEIC {
+		rec.Error = fmt.Sprintf("not a HEIC file, its ftyp brand is %q", info.Brand)
+	}
+	return rec
+}
+
+// printInspectRecord prints a record as an indented block of text.
+func printInspectRecord(rec inspectRecord) {
+	fmt.Fprintln(os.Stdout, rec.Source)
+	field := func(name, value string) {
+		fmt.Fprintf(os.Stdout, "  %-12s %s\n", name+":", value)
+	}
+	if rec.Brand != "" {
+		field("Brand", fmt.Sprintf("%s (compatible: %s)", rec.Brand, strings.Join(rec.Compatible, ", ")))
+	}
+	if rec.Error != "" {
+		field("Error", rec.Error)
+		return
+	}
+	if rec.Width > 0 {
+		field("Dimensions", fmt.Sprintf("%dx%d", rec.Width, rec.Height))
+	}
+	if rec.BitDepth > 0 {
+		field("Bit depth", fmt.Sprint(rec.BitDepth))
+	}
+	field("Images", fmt.Sprint(rec.Images))
+	if rec.Captured != "" {
+		field("Captured", rec.Captured)
+	}
+	field("GPS", yesNo(rec.GPS))
+	if rec.LivePhoto != "" {
+		field("Live Photo", rec.LivePhoto)
+	} else {
+		field("Live Photo", "no")
+	}
+}
+
+// yesNo formats a boolean for text output.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// errInterrupted marks work stopped by Ctrl-C or SIGTERM.
+var errInterrupted = errors.New("interrupted")
+
+// interrupt is the context interruptContext hands out, created on first use.
+var interrupt struct {
+	once sync.Once
+	ctx  context.Context
+}
+
+// interruptContext returns a context that is canceled on the first Ctrl-C or SIGTERM. Later signals get
+// the default behavior again, so a second Ctrl-C exits immediately. Every call returns the same context,
+// so the downloads of a remote -input and the conversions after them stop on the same signal.
+func interruptContext() context.Context {
+	interrupt.once.Do(func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-ctx.Done()
+			stop()
+			fmt.Fprintln(os.Stderr, "WARN: Interrupted, stopping conversions in progress. Press Ctrl-C again to exit immediately.")
+		}()
+		interrupt.ctx = ctx
+	})
+	return interrupt.ctx
+}
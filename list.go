@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errMissingInput marks a listed source that does not exist. It is reported separately from conversion failures.
+var errMissingInput = errors.New("input file is missing")
+
+// readInputList reads one path per line from the -files-from file, or from stdin when path is "-".
+// Blank lines and lines starting with # are ignored; relative paths are resolved against the working directory.
+func readInputList(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open input list: %v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var files []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		abs, err := filepath.Abs(line)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path of %s: %v", line, err)
+		}
+		files = append(files, abs)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read input list: %v", err)
+	}
+	return files, nil
+}
+
+// splitMissing partitions listed files into those that exist and those that do not.
+func splitMissing(files []string) (present, missing []string) {
+	for _, file := range files {
+		if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) {
+			missing = append(missing, file)
+			continue
+		}
+		present = append(present, file)
+	}
+	return present, missing
+}
+
+// listedFiles reads the -files-from list and reports missing entries, returning them apart from the
+// entries that exist. Missing entries are skipped unless -fail-on-missing is set, in which case the run
+// is aborted before any conversion starts; they are returned either way so they can be recorded.
+func listedFiles() (present, missing []string, err error) {
+	files, err := readInputList(*filesFrom)
+	if err != nil {
+		return nil, nil, err
+	}
+	present, missing = splitMissing(files)
+	reportMissing(missing)
+	if *failOnMissing && len(missing) > 0 {
+		return nil, missing, fmt.Errorf("%d listed inputs are missing", len(missing))
+	}
+	if len(present) == 0 {
+		return nil, missing, errors.New("no listed input files exist")
+	}
+	return present, missing, nil
+}
+
+// recordMissing records a missing result for each listed input that does not exist, so they show up in
+// the summary, -jsonl, -manifest and -report like sources that disappear during the run.
+func recordMissing(missing []string) {
+	for _, file := range missing {
+		res := fileResult{Source: file, Status: statusMissing, Err: fmt.Errorf("%w: %s", errMissingInput, file)}
+		countResult(res)
+		if err := recordResult(res); err != nil {
+			fmt.Fprintf(os.Stderr, "WARN: %v\n", err)
+		}
+	}
+}
+
+// reportMissing logs each missing input and how many there were.
+func reportMissing(missing []string) {
+	for _, file := range missing {
+		fmt.Fprintf(os.Stdout, "WARN: Missing input %s.\n", file)
+	}
+	if len(missing) > 0 {
+		fmt.Fprintf(infoOut, "INFO: %d inputs missing.\n", len(missing))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestListedFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := writeFile(t, dir, "a.heic", "x")
+	b := writeFile(t, dir, "b.heic", "x")
+	gone := filepath.Join(dir, "gone.heic")
+	alsoGone := filepath.Join(dir, "sub", "also gone.heic")
+
+	tests := []struct {
+		name        string
+		list        string
+		strict      bool
+		wantPresent []string
+		wantMissing []string
+		err         string
+	}{
+		{
+			name:        "present and absent entries",
+			list:        "# batch\n" + a + "\n\n" + gone + "\n  " + b + "  \n" + alsoGone + "\n",
+			wantPresent: []string{a, b},
+			wantMissing: []string{gone, alsoGone},
+		},
+		{name: "all present", list: a + "\n" + b + "\n", wantPresent: []string{a, b}},
+		{name: "all absent", list: gone + "\n", wantMissing: []string{gone}, err: "no listed input files exist"},
+		{name: "-fail-on-missing", list: a + "\n" + gone + "\n", strict: true, wantMissing: []string{gone}, err: "1 listed inputs are missing"},
+		{name: "-fail-on-missing with none missing", list: a + "\n", strict: true, wantPresent: []string{a}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, filesFrom, writeFile(t, t.TempDir(), "list.txt", tt.list))
+			setFlag(t, failOnMissing, tt.strict)
+			var present, missing []string
+			var err error
+			captureStdout(t, func() { present, missing, err = listedFiles() })
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("listedFiles() error = %v, want it to mention %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(present, tt.wantPresent) || !slices.Equal(missing, tt.wantMissing) {
+				t.Errorf("listedFiles() = %q, %q missing, want %q, %q missing", present, missing, tt.wantPresent, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestRecordMissing(t *testing.T) {
+	dir := t.TempDir()
+	jsonlPath := filepath.Join(dir, "results.jsonl")
+	if err := openResultLog(jsonlPath); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		closeResultLog()
+		resultLog.file = nil
+	})
+	gone := []string{filepath.Join(dir, "a.heic"), filepath.Join(dir, "b.heic")}
+	before := statusCount(statusMissing)
+	recordMissing(gone)
+
+	if n := statusCount(statusMissing) - before; n != len(gone) {
+		t.Errorf("counted %d missing results, want %d", n, len(gone))
+	}
+	data, err := os.ReadFile(jsonlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(gone) {
+		t.Fatalf("JSONL has %d lines, want %d", len(lines), len(gone))
+	}
+	for i, line := range lines {
+		var rec resultRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Source != gone[i] || rec.Status != statusMissing || !strings.Contains(rec.Error, "input file is missing") {
+			t.Errorf("JSONL record %d = %+v, want a missing result for %s", i, rec, gone[i])
+		}
+	}
+}
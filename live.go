@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/nomadicGopher/Convert_HEIC/convert"
+)
+
+// liveSidecarExts are the extensions of the motion part of a Live Photo, as exported by iOS and macOS.
+var liveSidecarExts = []string{".MOV", ".mov"}
+
+// validateLivePhotos checks -live-photo-format and that ffmpeg is installed when transcoding.
+func validateLivePhotos() error {
+	if !*livePhotos {
+		return nil
+	}
+	switch *liveFormat {
+	case "mov":
+	case "mp4":
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			return errors.New("-live-photo-format mp4 requires the 'ffmpeg' command, please install FFmpeg")
+		}
+	default:
+		return fmt.Errorf("invalid -live-photo-format %q. Use 'mov' or 'mp4'", *liveFormat)
+	}
+	return nil
+}
+
+// findLiveSidecar returns the video sharing inFile's base name, or "" when the source is not a Live Photo.
+func findLiveSidecar(inFile string) string {
+	base := strings.TrimSuffix(inFile, filepath.Ext(inFile))
+	for _, ext := range liveSidecarExts {
+		if info, err := os.Stat(base + ext); err == nil && info.Mode().IsRegular() {
+			return base + ext
+		}
+	}
+	return ""
+}
+
+// pairLivePhoto places the motion part of a Live Photo next to outFile, copying it or, with
+// -live-photo-format mp4, transcoding it, and returns where it is. It returns "" for ordinary photos.
+func pairLivePhoto(ctx context.Context, inFile, outFile string) (string, error) {
+	sidecar := findLiveSidecar(inFile)
+	if sidecar == "" {
+		return "", nil
+	}
+	outBase := strings.TrimSuffix(outFile, filepath.Ext(outFile))
+	if *liveFormat == "mp4" {
+		dest := outBase + ".mp4"
+		cmd := convert.CommandContext(ctx, "ffmpeg", "-nostdin", "-loglevel", "error", "-y", "-i", sidecar, "-c:v", "libx264", "-pix_fmt", "yuv420p", "-c:a", "aac", "-movflags", "+faststart", dest)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			os.Remove(dest)
+			return "", fmt.Errorf("failed to transcode %s: %v: %s", sidecar, err, strings.TrimSpace(string(output)))
+		}
+		fmt.Fprintf(infoOut, "INFO: Transcoded Live Photo video %s to %s.\n", sidecar, dest)
+		return dest, nil
+	}
+
+	dest := outBase + filepath.Ext(sidecar)
+	if dest == sidecar {
+		// The output sits next to the source, so the video is already beside it.
+		return sidecar, nil
+	}
+	if err := copyFile(sidecar, dest); err != nil {
+		return "", fmt.Errorf("failed to copy %s to %s: %v", sidecar, dest, err)
+	}
+	fmt.Fprintf(infoOut, "INFO: Copied Live Photo video %s to %s.\n", sidecar, dest)
+	return dest, nil
+}
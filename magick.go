@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/nomadicGopher/Convert_HEIC/convert"
+)
+
+// magickBin is the ImageMagick entry point found by findMagick: "magick" for ImageMagick 7, where
+// convert, identify and montage are subcommands, or "convert" for ImageMagick 6.
+var magickBin = "convert"
+
+// magickLimitResources are the resources -limit accepts, with the environment variable ImageMagick reads
+// each one's limit from.
+var magickLimitResources = map[string]string{
+	"area":        "MAGICK_AREA_LIMIT",
+	"disk":        "MAGICK_DISK_LIMIT",
+	"file":        "MAGICK_FILE_LIMIT",
+	"height":      "MAGICK_HEIGHT_LIMIT",
+	"list-length": "MAGICK_LIST_LENGTH_LIMIT",
+	"map":         "MAGICK_MAP_LIMIT",
+	"memory":      "MAGICK_MEMORY_LIMIT",
+	"thread":      "MAGICK_THREAD_LIMIT",
+	"time":        "MAGICK_TIME_LIMIT",
+	"width":       "MAGICK_WIDTH_LIMIT",
+}
+
+// applyMagickSettings passes -limit and -magick-config to ImageMagick through the environment, so they
+// apply to every ImageMagick process, probes included, and override any limits set in the shell.
+func applyMagickSettings() error {
+	var applied []string
+	for _, limit := range *magickLimits {
+		resource, value, ok := strings.Cut(limit, "=")
+		resource = strings.ToLower(strings.TrimSpace(resource))
+		value = strings.TrimSpace(value)
+		env, known := magickLimitResources[resource]
+		if !ok || !known || value == "" {
+			return fmt.Errorf("invalid -limit %q, use RESOURCE=VALUE with a resource such as memory, map, disk, thread or time", limit)
+		}
+		if err := os.Setenv(env, value); err != nil {
+			return fmt.Errorf("failed to set %s: %v", env, err)
+		}
+		applied = append(applied, resource+"="+value)
+	}
+	if len(applied) > 0 {
+		fmt.Fprintln(infoOut, "INFO: ImageMagick limits:", strings.Join(applied, ", "))
+	}
+	if *magickConfig != "" {
+		// ImageMagick looks for policy.xml and its other configuration files here first.
+		if info, err := os.Stat(*magickConfig); err != nil || !info.IsDir() {
+			return fmt.Errorf("invalid -magick-config %q, it must be a directory containing policy.xml", *magickConfig)
+		}
+		if err := os.Setenv("MAGICK_CONFIGURE_PATH", *magickConfig); err != nil {
+			return fmt.Errorf("failed to set MAGICK_CONFIGURE_PATH: %v", err)
+		}
+	}
+	return nil
+}
+
+// findMagick resolves magickBin, preferring ImageMagick 7's magick and falling back to the legacy convert
+// tool. On Windows only magick is considered, as convert.exe there is the system's FAT to NTFS conversion tool.
+func findMagick() error {
+	bin, err := convert.FindMagick()
+	if err != nil {
+		return err
+	}
+	magickBin = bin
+	return nil
+}
+
+// magickTool returns the program and arguments that run an ImageMagick tool such as convert or
+// identify with the installed ImageMagick version.
+func magickTool(tool string, args ...string) (string, []string) {
+	return convert.Tool(magickBin, tool, args...)
+}
+
+// splitMagickTool reverses magickTool, returning the ImageMagick tool a command runs and its arguments.
+func splitMagickTool(name string, args []string) (string, []string) {
+	if name == "magick" && len(args) > 0 {
+		return args[0], args[1:]
+	}
+	return name, args
+}
+
+// magickCommand returns an exec.Cmd running an ImageMagick tool.
+func magickCommand(tool string, args ...string) *exec.Cmd {
+	name, args := magickTool(tool, args...)
+	return exec.Command(name, args...)
+}
+
+// magickCommandContext is like magickCommand but the command is stopped when ctx is done.
+func magickCommandContext(ctx context.Context, tool string, args ...string) *exec.Cmd {
+	name, args := magickTool(tool, args...)
+	return convert.CommandContext(ctx, name, args...)
+}
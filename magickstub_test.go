@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// stubMagickScript stands in for ImageMagick 7's magick. It logs each command line to $STUB_LOG.
+//
+// identify prints the contents of <file>.icc, if any, for %[icc:description], and otherwise the contents
+// of <file>.dims when it exists and $STUB_IDENTIFY when not.
+//
+// convert fails with the contents of <file>.fail on stderr when that exists for an input. Otherwise it
+// prints any <file>.warn to stderr and, with $STUB_SLEEP set, sleeps that long, appending how many
+// converts were running when it started to $STUB_LOG.running. An info: output prints the contents of
+// <input>.info. Any other output and every -write target get $STUB_BYTES bytes each, or
+// $STUB_BYTES_PER_QUALITY bytes per -quality point when that is set; an output ending in ":-" goes to
+// stdout, and one with a format prefix such as "miff:" goes to the path after it.
+const stubMagickScript = `#!/bin/sh
+tool=$1
+shift
+echo "$tool $*" >> "$STUB_LOG"
+if [ "$tool" = identify ]; then
+	for last; do :; done
+	file=${last%\[*\]}
+	case $* in
+	*icc:description*)
+		if [ -f "$file.icc" ]; then cat "$file.icc"; fi
+		exit 0
+		;;
+	esac
+	if [ -f "$file.dims" ]; then cat "$file.dims"; else printf '%s' "$STUB_IDENTIFY"; fi
+	exit 0
+fi
+for arg; do
+	file=${arg%\[*\]}
+	if [ -f "$file.fail" ]; then cat "$file.fail" >&2; exit 1; fi
+	if [ -f "$file.warn" ]; then cat "$file.warn" >&2; fi
+done
+if [ -n "$STUB_SLEEP" ]; then
+	touch "$STUB_LOG.$$.active"
+	ls "$STUB_LOG".*.active | wc -l >> "$STUB_LOG.running"
+	sleep "$STUB_SLEEP"
+	rm -f "$STUB_LOG.$$.active"
+fi
+for last; do :; done
+if [ "$last" = info: ]; then
+	file=${1%\[*\]}
+	if [ -f "$file.info" ]; then cat "$file.info"; fi
+	exit 0
+fi
+n=${STUB_BYTES:-4}
+prev=
+for arg; do
+	if [ "$prev" = -quality ] && [ -n "$STUB_BYTES_PER_QUALITY" ]; then n=$((arg * STUB_BYTES_PER_QUALITY)); fi
+	prev=$arg
+done
+prev=
+for arg; do
+	if [ "$prev" = -write ]; then head -c "$n" /dev/zero > "$arg"; fi
+	prev=$arg
+	last=$arg
+done
+case $last in
+*:-) head -c "$n" /dev/zero ;;
+[a-z]*:/*) head -c "$n" /dev/zero > "${last#*:}" ;;
+*) head -c "$n" /dev/zero > "$last" ;;
+esac
+`
+
+// stubMagick puts stubMagickScript on PATH as magick for the rest of the test and returns the log of the
+// command lines it ran.
+func stubMagick(t *testing.T) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the ImageMagick stub is a shell script")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "magick"), []byte(stubMagickScript), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "commands.log")
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("STUB_LOG", log)
+	setFlag(t, &magickBin, "magick")
+	var backend Converter = magickConverter{}
+	setFlag(t, &converter, backend)
+	return log
+}
+
+// stubCommands returns the command lines the stub has run so far.
+func stubCommands(t *testing.T, log string) []string {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// setFlag sets *p to v for the rest of the test, restoring the previous value afterwards.
+func setFlag[T any](t *testing.T, p *T, v T) {
+	t.Helper()
+	old := *p
+	*p = v
+	t.Cleanup(func() { *p = old })
+}
+
+// writeFile writes data to name in dir and returns its path.
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -2,147 +2,843 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nomadicGopher/Convert_HEIC/convert"
 )
 
 var (
-	outType       = flag.String("output", "", "Output image format: png, jpg, or jpeg (required)")
-	inPath        = flag.String("input", "", "File or directory path to convert (required)")
-	workers       = flag.Int("workers", 4, "Number of parallel conversions (only applies to directories)")
+	outType          = flag.String("output", "", "Output image format: png, jpg, jpeg, webp, avif, tiff, or bmp (required)")
+	inputs           = newStringList("input", "File or directory path, or glob pattern such as '/photos/**/*.heic', to convert; repeat to give several (required)")
+	sniff            = flag.Bool("sniff", false, "Detect HEIC sources by their ftyp box instead of their extension, picking up misnamed files")
+	outputExt        = flag.String("output-ext", "", "Extension for output files, independent of the -output format (e.g. jpeg or img)")
+	outDir           = flag.String("outdir", "", "Directory to write outputs to, or an s3:// or webdav:// URL to upload them to (default: next to each source)")
+	outputFile       = flag.String("output-file", "", "Write the output of a single file -input to this path, or to stdout with - (INFO lines are dropped and other output goes to stderr)")
+	relativeTo       = flag.String("relative-to", "", "With -outdir, recreate each source's directory path relative to this base under the output directory")
+	filesFrom        = flag.String("files-from", "", "Read source paths from this file, one per line, or from stdin with -")
+	failOnMissing    = flag.Bool("fail-on-missing", false, "Abort when a -files-from entry does not exist instead of skipping it")
+	deleteSource     = flag.Bool("delete-source", false, "Delete each source HEIC once its output has been written and verified")
+	deleteOriginals  = flag.Bool("delete-originals", false, "Same as -delete-source")
+	moveSources      = flag.String("move-originals", "", "Move each source HEIC into this directory once its output has been written and verified")
+	preserveTimes    = flag.Bool("preserve-times", false, "Give each output its source's modification time")
+	dryRun           = flag.Bool("dry-run", false, "Print each source and the output it would be converted to without running ImageMagick")
+	includes         = newStringList("include", "Only convert directory sources matching this pattern, e.g. '2023/**'; repeatable")
+	excludes         = newStringList("exclude", "Skip directory sources matching this pattern, e.g. '*/thumbnails/*'; repeatable")
+	overwrite        = flag.Bool("overwrite", false, "Reconvert sources whose output already exists instead of skipping them")
+	skipExisting     = flag.String("skip-existing", "any", "When an output exists, skip its source: any (always), mtime (unless the source is newer) or checksum (unless its content changed, needs -output-registry)")
+	renameOnConflict = flag.Bool("rename-on-conflict", false, "Write <base>_N.<ext> instead of skipping when the output already exists")
+	configPath       = flag.String("config", "", "Read flag defaults from this YAML file (default: "+defaultConfigName+" in the working directory, if present)")
+	recursive        = flag.Bool("recursive", false, "Also convert HEIC files in subdirectories of a directory -input")
+	workers          = newWorkerCount("workers", "Number of parallel conversions, or auto for one per CPU; only applies to directories")
+	maxMem           = flag.String("max-mem", "", "Estimated memory budget for concurrent conversions, e.g. 4G; large sources wait for room (default: no limit)")
+	magickLimits     = newStringList("limit", "ImageMagick resource limit as RESOURCE=VALUE, e.g. memory=2GiB or thread=2; repeatable")
+	magickConfig     = flag.String("magick-config", "", "Directory with an ImageMagick policy.xml to use instead of the system policy")
+	listDelegates    = flag.Bool("list-delegates", false, "Print which formats the installed ImageMagick can read and write, then exit")
+	rebuildIdx       = flag.Bool("rebuild-index", false, "Rebuild the -state file from the outputs already present, marking their sources done for -resume, then exit")
+	statePath        = flag.String("state", "", "Record each source's hash, output and status in this state file as the run goes (default with -resume: "+defaultStateName+" in the input directory)")
+	resume           = flag.Bool("resume", false, "Skip sources the state file marks done whose output still exists and whose content is unchanged")
+	jpegQuality      = flag.Int("quality", 0, "JPEG or HEIC quality from 1 to 100 (0 = ImageMagick's default; ignored for png)")
+	heicChroma       = flag.String("heic-chroma", "", "Chroma subsampling of the HEIC encoder with -output heic: 420, 422 or 444 (default: the encoder's)")
+	pngCompression   = flag.Int("png-compression", -1, "PNG zlib compression level from 0 (fastest) to 9 (smallest) (-1 = ImageMagick's default)")
+	resizeGeom       = flag.String("resize", "", "Fit outputs within WxH pixels keeping the aspect ratio (e.g. 1920x1080, 1920x or x1080)")
+	maxDimension     = flag.Int("max-dimension", 0, "Shrink outputs so neither side exceeds N pixels; smaller images are left as is (0 = no limit)")
+	sizesList        = flag.String("sizes", "", "Comma-separated renditions to write from one decode, e.g. full,1600,400 for photo.jpg, photo_1600.jpg and photo_400.jpg")
+	qualityScale     = flag.String("quality-scale", "", "Scale JPEG quality down as source megapixels grow, within FLOOR-CEILING bounds (e.g. 70-92)")
+	probeProfile     = flag.Bool("probe-profile", false, "Report the embedded ICC color profile of each source instead of converting")
+	sample           = flag.Int("sample", 0, "Convert only N randomly chosen files from the input (0 converts all)")
+	shuffle          = flag.Bool("shuffle", false, "Dispatch files to workers in random order to smooth resource usage")
+	seedFlag         = flag.Int64("seed", 0, "Seed for -sample and -shuffle, the only random choices a run makes; 0 picks a random seed and prints it")
+	burstPick        = flag.String("burst-pick", "", "Treat each leaf subdirectory as a burst and convert only one image from it: sharpest")
+	livePhotos       = flag.Bool("live-photos", false, "Keep the .MOV video of each Live Photo next to its converted still and record the pairing in reports")
+	retries          = flag.Int("retries", 0, "Retry a failed conversion up to N times, waiting -retry-backoff and then twice as long each time; unreadable sources are not retried")
+	retryBackoff     = flag.Duration("retry-backoff", time.Second, "Wait before the first -retries attempt, doubling for each further attempt")
+	failedDir        = flag.String("failed-dir", "", "Move sources that still fail into this directory, each with a <name>.err.txt holding the error and converter output")
+	failedLink       = flag.Bool("failed-symlink", false, "With -failed-dir, symlink failed sources there instead of moving them")
+	liveFormat       = flag.String("live-photo-format", "mov", "How -live-photos places the video: mov (copy it) or mp4 (transcode with ffmpeg)")
+	allFrames        = flag.Bool("all-frames", false, "Write every image of multi-image sources such as bursts as <base>_001.<ext>, <base>_002.<ext> and so on")
+	frameIndex       = flag.Int("frame", 0, "Convert only the Nth image of each source, counting from 1 (0 lets ImageMagick decide)")
+	montageMode      = flag.Bool("montage", false, "Tile the frames of multi-frame sources into a single contact sheet using ImageMagick's montage")
+	montageTile      = flag.String("montage-tile", "", "Tile geometry for -montage, e.g. 4x or 3x2 (default: ImageMagick chooses)")
+	montageLabel     = flag.String("montage-label", "%p", "Label format drawn under each -montage frame; empty disables labels")
+	dumpCommands     = flag.String("dump-commands", "", "Write each convert command to this shell script instead of running it")
+	withThumbnail    = flag.Bool("with-thumbnail", false, "Also write a <base>_thumb.jpg thumbnail for every converted file")
+	thumbSize        = flag.Int("thumb-size", 256, "Maximum width and height in pixels of -with-thumbnail thumbnails")
+	thumbDir         = flag.String("thumb-dir", "", "Directory for -with-thumbnail thumbnails (default: next to the output)")
+	backendName      = flag.String("backend", "magick", "Conversion backend: magick (ImageMagick), libheif (heif-convert), sips (macOS) or native (in-process libheif, needs a -tags libheif build)")
+	stripMeta        = flag.Bool("strip", false, "Remove EXIF, IPTC and XMP metadata from outputs, keeping the color profile; outputs keep their own modification times")
+	stripMetadata    = flag.Bool("strip-metadata", false, "Same as -strip")
+	stripProfile     = flag.Bool("strip-profile", false, "Remove the embedded ICC color profile from outputs; with -strip, remove all metadata for minimal outputs")
+	convertToSRGB    = flag.Bool("convert-to-srgb", false, "Transform colors from the source's profile (such as Display P3) into sRGB for viewers without color management")
+	srgbProfile      = flag.String("srgb-profile", "", "sRGB ICC profile used by -convert-to-srgb (default: the system's sRGB profile)")
+	autoOrient       = flag.Bool("auto-orient", true, "Rotate outputs to match the source's orientation; on by default")
+	noAutoOrient     = flag.Bool("no-auto-orient", false, "Keep the stored pixel orientation instead of rotating outputs upright (magick and native backends only)")
+	vendorFix        = flag.Bool("vendor-fixups", false, "Apply known per-vendor corrections, such as Samsung orientation, based on the EXIF Make")
+	autoGrayscale    = flag.Bool("auto-grayscale", false, "Store near-grayscale sources, such as scanned documents, as grayscale outputs")
+	toneMap          = flag.Bool("tone-map", false, "Tone-map HDR sources (high bit depth or PQ/HLG transfer) to SDR")
+	targetSize       = flag.String("target-size", "", "Lower JPEG quality until each output fits this size, e.g. 500KB or 2MB")
+	shrinkToFit      = flag.Bool("shrink-to-fit", false, "With -target-size, also reduce dimensions when quality 1 is still too large")
+	ioWorkers        = flag.Int("io-workers", 0, "Maximum number of outputs written to disk at once, independent of -workers (0 means no separate limit)")
+	skipIfNewer      = flag.Bool("skip-if-output-newer", false, "Skip sources whose existing output is newer than the source, protecting edited outputs")
+	warnOutputOver   = flag.String("warn-output-over", "", "Warn about any output larger than this size, e.g. 20MB")
+	onlyIfSmaller    = flag.Bool("only-if-smaller", false, "Discard the output when it is not smaller than the source HEIC")
+	metadataJSON     = flag.Bool("metadata-json", false, "Also write each source's EXIF/XMP metadata to <output>.meta.json")
+	metadataOnly     = flag.Bool("metadata-only", false, "Write <output>.meta.json metadata files without converting pixels")
+	manifestPath     = flag.String("manifest", "", "Write a CSV manifest with one row per processed file to this path")
+	skipFromManifest = flag.String("skip-from-manifest", "", "Skip sources that this earlier -manifest CSV records as converted")
+	jsonOutput       = flag.Bool("json", false, "Print one JSON record per file and a final summary record on stdout, moving all other output to stderr")
+	reportPath       = flag.String("report", "", "Write a JSON report with every file's result and the run summary to this path")
+	jsonlPath        = flag.String("jsonl", "", "Append one JSON record per processed file to this path as each file completes")
+	minSuccessRate   = flag.Float64("min-success-rate", 0, "Exit with an error when less than this fraction (0-1) of processed files succeeded")
+	breakerThreshold = flag.Float64("breaker-threshold", 0, "Abort when more than this fraction (0-1) of the first -breaker-window files fail; 0 disables")
+	breakerWindow    = flag.Int("breaker-window", 50, "Number of leading files considered by -breaker-threshold")
+	dedupe           = flag.Bool("dedupe", false, "Convert byte-identical sources once and report the others as duplicates of it")
+	dedupePixels     = flag.Bool("dedupe-pixels", false, "With -dedupe, also treat sources that decode to the same pixels as duplicates (decodes every source first)")
+	followSymlinks   = flag.Bool("follow-symlinks", false, "With -recursive, also descend into symlinked directories, walking each directory once even through link cycles")
+	preserveLinks    = flag.Bool("preserve-symlinks", false, "Recreate relative symlinks between sources as symlinks between their outputs instead of converting twice")
+	splitTiming      = flag.Bool("split-timing", false, "Run each conversion as separate decode and encode stages and report the time spent in each")
+	preflight        = flag.Bool("preflight", false, "Before converting, check that output directories are writable and their filesystems have space for the estimated output")
+	force            = flag.Bool("force", false, "Continue past failed safety checks such as -preflight, reporting them as warnings")
+	verifyTiles      = flag.Bool("verify-tiles", false, "Check that each output has the full dimensions declared by its source, scaled by -resize or -max-dimension, catching broken grid/tile assembly")
+	envFile          = flag.String("env-file", "", "Load KEY=VALUE environment variables for every conversion from this file; a <source>.env sidecar overrides it per file")
+	registryPath     = flag.String("output-registry", "", "Shared file recording which source each output came from, to warn about conflicting outputs across runs")
+	timeout          = flag.Duration("timeout", 0, "Kill a conversion that runs longer than this, e.g. 30s (0 = no timeout)")
+	timeoutPerMB     = flag.Duration("timeout-per-mb", 0, "Per-file conversion deadline per MB of source, e.g. 10s (0 = no deadline)")
+	minTimeout       = flag.Duration("min-timeout", 30*time.Second, "Lowest per-file deadline when -timeout-per-mb is set")
+	collectCorrupt   = flag.String("collect-corrupt", "", "File to list sources that failed because they are unreadable or corrupt")
+	watch            = flag.Bool("watch", false, "Keep running and convert new HEIC files as they appear in the -input directory, until Ctrl-C; polls rather than subscribing to file events")
+	watchInterval    = flag.Duration("watch-interval", 2*time.Second, "How often -watch rescans the input tree, listing and stating every file each time; a file is converted once it is unchanged for one interval")
+	diffMode         = flag.Bool("diff", false, "Convert only sources in the -input tree without an output in the -outdir tree")
+	failFast         = flag.Bool("fail-fast", false, "Same as -on-error fail")
+	onError          = flag.String("on-error", "continue", "What a failed conversion does: fail stops the run, continue converts the rest, prompt asks whether to go on")
+	verbose          = flag.Bool("verbose", false, "Print every command line and pass ImageMagick's output through to the terminal")
+	noProgress       = flag.Bool("no-progress", false, "Don't draw the live progress line on stderr, which is otherwise shown when stderr is a terminal")
+	quiet            = flag.Bool("quiet", false, "Only print warnings, errors and the final summary")
+	debugSerial      = flag.Bool("debug-serial", false, "Convert one file at a time, logging each file's full command and result in order")
+	cacheDir         = flag.String("cache-dir", "", "Reuse outputs cached here, keyed by source content and every conversion option")
+	failOnWarning    = flag.Bool("fail-on-warning", false, "Treat any ImageMagick output on stderr as a failed conversion, even if convert exits successfully")
+	// completedSources are the sources loaded from -skip-from-manifest, which are not dispatched again.
+	completedSources map[string]bool
+	// warnOverBytes is the parsed -warn-output-over threshold, or zero when disabled.
+	warnOverBytes int64
+	// inPath is the -input when exactly one was given, and empty otherwise.
+	inPath = new(string)
+	// infoOut receives INFO lines; -quiet discards them, leaving the summary, warnings and errors.
+	infoOut io.Writer = os.Stdout
+	// safeExtension restricts -output-ext to plain alphanumeric extensions.
+	safeExtension = regexp.MustCompile(`^[A-Za-z0-9]{1,10}$`)
+	// ioSlots limits concurrent output writes when -io-workers is set; nil means outputs are written by ImageMagick directly.
+	ioSlots       chan struct{}
 	validOutTypes = map[string]struct{}{
 		"png":  {},
 		"jpg":  {},
 		"jpeg": {},
+		"webp": {},
+		"avif": {},
+		"tiff": {},
+		"bmp":  {},
+		"heic": {},
+	}
+	// delegateOutTypes are the output types that depend on optional ImageMagick delegates, with the
+	// library that provides each one.
+	delegateOutTypes = map[string]string{
+		"webp": "libwebp",
+		"avif": "libheif with an AV1 encoder such as libaom",
+		"tiff": "libtiff",
+		"jpg":  "libjpeg",
+		"jpeg": "libjpeg",
+		"png":  "libpng",
+		"heic": "libheif with an HEVC encoder such as x265",
+	}
+	// validInExts are the accepted source extensions, compared case-insensitively.
+	validInExts = map[string]struct{}{
+		".heic": {},
+		".heif": {},
+		".hif":  {},
 	}
 )
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s -input <file|dir> -output <png|jpg|jpeg> [-workers N]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [convert|watch] -input <file|dir> -output <png|jpg|jpeg|webp|avif|tiff|bmp|heic> [-workers N]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s serve [-addr host:port] (see %s serve -h)\n", os.Args[0], os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s inspect [-json] <file|dir>...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s bench [-backends list] [-workers list] [sample.heic...]\n", os.Args[0])
 		flag.PrintDefaults()
+		fmt.Fprint(os.Stderr, configUsage)
+		fmt.Fprint(os.Stderr, exitCodeUsage)
+	}
+	subcommand, args := splitSubcommand(os.Args[1:])
+	if subcommand == "serve" || subcommand == "inspect" || subcommand == "bench" {
+		run := runServe
+		switch subcommand {
+		case "inspect":
+			run = runInspect
+		case "bench":
+			run = runBench
+		}
+		if err := run(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return
+			}
+			fatal(exitEnvironment, err)
+		}
+		return
+	}
+	// Report bad flags with exitUsage rather than the flag package's default of 2, which means an environment error here.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		printResultLine(exitUsage)
+		os.Exit(exitUsage)
+	}
+	if subcommand == "watch" {
+		*watch = true
+	}
+	configFile, err := applySettings(flag.CommandLine, subcommand, *configPath)
+	if err != nil {
+		fatal(exitUsage, err)
+	}
+	if *quiet {
+		infoOut = io.Discard
+	}
+	if *jsonOutput {
+		enableJSONOutput()
+	}
+	if configFile != "" {
+		fmt.Fprintln(infoOut, "INFO: Config:", configFile)
+	}
+	if len(*inputs) == 1 {
+		*inPath = (*inputs)[0]
+	}
+	if err := setupStreams(); err != nil {
+		fatal(exitEnvironment, err)
+	}
+	defer cleanupStreams()
+	defer cleanupRemote()
+	resolveWorkers()
+
+	if *listDelegates {
+		if err := runListDelegates(); err != nil {
+			fatal(exitEnvironment, err)
+		}
+		return
 	}
-	flag.Parse()
 
 	if err := validateRequiredFlags(); err != nil {
-		log.Fatalf("ERROR: %v\n", err)
+		fatal(exitUsage, err)
+	}
+
+	inPathInfo, err := validateFlags()
+	if err != nil {
+		fatal(exitUsage, err)
+	}
+
+	if *rebuildIdx {
+		if err := runRebuildIndex(inPathInfo); err != nil {
+			fatal(exitEnvironment, err)
+		}
+		return
 	}
 
 	if err := verifyRequirements(); err != nil {
-		log.Fatalf("ERROR: %v\n", err)
+		fatal(exitEnvironment, err)
 	}
 
-	inPathInfo, err := validateFlags()
+	if *probeProfile {
+		if err := runProfileProbe(inPathInfo); err != nil {
+			fatal(exitEnvironment, err)
+		}
+		return
+	}
+
+	if *jsonlPath != "" {
+		if err := openResultLog(*jsonlPath); err != nil {
+			fatal(exitEnvironment, err)
+		}
+		defer closeResultLog()
+	}
+
+	if *collectCorrupt != "" {
+		if err := openCorruptLog(*collectCorrupt); err != nil {
+			fatal(exitEnvironment, err)
+		}
+	}
+
+	if *skipFromManifest != "" {
+		if completedSources, err = readCompletedFromManifest(*skipFromManifest); err != nil {
+			fatal(exitEnvironment, err)
+		}
+	}
+
+	if *manifestPath != "" {
+		if err := openManifest(*manifestPath, *manifestPath == *skipFromManifest); err != nil {
+			fatal(exitEnvironment, err)
+		}
+		defer closeManifest()
+	}
+
+	if *registryPath != "" {
+		if err := loadOutputRegistry(*registryPath); err != nil {
+			fatal(exitEnvironment, err)
+		}
+	}
+
+	if (*statePath != "" || *resume) && !*dryRun {
+		if err := loadState(inPathInfo); err != nil {
+			fatal(exitEnvironment, err)
+		}
+	}
+
+	if *dumpCommands != "" {
+		if err := openCommandDump(*dumpCommands); err != nil {
+			fatal(exitEnvironment, err)
+		}
+	}
+
+	start := time.Now()
+	err = processFiles(interruptContext(), inPathInfo)
+	if *dryRun {
+		fmt.Fprintf(infoOut, "INFO: Dry run: %d files would be converted.\n", statusCount(statusDryRun))
+	}
+	if n := statusCount(statusExists); n > 0 {
+		fmt.Fprintf(infoOut, "INFO: Skipped %d files whose output already exists (use -overwrite to reconvert them).\n", n)
+	}
+	elapsed := time.Since(start)
+	printSummary(elapsed)
+	if reportErr := finishReport(elapsed); reportErr != nil {
+		log.Printf("ERROR: %v\n", reportErr)
+	}
+	if *splitTiming {
+		reportStageTotals()
+	}
+	if *registryPath != "" && !*dryRun {
+		if err := saveOutputRegistry(*registryPath); err != nil {
+			log.Printf("ERROR: %v\n", err)
+		}
+	}
+	if err := saveState(); err != nil {
+		log.Printf("ERROR: %v\n", err)
+	}
+	if uploadErr := finishRemote(); uploadErr != nil {
+		log.Printf("ERROR: %v\n", uploadErr)
+		if err == nil {
+			err = uploadErr
+		}
+	}
+	closeCorruptLog()
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+	}
+	if rateErr := checkSuccessRate(); rateErr != nil {
+		log.Printf("ERROR: %v\n", rateErr)
+		if err == nil {
+			err = rateErr
+		}
+	}
 	if err != nil {
-		log.Fatalf("ERROR: %v\n", err)
+		cleanupStreams()
+		cleanupRemote()
+		code := processingExitCode(err)
+		printResultLine(code)
+		os.Exit(code)
 	}
 
-	if err := processFiles(inPathInfo); err != nil {
-		log.Fatalf("ERROR: %v\n", err)
+	if err := closeCommandDump(); err != nil {
+		fatal(exitEnvironment, err)
 	}
+	if err := finishStreams(); err != nil {
+		fatal(exitEnvironment, err)
+	}
+
+	fmt.Fprintln(infoOut, "INFO: Processing completed successfully.")
+	printResultLine(0)
+}
 
-	fmt.Fprintln(os.Stdout, "INFO: Processing completed successfully.")
+// flagSet reports whether the named flag was given on the command line, as opposed to left at its default.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
 
 // validateRequiredFlags ensures required flags are provided.
 func validateRequiredFlags() error {
-	if strings.TrimSpace(*inPath) == "" || strings.TrimSpace(*outType) == "" {
+	if (len(*inputs) == 0 && *filesFrom == "") || strings.TrimSpace(*outType) == "" {
 		flag.Usage()
-		return errors.New("both -input and -output flags are required")
+		return errors.New("both -input (or -files-from) and -output flags are required")
 	}
 	return nil
 }
 
-// verifyRequirements checks that the operating system is supported and that ImageMagick with HEIC/HEIF support is installed.
+// verifyRequirements checks that the operating system is supported and that the selected backend is installed.
 func verifyRequirements() error {
 	osType := runtime.GOOS
 	switch osType {
-	case "linux":
-		// Verify ImageMagick is installed
-		if _, err := exec.LookPath("convert"); err != nil {
-			return errors.New("the 'convert' command does not exist, please ensure that ImageMagick is installed and accessible via PATH")
+	case "linux", "windows":
+		if err := converter.Verify(); err != nil {
+			return err
 		}
-
-		// Check if 'convert' supports HEIC
-		output, err := exec.Command("convert", "--version").CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("failed to run 'convert --version': %v", err)
+	case "darwin":
+		err := converter.Verify()
+		if err != nil && !flagSet("backend") {
+			// Fall back to the built-in sips when ImageMagick is missing and no backend was asked for.
+			if sips, sipsErr := newConverter("sips"); sipsErr == nil && sips.Verify() == nil {
+				fmt.Fprintf(os.Stdout, "WARN: %v; falling back to sips.\n", err)
+				converter, err = sips, nil
+			}
 		}
-		if !strings.Contains(strings.ToLower(string(output)), "heic") {
-			return errors.New("ImageMagick 'convert' does not support HEIC. Try installing libheif* and then reinstall ImageMagick")
+		if err != nil {
+			return err
 		}
-	case "windows":
-		return errors.New("currently, Windows is not supported")
-	case "darwin":
-		return errors.New("currently, Darwin/MacOS is not supported")
 	default:
 		return fmt.Errorf("%s is not supported", osType)
 	}
 
-	fmt.Fprintln(os.Stdout, "INFO: OS requirements are met.")
+	warnDroppedMetadata()
+	fmt.Fprintln(infoOut, "INFO: OS requirements are met.")
 	return nil
 }
 
-// validateFlags checks the command-line flags for validity and returns information about the input path.
-func validateFlags() (os.FileInfo, error) {
-	absPath, err := filepath.Abs(*inPath)
+// verifyImageMagick checks that ImageMagick is installed with HEIC/HEIF support, along with any extra tools the flags need.
+func verifyImageMagick() error {
+	// Verify ImageMagick is installed
+	if err := findMagick(); err != nil {
+		return err
+	}
+
+	// Check if ImageMagick supports HEIC
+	output, err := exec.Command(magickBin, "--version").CombinedOutput()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get absolute path: %v", err)
+		return fmt.Errorf("failed to run '%s --version': %v", magickBin, err)
+	}
+	if !strings.Contains(strings.ToLower(string(output)), "heic") {
+		return fmt.Errorf("ImageMagick '%s' does not support HEIC. Try installing libheif* and then reinstall ImageMagick", magickBin)
+	}
+	if magickVersion = convert.ParseVersion(string(output)); magickVersion != "" {
+		fmt.Fprintln(infoOut, "INFO: ImageMagick version:", magickVersion)
 	}
-	*inPath = absPath
 
-	inPathInfo, err := os.Stat(*inPath)
+	// Check the output format up front, so a missing delegate fails once here rather than on every file.
+	formatOut, err := exec.Command(magickBin, "-list", "format").Output()
 	if err != nil {
-		return nil, fmt.Errorf("input path error: %v", err)
+		return fmt.Errorf("failed to run '%s -list format': %v", magickBin, err)
+	}
+	if !convert.ParseFormatList(string(formatOut))[convert.FormatName(*outType)].Write {
+		if lib, ok := delegateOutTypes[*outType]; ok {
+			return fmt.Errorf("ImageMagick cannot write %s output. Install %s and then reinstall ImageMagick", *outType, lib)
+		}
+		return fmt.Errorf("ImageMagick cannot write %s output", *outType)
+	}
+
+	if *montageMode && magickBin == "convert" {
+		if _, err := exec.LookPath("montage"); err != nil {
+			return errors.New("the 'montage' command does not exist, it is required by -montage and ships with ImageMagick")
+		}
+	}
+	return nil
+}
+
+// magickVersion is the ImageMagick release found by verifyImageMagick, recorded with every result
+// so a batch can be tied to the tooling that produced it.
+var magickVersion string
+
+// validateFlags checks the command-line flags for validity and returns information about the input path.
+// With -files-from, a glob -input or several -input values the input path info is nil, as sources come from
+// the list, pattern or paths instead.
+func validateFlags() (os.FileInfo, error) {
+	var inPathInfo os.FileInfo
+	if err := validatePatterns(); err != nil {
+		return nil, err
+	}
+	if *verbose && *quiet {
+		return nil, errors.New("-verbose and -quiet cannot be used together")
 	}
-	fmt.Fprintln(os.Stdout, "INFO: Input Path:", *inPath)
 
+	// The output type decides which files count as sources, so it is checked before any input is listed.
 	outTypeLower := strings.ToLower(*outType)
 	if _, ok := validOutTypes[outTypeLower]; !ok {
-		return nil, errors.New("invalid output type. Use 'png', 'jpg', or 'jpeg'")
+		return nil, errors.New("invalid output type. Use 'png', 'jpg', 'jpeg', 'webp', 'avif', 'tiff', 'bmp', or 'heic'")
 	}
 	*outType = outTypeLower
-	fmt.Fprintln(os.Stdout, "INFO: Output Type:", *outType)
+	fmt.Fprintln(infoOut, "INFO: Output Type:", *outType)
+	if err := validateEncodeFlags(); err != nil {
+		return nil, err
+	}
+	if err := setupRemote(); err != nil {
+		return nil, err
+	}
+
+	if *filesFrom != "" {
+		if len(*inputs) > 0 {
+			return nil, errors.New("-input and -files-from cannot be used together")
+		}
+		if *rebuildIdx || *burstPick != "" {
+			return nil, errors.New("-rebuild-index and -burst-pick require -input")
+		}
+		fmt.Fprintln(infoOut, "INFO: Input List:", *filesFrom)
+	} else if len(*inputs) > 1 {
+		if *rebuildIdx || *burstPick != "" || *diffMode {
+			return nil, errors.New("-rebuild-index, -burst-pick and -diff require a single directory -input")
+		}
+		var err error
+		if expandedInputs, err = resolveInputs(*inputs); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(infoOut, "INFO: Inputs: %s (%d files)\n", inputs, len(expandedInputs))
+	} else {
+		absPath, err := filepath.Abs(*inPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %v", err)
+		}
+		*inPath = absPath
+		(*inputs)[0] = absPath
+
+		if hasGlobMeta(*inPath) {
+			if *rebuildIdx || *burstPick != "" {
+				return nil, errors.New("-rebuild-index and -burst-pick require a directory -input, not a pattern")
+			}
+			if expandedInputs, err = expandGlob(*inPath); err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(infoOut, "INFO: Input Pattern: %s (%d files)\n", *inPath, len(expandedInputs))
+		} else {
+			if inPathInfo, err = os.Stat(*inPath); err != nil {
+				return nil, withExitCode(exitEnvironment, fmt.Errorf("input path error: %v", err))
+			}
+			fmt.Fprintln(infoOut, "INFO: Input Path:", *inPath)
+		}
+	}
+
+	if *debugSerial {
+		// One worker keeps every file's command, output and result together and in dispatch order.
+		*workers = 1
+		fmt.Fprintln(infoOut, "INFO: Debug mode: converting one file at a time.")
+	}
+
+	if err := validateOutputFile(inPathInfo); err != nil {
+		return nil, err
+	}
+	if *diffMode {
+		if err := validateDiff(inPathInfo); err != nil {
+			return nil, err
+		}
+	}
+	if *watch {
+		if err := validateWatch(inPathInfo); err != nil {
+			return nil, err
+		}
+	}
+
+	if *outDir != "" && *relativeTo == "" && *inPath != "" {
+		// Mirror the input tree so same-named files in different subdirectories don't collide.
+		switch {
+		case *recursive && inPathInfo != nil && inPathInfo.IsDir():
+			*relativeTo = *inPath
+		case hasGlobMeta(*inPath) && strings.Contains(*inPath, "**"):
+			*relativeTo = globRoot(*inPath)
+		}
+	}
+
+	if err := validateOutputDir(); err != nil {
+		return nil, err
+	}
+
+	if *outputExt != "" {
+		*outputExt = strings.TrimPrefix(*outputExt, ".")
+		if !safeExtension.MatchString(*outputExt) {
+			return nil, fmt.Errorf("invalid -output-ext %q, use 1-10 letters or digits", *outputExt)
+		}
+		fmt.Fprintln(infoOut, "INFO: Output Extension:", *outputExt)
+	}
+
+	if *stripMetadata {
+		*stripMeta = true
+	}
+	if *noAutoOrient {
+		*autoOrient = false
+	}
+	if err := validateErrorPolicy(); err != nil {
+		return nil, err
+	}
+	if err := validateColorFlags(); err != nil {
+		return nil, err
+	}
+
+	if err := applyMagickSettings(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if converter, err = newConverter(*backendName); err != nil {
+		return nil, err
+	}
+
+	if *burstPick != "" && *burstPick != "sharpest" {
+		return nil, fmt.Errorf("invalid -burst-pick %q. Use 'sharpest'", *burstPick)
+	}
+
+	if *sample < 0 {
+		return nil, errors.New("-sample must not be negative")
+	}
+
+	if *withThumbnail {
+		if *thumbSize < 1 {
+			return nil, errors.New("-thumb-size must be at least 1")
+		}
+		if *thumbDir != "" && !*dryRun {
+			if err := os.MkdirAll(*thumbDir, 0o755); err != nil {
+				return nil, withExitCode(exitEnvironment, fmt.Errorf("failed to create thumbnail directory: %v", err))
+			}
+		}
+	}
+
+	if *targetSize != "" {
+		if !isJpegType(*outType) {
+			return nil, errors.New("-target-size requires jpg or jpeg output")
+		}
+		var err error
+		if targetBytes, err = parseByteSize(*targetSize); err != nil {
+			return nil, err
+		}
+	} else if *shrinkToFit {
+		return nil, errors.New("-shrink-to-fit requires -target-size")
+	}
+
+	if *minSuccessRate < 0 || *minSuccessRate > 1 {
+		return nil, errors.New("-min-success-rate must be between 0 and 1")
+	}
+
+	if *breakerThreshold < 0 || *breakerThreshold >= 1 {
+		return nil, errors.New("-breaker-threshold must be at least 0 and below 1")
+	}
+	if *breakerWindow < 1 {
+		return nil, errors.New("-breaker-window must be at least 1")
+	}
+
+	if *envFile != "" {
+		if globalEnv, err = parseEnvFile(*envFile); err != nil {
+			return nil, fmt.Errorf("failed to load -env-file: %v", err)
+		}
+	}
+
+	if *warnOutputOver != "" {
+		if warnOverBytes, err = parseByteSize(*warnOutputOver); err != nil {
+			return nil, err
+		}
+	}
+
+	if *cacheDir != "" {
+		if *withThumbnail {
+			return nil, errors.New("-cache-dir cannot be combined with -with-thumbnail")
+		}
+		if !*dryRun {
+			if err := os.MkdirAll(*cacheDir, 0o755); err != nil {
+				return nil, withExitCode(exitEnvironment, fmt.Errorf("failed to create cache directory: %v", err))
+			}
+		}
+	}
+
+	if *ioWorkers < 0 {
+		return nil, errors.New("-io-workers must not be negative")
+	}
+	if *ioWorkers > 0 {
+		ioSlots = make(chan struct{}, *ioWorkers)
+	}
+	if err := validateMaxMem(); err != nil {
+		return nil, err
+	}
+
+	if *jpegQuality != 0 {
+		if *jpegQuality < 1 || *jpegQuality > 100 {
+			return nil, fmt.Errorf("invalid -quality %d, must be between 1 and 100", *jpegQuality)
+		}
+		if *qualityScale != "" {
+			return nil, errors.New("-quality and -quality-scale cannot be used together")
+		}
+		if !isJpegType(*outType) && !encodingHEIC() {
+			fmt.Fprintln(os.Stdout, "WARN: -quality only applies to jpg/jpeg and heic output and will be ignored.")
+		}
+	}
+
+	if *pngCompression != -1 {
+		if *pngCompression < 0 || *pngCompression > 9 {
+			return nil, fmt.Errorf("invalid -png-compression %d, must be between 0 and 9", *pngCompression)
+		}
+		if *outType != "png" {
+			fmt.Fprintln(os.Stdout, "WARN: -png-compression only applies to png output and will be ignored.")
+		}
+	}
+
+	if *resizeGeom != "" {
+		if err := parseResize(*resizeGeom); err != nil {
+			return nil, err
+		}
+		if *maxDimension != 0 {
+			return nil, errors.New("-resize and -max-dimension cannot be used together")
+		}
+	}
+	if *maxDimension < 0 {
+		return nil, errors.New("-max-dimension must not be negative")
+	}
+	if err := validateSizes(); err != nil {
+		return nil, err
+	}
+	if *dedupePixels && !*dedupe {
+		return nil, errors.New("-dedupe-pixels requires -dedupe")
+	}
+
+	if err := validateOriginalsFlags(); err != nil {
+		return nil, err
+	}
+	if err := validateFrameFlags(); err != nil {
+		return nil, err
+	}
+	if err := validateLivePhotos(); err != nil {
+		return nil, err
+	}
+	if err := validateRetryFlags(); err != nil {
+		return nil, err
+	}
+	if *dryRun && (*metadataOnly || *dumpCommands != "" || *rebuildIdx) {
+		return nil, errors.New("-dry-run cannot be used with -metadata-only, -dump-commands or -rebuild-index")
+	}
+
+	if err := validateConflictFlags(); err != nil {
+		return nil, err
+	}
+
+	if *preserveTimes && *skipIfNewer {
+		fmt.Fprintln(os.Stdout, "WARN: Outputs written with -preserve-times are never newer than their source, so -skip-if-output-newer will reconvert them.")
+	}
+
+	if *timeout < 0 || *timeoutPerMB < 0 {
+		return nil, errors.New("-timeout and -timeout-per-mb must not be negative")
+	}
+	if *timeout > 0 && *timeoutPerMB > 0 {
+		return nil, errors.New("-timeout and -timeout-per-mb cannot be used together")
+	}
+
+	if *qualityScale != "" {
+		var err error
+		if qualityFloor, qualityCeil, err = parseQualityScale(*qualityScale); err != nil {
+			return nil, err
+		}
+		if !isJpegType(*outType) {
+			fmt.Fprintln(os.Stdout, "WARN: -quality-scale only applies to jpg/jpeg output and will be ignored.")
+		}
+	}
 
 	return inPathInfo, nil
 }
 
 // processFiles converts the input file or all files in the input directory to the specified output format using ImageMagick.
 // It handles both single file and directory input, and processes directories in parallel.
-func processFiles(inPathInfo os.FileInfo) error {
+func processFiles(ctx context.Context, inPathInfo os.FileInfo) error {
+	if *filesFrom != "" {
+		files, missing, err := listedFiles()
+		recordMissing(missing)
+		if err != nil {
+			return err
+		}
+		return processFileList(ctx, files)
+	}
+	if expandedInputs != nil {
+		return processFileList(ctx, expandedInputs)
+	}
+	if *diffMode {
+		return processDiff(ctx, *inPath)
+	}
+	if *watch {
+		return watchDirectory(ctx, *inPath)
+	}
+	if *burstPick != "" {
+		if !inPathInfo.IsDir() {
+			return errors.New("-burst-pick requires a directory input")
+		}
+		return processBursts(ctx, *inPath)
+	}
 	if inPathInfo.IsDir() {
-		return processDirectory(*inPath)
+		return processDirectory(ctx, *inPath)
 	}
-	return processSingleFile(*inPath)
+	return processSingleFile(ctx, *inPath)
 }
 
-// processDirectory processes all .heic files in the directory in parallel.
-func processDirectory(dirPath string) error {
-	entries, err := os.ReadDir(dirPath)
+// processDirectory processes all HEIC files in the directory in parallel.
+func processDirectory(ctx context.Context, dirPath string) error {
+	heicFiles, err := collectHeicFiles(dirPath)
 	if err != nil {
-		return fmt.Errorf("failed to read directory: %v", err)
+		return err
 	}
+	return processFileList(ctx, heicFiles)
+}
 
-	var heicFiles []string
-	for _, entry := range entries {
-		if entry.IsDir() {
-			continue
-		}
-		if isHeicFile(entry.Name()) {
-			heicFiles = append(heicFiles, filepath.Join(dirPath, entry.Name()))
-		}
+// processFileList converts the given files in parallel and aggregates any failures.
+func processFileList(ctx context.Context, heicFiles []string) error {
+	// Naming is decided on the whole list, before any filtering, so it doesn't change between runs.
+	planOutputNames(heicFiles)
+	// Duplicates are found before already converted sources are left out, so a duplicate of a source
+	// converted by an earlier run is reported against that output rather than converted itself.
+	dupes := linkDuplicates
+	linkDuplicates = nil
+	if *dedupe {
+		var contentDupes []duplicate
+		heicFiles, contentDupes = dedupeFiles(ctx, heicFiles)
+		dupes = append(dupes, contentDupes...)
+	}
+	heicFiles = excludeCompleted(heicFiles, completedSources)
+	heicFiles = excludeResumed(heicFiles)
+	if len(heicFiles) == 0 && len(dupes) == 0 {
+		fmt.Fprintln(infoOut, "INFO: Nothing left to convert.")
+		return nil
 	}
 
-	if len(heicFiles) == 0 {
-		return errors.New("no HEIC files found in the directory")
+	if *sample > 0 && *sample < len(heicFiles) {
+		heicFiles = sampleFiles(heicFiles, *sample, randSource())
+		dupes = duplicatesOf(dupes, heicFiles)
+		fmt.Fprintf(infoOut, "INFO: Sampled %d files.\n", len(heicFiles))
+	}
+	trackCanonicals(dupes)
+	if *shuffle {
+		// Spread out runs of similarly sized files so workers don't all hit the largest ones at once.
+		heicFiles = shuffleFiles(heicFiles, randSource())
+	}
+
+	var links map[string]string
+	if *preserveLinks && commandDump.file == nil && !*dryRun {
+		heicFiles, links = splitSymlinks(heicFiles)
+		defer recreateSymlinks(links)
+	}
+
+	if *preflight && commandDump.file == nil && !*dryRun {
+		if err := preflightPermissions(heicFiles); err != nil {
+			return err
+		}
+		if err := preflightDiskSpace(ctx, heicFiles); err != nil {
+			return err
+		}
 	}
 
 	// Parallel processing with worker pool
@@ -151,17 +847,40 @@ func processDirectory(dirPath string) error {
 		numWorkers = 1
 	}
 	fileCh := make(chan string, len(heicFiles))
-	errCh := make(chan error, len(heicFiles))
+	errCh := make(chan error, len(heicFiles)+len(dupes))
+	breaker := newCircuitBreaker(*breakerThreshold, *breakerWindow, len(heicFiles))
 	var wg sync.WaitGroup
+	var processed atomic.Int64
+	// A failure that -on-error stops on cancels workCtx, stopping the remaining and in-flight conversions.
+	workCtx, stopWork := context.WithCancel(ctx)
+	defer stopWork()
+	var firstErr error
+	var firstErrOnce sync.Once
+	prog := newProgress(len(heicFiles))
 
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for file := range fileCh {
-				if err := processSingleFile(file); err != nil {
+				if workCtx.Err() != nil || breaker.open() {
+					continue
+				}
+				err := processSingleFile(workCtx, file)
+				processed.Add(1)
+				prog.record(err != nil)
+				if err != nil {
 					errCh <- err
 				}
+				if err != nil && (*failOnMissing || !errors.Is(err, errMissingInput)) && ctx.Err() == nil && stopOnError(file, err) {
+					firstErrOnce.Do(func() {
+						firstErr = err
+						stopWork()
+					})
+				}
+				if !errors.Is(err, errMissingInput) {
+					breaker.record(err != nil)
+				}
 			}
 		}()
 	}
@@ -171,37 +890,616 @@ func processDirectory(dirPath string) error {
 	}
 	close(fileCh)
 	wg.Wait()
+	prog.finish()
+	if workCtx.Err() == nil && !breaker.open() {
+		recordDuplicates(workCtx, dupes, errCh)
+	}
 	close(errCh)
 
-	var errs []string
+	var errs, missing []string
 	for e := range errCh {
+		if errors.Is(e, errMissingInput) {
+			missing = append(missing, strings.TrimPrefix(e.Error(), errMissingInput.Error()+": "))
+			if !*failOnMissing {
+				continue
+			}
+		}
 		errs = append(errs, e.Error())
 	}
+	reportMissing(missing)
+	if ctx.Err() != nil {
+		fmt.Fprintf(os.Stdout, "INFO: Stopped early: %d of %d files processed, %d converted.\n",
+			processed.Load(), len(heicFiles), statusCount(statusConverted))
+		return errInterrupted
+	}
+	if firstErr != nil {
+		fmt.Fprintf(os.Stdout, "INFO: Stopped after the first failure: %d of %d files processed.\n", processed.Load(), len(heicFiles))
+		return firstErr
+	}
+	if breaker.open() {
+		return breaker.err()
+	}
 	if len(errs) > 0 {
 		return fmt.Errorf("some files failed to convert:\n%s", strings.Join(errs, "\n"))
 	}
 	return nil
 }
 
-// processSingleFile converts a single HEIC file to the specified output format.
-func processSingleFile(inFile string) error {
-	if !isHeicFile(inFile) {
-		return fmt.Errorf("file %s does not have a .heic extension", inFile)
+// collectHeicFiles lists the HEIC files directly inside dirPath, or with -recursive anywhere under it,
+// that pass -include and -exclude, failing if there are none.
+func collectHeicFiles(dirPath string) ([]string, error) {
+	heicFiles, err := scanHeicFiles(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(heicFiles) == 0 {
+		return nil, errors.New("no HEIC files found in the directory")
+	}
+	return skipLinkedDuplicates(heicFiles), nil
+}
+
+// scanHeicFiles is collectHeicFiles without the check that any were found.
+func scanHeicFiles(dirPath string) ([]string, error) {
+	var heicFiles []string
+	if *recursive && *followSymlinks {
+		err := walkFollowingSymlinks(dirPath, dirPath, make(map[string]bool), func(path string) {
+			if selectedSource(dirPath, path) && isHeicSource(path) {
+				heicFiles = append(heicFiles, path)
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk directory: %v", err)
+		}
+	} else if *recursive {
+		err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if excludedDir(dirPath, path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if selectedSource(dirPath, path) && isHeicSource(path) {
+				heicFiles = append(heicFiles, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk directory: %v", err)
+		}
+	} else {
+		entries, err := os.ReadDir(dirPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory: %v", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			path := filepath.Join(dirPath, entry.Name())
+			if selectedSource(dirPath, path) && isHeicSource(path) {
+				heicFiles = append(heicFiles, path)
+			}
+		}
+	}
+	return heicFiles, nil
+}
+
+// inputFiles resolves the input path to the list of HEIC files it refers to.
+func inputFiles(inPathInfo os.FileInfo) ([]string, error) {
+	if *filesFrom != "" {
+		files, _, err := listedFiles()
+		return files, err
+	}
+	if expandedInputs != nil {
+		return expandedInputs, nil
+	}
+	if inPathInfo.IsDir() {
+		return collectHeicFiles(*inPath)
+	}
+	if err := checkHeicSource(*inPath); err != nil {
+		return nil, err
+	}
+	return []string{*inPath}, nil
+}
+
+// processSingleFile converts a single HEIC file to the specified output format and records the result.
+func processSingleFile(ctx context.Context, inFile string) error {
+	start := time.Now()
+	res := convertWithRetries(ctx, inFile)
+	res.Duration = time.Since(start)
+	if res.Status == statusFailed && *failedDir != "" && ctx.Err() == nil {
+		if err := quarantine(res); err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: %v\n", err)
+		}
+	}
+	if *debugSerial {
+		fmt.Fprintf(os.Stdout, "DEBUG: Result: %s %s in %s", res.Source, res.Status, res.Duration.Round(time.Millisecond))
+		if res.Err != nil {
+			fmt.Fprintf(os.Stdout, ": %v", res.Err)
+		}
+		fmt.Fprintln(os.Stdout)
+	}
+	countResult(res)
+	noteCanonicalResult(res)
+	if err := recordResult(res); err != nil {
+		fmt.Fprintf(os.Stderr, "WARN: %v\n", err)
+	}
+	return res.Err
+}
+
+// convertFile performs the conversion of a single HEIC file and describes what happened.
+func convertFile(ctx context.Context, inFile string) fileResult {
+	res := fileResult{Source: inFile}
+	if err := checkHeicSource(inFile); err != nil {
+		return res.fail(err)
+	}
+	inInfo, err := os.Stat(inFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		res.Status = statusMissing
+		res.Err = fmt.Errorf("%w: %s", errMissingInput, inFile)
+		return res
+	}
+	if err == nil {
+		res.SourceSize = inInfo.Size()
+	}
+	outFile, err := prepareOutputPath(inFile)
+	if err != nil {
+		return res.fail(err)
+	}
+	if *allFrames {
+		outFile = firstFrameOutput(outFile)
+	}
+	if outFile == inFile {
+		// A sniffed HEIC file named like its output, e.g. a misnamed photo.jpg converted to jpg.
+		return res.fail(fmt.Errorf("output for %s would overwrite the source, use -output-ext or -outdir", inFile))
+	}
+	res.Target = outFile
+	if *skipIfNewer && inInfo != nil {
+		if outInfo, err := statOutput(outFile); err == nil && outInfo.ModTime().After(inInfo.ModTime()) {
+			fmt.Fprintf(infoOut, "INFO: Skipping %s, output %s is newer than the source.\n", inFile, outFile)
+			res.Status = statusUpToDate
+			return res
+		}
+	}
+	if *metadataOnly {
+		path, err := writeMetadataJSON(inFile, outFile)
+		if err != nil {
+			return res.fail(err)
+		}
+		fmt.Fprintf(infoOut, "INFO: Wrote metadata of %s to %s.\n", inFile, path)
+		res.Target = path
+		res.Status = statusMetadata
+		return res
+	}
+	outFile, skip, err := resolveExisting(inFile, outFile, inInfo)
+	if err != nil {
+		return res.fail(err)
+	}
+	if skip != "" {
+		res.Status = skip
+		return res
+	}
+	res.Target = outFile
+	if *dryRun {
+		if err := planConversion(inFile, outFile); err != nil {
+			return res.fail(err)
+		}
+		res.Status = statusDryRun
+		return res
+	}
+	name, args, err := converter.Command(inFile, outFile)
+	if err != nil {
+		return res.fail(err)
+	}
+	if *debugSerial || *verbose {
+		fmt.Fprintln(os.Stdout, "DEBUG: Command:", shellCommand(name, args))
+	}
+	if commandDump.file != nil {
+		if err := dumpCommand(name, args); err != nil {
+			return res.fail(err)
+		}
+		res.Status = statusDumped
+		return res
+	}
+	var sourceSum string
+	if outputRegistry.entries != nil {
+		if sourceSum, err = checkOutputConflict(inFile, outFile); err != nil {
+			return res.fail(err)
+		}
+	}
+	var key string
+	cached := false
+	if *cacheDir != "" {
+		if key, err = cacheKey(inFile, name, args, outFile); err != nil {
+			return res.fail(err)
+		}
+		if cached, err = restoreFromCache(key, outFile); err != nil {
+			return res.fail(err)
+		}
+	}
+	if cached {
+		res.Detail = "from cache"
+	} else {
+		if err := runAtomicConversion(ctx, &res, name, args); err != nil {
+			res = res.fail(err)
+			res.transient = !errors.Is(err, errCorruptInput) && !errors.Is(err, errInterrupted) && !errors.Is(err, errConversionWarning)
+			return res
+		}
+		if key != "" {
+			if err := storeInCache(key, outFile); err != nil {
+				fmt.Fprintf(os.Stdout, "WARN: %v\n", err)
+			}
+		}
+	}
+	if tool, _ := splitMagickTool(name, args); *verifyTiles && tool == "convert" && targetBytes == 0 {
+		if err := verifyTileAssembly(inFile, outFile); err != nil {
+			os.Remove(outFile)
+			return res.fail(err)
+		}
+	}
+	if outInfo, err := os.Stat(outFile); err == nil {
+		res.TargetSize = outInfo.Size()
+	}
+	if warnOverBytes > 0 && res.TargetSize > warnOverBytes {
+		fmt.Fprintf(os.Stdout, "WARN: Output %s is %s (source %s), above -warn-output-over %s.\n",
+			outFile, formatBytes(res.TargetSize), formatBytes(res.SourceSize), formatBytes(warnOverBytes))
+	}
+	if *onlyIfSmaller {
+		smaller, inSize, outSize, err := outputIsSmaller(inFile, outFile)
+		if err != nil {
+			return res.fail(err)
+		}
+		if !smaller {
+			if err := os.Remove(outFile); err != nil {
+				return res.fail(fmt.Errorf("failed to discard %s: %v", outFile, err))
+			}
+			fmt.Fprintf(infoOut, "INFO: Discarded %s, output (%d bytes) is not smaller than the source (%d bytes).\n", outFile, outSize, inSize)
+			res.Status = statusDiscarded
+			return res
+		}
+	}
+	if *preserveTimes && inInfo != nil {
+		if err := os.Chtimes(outFile, time.Now(), inInfo.ModTime()); err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: Failed to copy the modification time of %s to %s: %v\n", inFile, outFile, err)
+		}
+	} else if keepCaptureTime() {
+		taken, ok, err := captureTime(ctx, inFile)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: %v\n", err)
+		} else if ok {
+			if err := os.Chtimes(outFile, time.Now(), taken); err != nil {
+				fmt.Fprintf(os.Stdout, "WARN: Failed to set the modification time of %s to its capture time: %v\n", outFile, err)
+			}
+		}
 	}
-	outFile := buildOutputFilename(inFile, *outType)
-	cmd := exec.Command("convert", inFile, outFile)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to convert %s: %v", inFile, err)
+	var details []string
+	if res.Detail != "" {
+		details = append(details, res.Detail)
+	}
+	if res.DecodeTime > 0 {
+		details = append(details, fmt.Sprintf("decode %s, encode %s", res.DecodeTime.Round(time.Millisecond), res.EncodeTime.Round(time.Millisecond)))
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(infoOut, "INFO: Converted %s to %s (%s).\n", inFile, outFile, strings.Join(details, "; "))
+	} else {
+		fmt.Fprintf(infoOut, "INFO: Converted %s to %s.\n", inFile, outFile)
+	}
+	if *metadataJSON {
+		if _, err := writeMetadataJSON(inFile, outFile); err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: %v\n", err)
+		}
+	}
+	if sourceSum != "" {
+		registerOutput(inFile, outFile, sourceSum)
+	}
+	if *livePhotos {
+		if res.Sidecar, err = pairLivePhoto(ctx, inFile, outFile); err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: %v\n", err)
+		}
+	}
+	if *deleteSource || *moveSources != "" {
+		if err := disposeSource(inFile, outFile); err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: %v\n", err)
+		}
+	}
+	res.Status = statusConverted
+	return res
+}
+
+// logConverterOutput writes each line of a converter's output prefixed with the level and the source it
+// was converting, in a single write so the lines of one file stay together.
+func logConverterOutput(w io.Writer, level, source, output string) {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			fmt.Fprintf(&b, "%s: %s: %s\n", level, source, line)
+		}
+	}
+	io.WriteString(w, b.String())
+}
+
+// runConversion runs the conversion command for res, writing res.Target.
+func runConversion(ctx context.Context, res *fileResult, name string, args []string) error {
+	env, err := commandEnv(res.Source)
+	if err != nil {
+		return err
+	}
+	// Waiting for room in the -max-mem budget happens before the timeout starts counting.
+	release, err := reserveMemory(ctx, res.Source)
+	if err != nil {
+		return contextError(ctx, res, err)
+	}
+	defer release()
+	ctx, cancel := conversionContext(ctx, res.SourceSize)
+	defer cancel()
+	if nc, ok := converter.(inProcessConverter); ok {
+		var stderr bytes.Buffer
+		err := nc.Convert(ctx, res.Source, res.Target, &stderr)
+		res.Stderr = stderr.String()
+		if err != nil {
+			return contextError(ctx, res, err)
+		}
+		return conversionWarnings(res)
+	}
+	tool, toolArgs := splitMagickTool(name, args)
+	if targetBytes > 0 {
+		if err := fitToTarget(ctx, res, toolArgs, env); err != nil {
+			return contextError(ctx, res, err)
+		}
+		return conversionWarnings(res)
+	}
+	var encoded *bytes.Buffer
+	if ioSlots != nil {
+		// Encode to stdout so the write to disk can be throttled separately from the conversion itself.
+		encoded = new(bytes.Buffer)
+		args[len(args)-1] = *outType + ":-"
+	}
+	cmd := convert.CommandContext(ctx, name, args...)
+	cmd.Env = env
+	// Each command's output is buffered and reported with the source's name once it finishes, so output
+	// from parallel workers never interleaves.
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	if encoded != nil {
+		cmd.Stdout = encoded
+	}
+	cmd.Stderr = &stderr
+	if *splitTiming && tool == "convert" {
+		res.DecodeTime, res.EncodeTime, err = runSplitPipeline(ctx, toolArgs, env, cmd.Stdout, cmd.Stderr)
+	} else {
+		err = cmd.Run()
+	}
+	res.Stderr = stderr.String()
+	if *verbose {
+		logConverterOutput(os.Stdout, "INFO", res.Source, stdout.String())
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// The killed command may have left a partially written output behind.
+			os.Remove(res.Target)
+		}
+		output := strings.TrimSpace(res.Stderr)
+		if isCorruptRead(output) {
+			return fmt.Errorf("failed to convert %s: %w: %s", res.Source, errCorruptInput, output)
+		}
+		if output != "" {
+			err = fmt.Errorf("%v: %s", err, output)
+		}
+		return contextError(ctx, res, fmt.Errorf("failed to convert %s: %v", res.Source, err))
+	}
+	if encoded != nil {
+		if err := writeThrottled(res.Target, encoded.Bytes()); err != nil {
+			return err
+		}
+	}
+	return conversionWarnings(res)
+}
+
+// errConversionWarning marks a conversion that succeeded but wrote to stderr under -fail-on-warning. The
+// same source produces the same warnings again, so it is not retried.
+var errConversionWarning = errors.New("conversion produced warnings (-fail-on-warning)")
+
+// conversionWarnings handles what a successful conversion wrote to stderr: with -fail-on-warning it fails
+// the conversion and removes the output, otherwise it is logged as warnings unless -quiet is set.
+func conversionWarnings(res *fileResult) error {
+	output := strings.TrimSpace(res.Stderr)
+	if output == "" {
+		return nil
+	}
+	if *failOnWarning {
+		// The output may be usable, but strict mode must not leave it behind for a file reported as failed.
+		os.Remove(res.Target)
+		return fmt.Errorf("failed to convert %s: %w: %s", res.Source, errConversionWarning, output)
+	}
+	if !*quiet {
+		logConverterOutput(os.Stdout, "WARN", res.Source, res.Stderr)
 	}
-	fmt.Fprintf(os.Stdout, "INFO: Converted %s to %s.\n", inFile, outFile)
 	return nil
 }
 
-// isHeicFile checks if the file has a .heic extension (case-insensitive).
+// fitToTarget writes an output that meets -target-size instead of running the conversion as-is.
+func fitToTarget(ctx context.Context, res *fileResult, args, env []string) error {
+	data, quality, scale, warnings, err := fitTargetSize(ctx, args, env)
+	res.Stderr = warnings
+	if err != nil {
+		return fmt.Errorf("failed to fit %s: %v", res.Source, err)
+	}
+	if ioSlots != nil {
+		err = writeThrottled(res.Target, data)
+	} else if err = os.WriteFile(res.Target, data, 0o644); err != nil {
+		err = fmt.Errorf("failed to write %s: %v", res.Target, err)
+	}
+	if err != nil {
+		return err
+	}
+	dims := fmt.Sprintf("%d%% scale", scale)
+	if width, height, err := imageDimensions(res.Source); err == nil {
+		dims = fmt.Sprintf("%dx%d", width*scale/100, height*scale/100)
+	}
+	res.Detail = fmt.Sprintf("%d bytes at quality %d, %s", len(data), quality, dims)
+	return nil
+}
+
+// writeThrottled writes an encoded output while holding one of the -io-workers slots.
+func writeThrottled(outFile string, data []byte) error {
+	ioSlots <- struct{}{}
+	defer func() { <-ioSlots }()
+	if err := os.WriteFile(outFile, data, 0o644); err != nil {
+		os.Remove(outFile)
+		return fmt.Errorf("failed to write %s: %v", outFile, err)
+	}
+	return nil
+}
+
+// outputIsSmaller compares the sizes of a source and its converted output.
+func outputIsSmaller(inFile, outFile string) (smaller bool, inSize, outSize int64, err error) {
+	inInfo, err := os.Stat(inFile)
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("failed to stat %s: %v", inFile, err)
+	}
+	outInfo, err := os.Stat(outFile)
+	if err != nil {
+		return false, 0, 0, fmt.Errorf("failed to stat %s: %v", outFile, err)
+	}
+	return outInfo.Size() < inInfo.Size(), inInfo.Size(), outInfo.Size(), nil
+}
+
+// buildCommand picks the ImageMagick tool and arguments for a single file: montage for multi-frame
+// sources in -montage mode, convert otherwise.
+func buildCommand(inFile, outFile string) (string, []string, error) {
+	if *montageMode {
+		n, err := frameCount(inFile)
+		if err != nil {
+			return "", nil, err
+		}
+		if n > 1 {
+			name, args := magickTool("montage", withExplicitFormat(buildMontageArgs(inFile, outFile))...)
+			return name, args, nil
+		}
+	}
+	args, err := buildConvertArgs(inFile, outFile)
+	if err != nil {
+		return "", nil, err
+	}
+	name, args := magickTool("convert", withExplicitFormat(args)...)
+	return name, args, nil
+}
+
+// withExplicitFormat prefixes the output path (the last argument) with the output format when -output-ext
+// is set, as ImageMagick would otherwise infer the format from the custom extension.
+func withExplicitFormat(args []string) []string {
+	if *outputExt != "" {
+		args[len(args)-1] = *outType + ":" + args[len(args)-1]
+	}
+	return args
+}
+
+// outputExtension returns the extension given to outputs: -output-ext if set, else the output type.
+func outputExtension() string {
+	if *outputExt != "" {
+		return *outputExt
+	}
+	return *outType
+}
+
+// buildConvertArgs assembles the convert arguments for a single file, placing per-file options
+// between the input and output paths so ImageMagick applies them to the decoded image.
+func buildConvertArgs(inFile, outFile string) ([]string, error) {
+	args := []string{sourceSpec(inFile)}
+	if *vendorFix {
+		cameraMk, err := cameraMake(inFile)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, vendorFixupArgs(cameraMk)...)
+	}
+	if *toneMap {
+		hdr, err := isHDR(inFile)
+		if err != nil {
+			return nil, err
+		}
+		if hdr {
+			args = append(args, toneMapArgs...)
+		}
+	}
+	if *autoGrayscale {
+		sat, err := meanSaturation(inFile)
+		if err != nil {
+			return nil, err
+		}
+		if isNearGrayscale(sat) {
+			args = append(args, "-colorspace", "Gray")
+		}
+	}
+	args = append(args, encodeOptions().Args()...)
+	args = append(args, heicEncodeArgs()...)
+	if qualityCeil > 0 && isJpegType(*outType) {
+		q, err := fileQuality(inFile)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-quality", strconv.Itoa(q))
+	}
+	args = append(args, renditionArgs(outFile)...)
+	if *withThumbnail {
+		// Write the thumbnail from a clone of the already decoded image, so the source is only read once.
+		// The ">" only ever shrinks, so sources smaller than -thumb-size are not blown up.
+		size := fmt.Sprintf("%dx%d>", *thumbSize, *thumbSize)
+		args = append(args, "(", "+clone", "-thumbnail", size, "-write", buildThumbnailFilename(outFile), "+delete", ")")
+	}
+	if *allFrames {
+		return append(args, "-scene", "1", framePattern(outFile)), nil
+	}
+	return append(args, outFile), nil
+}
+
+// encodeOptions returns the encoding flags as convert.Options, whose Args build the part of every
+// command line the convert package shares with the command.
+func encodeOptions() convert.Options {
+	opts := convert.Options{
+		Format:       *outType,
+		Quality:      *jpegQuality,
+		Resize:       *resizeGeom,
+		MaxDimension: *maxDimension,
+		AutoOrient:   *autoOrient,
+		Strip:        *stripMeta,
+		StripProfile: *stripProfile,
+	}
+	switch *pngCompression {
+	case -1:
+	case 0:
+		opts.PNGCompression = convert.NoPNGCompression
+	default:
+		opts.PNGCompression = *pngCompression
+	}
+	if *convertToSRGB {
+		opts.SRGBProfile = *srgbProfile
+	}
+	return opts
+}
+
+// buildThumbnailFilename returns the <base>_thumb.jpg path for an output, placed in -thumb-dir when given.
+func buildThumbnailFilename(outFile string) string {
+	thumb := strings.TrimSuffix(outFile, filepath.Ext(outFile)) + "_thumb.jpg"
+	if *thumbDir != "" {
+		return filepath.Join(*thumbDir, filepath.Base(thumb))
+	}
+	return thumb
+}
+
+// isJpegType reports whether the output type is one of the JPEG spellings.
+func isJpegType(outType string) bool {
+	return outType == "jpg" || outType == "jpeg"
+}
+
+// isHeicFile checks if the file has a .heic, .heif or .hif extension (case-insensitive), or with
+// -output heic a .jpg, .jpeg or .png one.
 func isHeicFile(filename string) bool {
-	return strings.EqualFold(filepath.Ext(filename), ".heic")
+	_, ok := validInExts[strings.ToLower(filepath.Ext(filename))]
+	return ok
 }
 
 // buildOutputFilename constructs the output filename based on the input file and output type.
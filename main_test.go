@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThumbnailOutputs(t *testing.T) {
+	tests := []struct {
+		name     string
+		thumbDir string
+		want     string
+	}{
+		{name: "next to the output", want: "IMG_1_thumb.jpg"},
+		{name: "in -thumb-dir", thumbDir: "thumbs", want: filepath.Join("thumbs", "IMG_1_thumb.jpg")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubMagick(t)
+			dir := t.TempDir()
+			setFlag(t, outType, "jpg")
+			setFlag(t, withThumbnail, true)
+			setFlag(t, thumbSize, 64)
+			if tt.thumbDir != "" {
+				if err := os.Mkdir(filepath.Join(dir, tt.thumbDir), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				setFlag(t, thumbDir, filepath.Join(dir, tt.thumbDir))
+			}
+			in := writeFile(t, dir, "IMG_1.heic", "heic")
+			out := filepath.Join(dir, "IMG_1.jpg")
+			thumb := filepath.Join(dir, tt.want)
+
+			args, err := buildConvertArgs(in, out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The thumbnail is written from a clone of the one decode, and only ever shrunk.
+			i := slices.Index(args, "-thumbnail")
+			if i < 1 || args[i-1] != "+clone" || !slices.Equal(args[i+1:i+4], []string{"64x64>", "-write", thumb}) {
+				t.Errorf("buildConvertArgs() = %q, want a +clone -thumbnail 64x64> -write %s group", args, thumb)
+			}
+			if args[0] != in+"[0]" || args[len(args)-1] != out {
+				t.Errorf("buildConvertArgs() = %q, want it to read %s[0] once and write %s", args, in, out)
+			}
+
+			if err := magickCommand("convert", args...).Run(); err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range []string{out, thumb} {
+				if _, err := os.Stat(path); err != nil {
+					t.Errorf("output missing: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDebugSerial(t *testing.T) {
+	stubMagick(t)
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"a.heic", "b.heic", "c.heic", "d.heic", "e.heic", "f.heic"} {
+		files = append(files, writeFile(t, dir, name, "x"))
+	}
+	writeFile(t, dir, "c.heic.fail", "convert: improper image header `c.heic'\n")
+	setFlag(t, &infoOut, io.Discard)
+	setFlag(t, inputs, stringList{dir})
+	setFlag(t, inPath, dir)
+	setFlag(t, outType, "jpg")
+	setFlag(t, workers, 8)
+	setFlag(t, debugSerial, true)
+	if _, err := validateFlags(); err != nil {
+		t.Fatal(err)
+	}
+	if *workers != 1 {
+		t.Fatalf("-debug-serial left -workers at %d, want 1", *workers)
+	}
+
+	var err error
+	output := captureStdout(t, func() { err = processFileList(context.Background(), files) })
+	if err == nil || !strings.Contains(err.Error(), "c.heic") {
+		t.Errorf("processFileList() = %v, want the failure of c.heic", err)
+	}
+	// Each file's command is followed by its own result before the next file starts, in input order.
+	var got []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "DEBUG: ") {
+			got = append(got, line)
+		}
+	}
+	if len(got) != 2*len(files) {
+		t.Fatalf("debug output has %d lines, want a command and a result per file:\n%s", len(got), strings.Join(got, "\n"))
+	}
+	for i, line := range got {
+		kind := []string{"DEBUG: Command: ", "DEBUG: Result: "}[i%2]
+		if file := files[i/2]; !strings.HasPrefix(line, kind) || !strings.Contains(line, file) {
+			t.Errorf("debug line %d = %q, want %q for %s", i, line, kind, file)
+		}
+	}
+}
+
+// warningConverter converts in-process like the native backend, writing a warning to stderr.
+type warningConverter struct{}
+
+func (warningConverter) Verify() error { return nil }
+
+func (warningConverter) Command(inFile, outFile string) (string, []string, error) {
+	return "native", []string{inFile, outFile}, nil
+}
+
+func (warningConverter) Convert(ctx context.Context, inFile, outFile string, stderr io.Writer) error {
+	io.WriteString(stderr, "libheif: Unsupported auxiliary image type\n")
+	return os.WriteFile(outFile, []byte("encoded"), 0o644)
+}
+
+func TestFailOnWarning(t *testing.T) {
+	tests := []struct {
+		name       string
+		strict     bool
+		target     int64
+		inProcess  bool
+		wantStatus string
+	}{
+		{name: "warnings only logged", wantStatus: statusConverted},
+		{name: "convert", strict: true, wantStatus: statusFailed},
+		{name: "-target-size", strict: true, target: 1000, wantStatus: statusFailed},
+		{name: "in-process backend", strict: true, inProcess: true, wantStatus: statusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := stubMagick(t)
+			if tt.inProcess {
+				var backend Converter = warningConverter{}
+				setFlag(t, &converter, backend)
+			}
+			dir := t.TempDir()
+			setFlag(t, outType, "jpg")
+			setFlag(t, failOnWarning, tt.strict)
+			setFlag(t, &targetBytes, tt.target)
+			setFlag(t, retries, 2)
+			setFlag(t, retryBackoff, 0)
+			in := writeFile(t, dir, "IMG_1.heic", "x")
+			writeFile(t, dir, "IMG_1.heic.warn", "convert: Unknown field with tag 59932 (0xea1c) encountered. `TIFFReadDirectory'\n")
+
+			var res fileResult
+			captureStdout(t, func() { res = convertWithRetries(context.Background(), in) })
+			if res.Status != tt.wantStatus {
+				t.Fatalf("convertWithRetries() = %s, %v, want %s", res.Status, res.Err, tt.wantStatus)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var outputs []string
+			for _, e := range entries {
+				if !strings.HasPrefix(e.Name(), "IMG_1.heic") {
+					outputs = append(outputs, e.Name())
+				}
+			}
+			if !tt.strict {
+				if !slices.Equal(outputs, []string{"IMG_1.jpg"}) {
+					t.Errorf("outputs = %q, want IMG_1.jpg", outputs)
+				}
+				return
+			}
+			if !errors.Is(res.Err, errConversionWarning) || res.transient {
+				t.Errorf("convertWithRetries() = %v, transient %v, want a permanent warning failure", res.Err, res.transient)
+			}
+			if len(outputs) > 0 {
+				t.Errorf("a failed conversion left %q behind", outputs)
+			}
+			// A -target-size search runs several encodes per attempt, so only plain conversions are counted.
+			if commands := stubCommands(t, log); !tt.inProcess && tt.target == 0 && len(commands) != 1 {
+				t.Errorf("ran %q, want one attempt without retries", commands)
+			}
+		})
+	}
+}
+
+func TestOnlyIfSmaller(t *testing.T) {
+	tests := []struct {
+		name       string
+		outBytes   string
+		wantStatus string
+	}{
+		{name: "smaller output kept", outBytes: "60", wantStatus: statusConverted},
+		{name: "same size discarded", outBytes: "100", wantStatus: statusDiscarded},
+		{name: "larger output discarded", outBytes: "250", wantStatus: statusDiscarded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubMagick(t)
+			t.Setenv("STUB_BYTES", tt.outBytes)
+			dir := t.TempDir()
+			setFlag(t, outType, "jpg")
+			setFlag(t, onlyIfSmaller, true)
+			in := writeFile(t, dir, "IMG_1.heic", strings.Repeat("x", 100))
+			out := filepath.Join(dir, "IMG_1.jpg")
+
+			var res fileResult
+			captureStdout(t, func() { res = convertFile(context.Background(), in) })
+			if res.Status != tt.wantStatus || res.Err != nil {
+				t.Fatalf("convertFile() = %s, %v, want %s", res.Status, res.Err, tt.wantStatus)
+			}
+			_, err := os.Stat(out)
+			if kept := err == nil; kept != (tt.wantStatus == statusConverted) {
+				t.Errorf("output kept = %v with status %s", kept, res.Status)
+			}
+			if data, err := os.ReadFile(in); err != nil || len(data) != 100 {
+				t.Errorf("the source was changed: %d bytes, %v", len(data), err)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	in := writeFile(t, dir, "a.heic", "source")
+	if _, _, _, err := outputIsSmaller(in, filepath.Join(dir, "a.jpg")); err == nil {
+		t.Error("outputIsSmaller() of a missing output succeeded")
+	}
+}
+
+func TestIOWorkers(t *testing.T) {
+	log := stubMagick(t)
+	t.Setenv("STUB_SLEEP", "0.2")
+	dir := t.TempDir()
+	setFlag(t, &infoOut, io.Discard)
+	setFlag(t, outType, "jpg")
+	setFlag(t, workers, 3)
+	setFlag(t, &ioSlots, make(chan struct{}, 1))
+	var files []string
+	for _, name := range []string{"a.heic", "b.heic", "c.heic", "d.heic", "e.heic", "f.heic"} {
+		files = append(files, writeFile(t, dir, name, "x"))
+	}
+
+	var err error
+	captureStdout(t, func() { err = processFileList(context.Background(), files) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(log + ".running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peak := 0
+	for _, field := range strings.Fields(string(data)) {
+		if n, err := strconv.Atoi(field); err == nil {
+			peak = max(peak, n)
+		}
+	}
+	if peak < 2 || peak > *workers {
+		t.Errorf("up to %d conversions ran at once, want more than one and at most -workers %d", peak, *workers)
+	}
+	for _, file := range files {
+		out := strings.TrimSuffix(file, ".heic") + ".jpg"
+		if info, err := os.Stat(out); err != nil || info.Size() == 0 {
+			t.Errorf("output %s was not written through the write slots: %v", out, err)
+		}
+	}
+
+	// With every slot taken, a write waits until one is released.
+	ioSlots <- struct{}{}
+	out := filepath.Join(dir, "held.jpg")
+	done := make(chan error)
+	go func() { done <- writeThrottled(out, []byte("encoded")) }()
+	select {
+	case err := <-done:
+		t.Fatalf("writeThrottled() = %v while every -io-workers slot was taken", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := os.Stat(out); err == nil {
+		t.Error("the output was written while every -io-workers slot was taken")
+	}
+	<-ioSlots
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(ioSlots) != 0 {
+		t.Errorf("writeThrottled() left %d slots taken", len(ioSlots))
+	}
+}
+
+func TestSkipIfOutputNewer(t *testing.T) {
+	tests := []struct {
+		name        string
+		skipIfNewer bool
+		outputAge   time.Duration
+		wantStatus  string
+	}{
+		{name: "newer output kept", skipIfNewer: true, outputAge: -time.Hour, wantStatus: statusUpToDate},
+		{name: "older output reconverted", skipIfNewer: true, outputAge: time.Hour, wantStatus: statusConverted},
+		{name: "newer output reconverted without the flag", outputAge: -time.Hour, wantStatus: statusConverted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := stubMagick(t)
+			dir := t.TempDir()
+			setFlag(t, outType, "jpg")
+			setFlag(t, overwrite, true)
+			setFlag(t, skipIfNewer, tt.skipIfNewer)
+			setFlag(t, &infoOut, io.Discard)
+			in := writeFile(t, dir, "IMG_1.heic", "source")
+			out := writeFile(t, dir, "IMG_1.jpg", "edited by hand")
+			sourceTime := time.Now().Add(-24 * time.Hour)
+			if err := os.Chtimes(in, sourceTime, sourceTime); err != nil {
+				t.Fatal(err)
+			}
+			outputTime := sourceTime.Add(-tt.outputAge)
+			if err := os.Chtimes(out, outputTime, outputTime); err != nil {
+				t.Fatal(err)
+			}
+
+			res := convertFile(context.Background(), in)
+			if res.Status != tt.wantStatus || res.Err != nil {
+				t.Fatalf("convertFile() = %s, %v, want %s", res.Status, res.Err, tt.wantStatus)
+			}
+			data, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := string(data) == "edited by hand"; kept != (tt.wantStatus == statusUpToDate) {
+				t.Errorf("output kept = %v with status %s", kept, res.Status)
+			}
+			if ran := len(stubCommands(t, log)) > 0; ran == (tt.wantStatus == statusUpToDate) {
+				t.Errorf("ImageMagick ran = %v with status %s", ran, res.Status)
+			}
+		})
+	}
+}
+
+func TestOutputExt(t *testing.T) {
+	setFlag(t, outType, "jpg")
+	if got := outputExtension(); got != "jpg" {
+		t.Errorf("outputExtension() = %q without -output-ext, want jpg", got)
+	}
+	if got := withExplicitFormat([]string{"in.heic[0]", "out.jpg"}); !slices.Equal(got, []string{"in.heic[0]", "out.jpg"}) {
+		t.Errorf("withExplicitFormat() = %q without -output-ext, want the output unchanged", got)
+	}
+	setFlag(t, outputExt, "img")
+	if got := outputExtension(); got != "img" {
+		t.Errorf("outputExtension() = %q, want img", got)
+	}
+	if got := withExplicitFormat([]string{"in.heic[0]", "-quality", "90", "out.img"}); !slices.Equal(got, []string{"in.heic[0]", "-quality", "90", "jpg:out.img"}) {
+		t.Errorf("withExplicitFormat() = %q, want the output prefixed with jpg:", got)
+	}
+
+	log := stubMagick(t)
+	setFlag(t, &infoOut, io.Discard)
+	dir := t.TempDir()
+	in := writeFile(t, dir, "IMG_1.heic", "source")
+	res := convertFile(context.Background(), in)
+	if want := filepath.Join(dir, "IMG_1.img"); res.Status != statusConverted || res.Err != nil || res.Target != want {
+		t.Fatalf("convertFile() = %s, %s, %v, want %s converted", res.Status, res.Target, res.Err, want)
+	}
+	if _, err := os.Stat(res.Target); err != nil {
+		t.Errorf("output was not written: %v", err)
+	}
+	commands := stubCommands(t, log)
+	if len(commands) == 0 {
+		t.Fatal("ImageMagick did not run")
+	}
+	args := strings.Fields(commands[0])
+	if out := args[len(args)-1]; !strings.HasPrefix(out, "jpg:"+dir) {
+		t.Errorf("convert wrote %s, want the output path prefixed with jpg:", out)
+	}
+}
+
+func TestWarnOutputOver(t *testing.T) {
+	tests := []struct {
+		name     string
+		outBytes string
+		limit    int64
+		wantWarn bool
+	}{
+		{name: "above the limit", outBytes: "2048", limit: 1024, wantWarn: true},
+		{name: "at the limit", outBytes: "1024", limit: 1024},
+		{name: "below the limit", outBytes: "512", limit: 1024},
+		{name: "disabled", outBytes: "2048"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubMagick(t)
+			t.Setenv("STUB_BYTES", tt.outBytes)
+			setFlag(t, outType, "jpg")
+			setFlag(t, &warnOverBytes, tt.limit)
+			setFlag(t, &infoOut, io.Discard)
+			in := writeFile(t, t.TempDir(), "IMG_1.heic", "source")
+
+			var res fileResult
+			out := captureStdout(t, func() { res = convertFile(context.Background(), in) })
+			if res.Status != statusConverted || res.Err != nil {
+				t.Fatalf("convertFile() = %s, %v", res.Status, res.Err)
+			}
+			if warned := strings.Contains(out, "above -warn-output-over"); warned != tt.wantWarn {
+				t.Errorf("warned = %v for a %s-byte output, output:\n%s", warned, tt.outBytes, out)
+			}
+			if tt.wantWarn && !strings.Contains(out, "is 2.0 KiB") {
+				t.Errorf("warning %q lacks the output size", out)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// manifestHeader is the column layout of the -manifest CSV.
+var manifestHeader = []string{"source", "target", "status", "duration_ms", "source_bytes", "target_bytes", "error", "magick_version"}
+
+// manifest is the open -manifest CSV, or nil when no manifest is written.
+var manifest struct {
+	sync.Mutex
+	file *os.File
+	w    *csv.Writer
+}
+
+// openManifest creates the -manifest CSV. When the same file is also the -skip-from-manifest input it is
+// appended to instead, so completions from earlier runs are kept.
+func openManifest(path string, appendRows bool) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendRows {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open manifest: %v", err)
+	}
+	manifest.file = f
+	manifest.w = csv.NewWriter(f)
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		manifest.w.Write(manifestHeader)
+		manifest.w.Flush()
+	}
+	return manifest.w.Error()
+}
+
+// closeManifest flushes and closes the -manifest CSV, if one is open.
+func closeManifest() {
+	if manifest.file != nil {
+		manifest.w.Flush()
+		manifest.file.Close()
+	}
+}
+
+// writeManifestRow appends res to the manifest. It is safe for concurrent use by workers.
+func writeManifestRow(res fileResult) error {
+	if manifest.file == nil {
+		return nil
+	}
+	rec := res.record()
+	row := []string{
+		rec.Source,
+		rec.Target,
+		rec.Status,
+		strconv.FormatInt(rec.DurationMS, 10),
+		strconv.FormatInt(rec.SourceBytes, 10),
+		strconv.FormatInt(rec.TargetBytes, 10),
+		rec.Error,
+		rec.Magick,
+	}
+	manifest.Lock()
+	defer manifest.Unlock()
+	manifest.w.Write(row)
+	manifest.w.Flush()
+	if err := manifest.w.Error(); err != nil {
+		return fmt.Errorf("failed to write manifest row for %s: %v", res.Source, err)
+	}
+	return nil
+}
+
+// readCompletedFromManifest returns the sources a manifest records as successfully converted.
+func readCompletedFromManifest(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %v", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest header: %v", err)
+	}
+	sourceCol, statusCol := -1, -1
+	for i, name := range header {
+		switch name {
+		case "source":
+			sourceCol = i
+		case "status":
+			statusCol = i
+		}
+	}
+	if sourceCol < 0 || statusCol < 0 {
+		return nil, fmt.Errorf("manifest %s has no source and status columns", path)
+	}
+
+	completed := make(map[string]bool)
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest: %v", err)
+		}
+		if len(row) > sourceCol && len(row) > statusCol && row[statusCol] == statusConverted {
+			completed[row[sourceCol]] = true
+		}
+	}
+	return completed, nil
+}
+
+// excludeCompleted drops files recorded as converted in the -skip-from-manifest set.
+func excludeCompleted(files []string, completed map[string]bool) []string {
+	if len(completed) == 0 {
+		return files
+	}
+	remaining := files[:0:0]
+	for _, file := range files {
+		if !completed[sourceName(file)] {
+			remaining = append(remaining, file)
+		}
+	}
+	if skipped := len(files) - len(remaining); skipped > 0 {
+		fmt.Fprintf(infoOut, "INFO: Skipping %d files already converted according to %s.\n", skipped, *skipFromManifest)
+	}
+	return remaining
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadCompletedFromManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     []string
+		err      string
+	}{
+		{
+			name: "only converted rows count",
+			manifest: "source,target,status,duration_ms,source_bytes,target_bytes,error,magick_version\n" +
+				"/p/a.heic,/p/a.jpg,converted,10,100,50,,6.9.12\n" +
+				"/p/b.heic,/p/b.jpg,failed,10,100,0,boom,6.9.12\n" +
+				"/p/c.heic,/p/c.jpg,exists,0,100,0,,6.9.12\n" +
+				"\"/p/d, e.heic\",/p/d.jpg,converted,10,100,50,,6.9.12\n",
+			want: []string{"/p/a.heic", "/p/d, e.heic"},
+		},
+		{
+			name:     "columns found by name",
+			manifest: "status,source\nconverted,/p/a.heic\n",
+			want:     []string{"/p/a.heic"},
+		},
+		{
+			name:     "short rows from an interrupted write",
+			manifest: "source,target,status\n/p/a.heic,/p/a.jpg,converted\n/p/b.heic\n",
+			want:     []string{"/p/a.heic"},
+		},
+		{
+			name:     "header only",
+			manifest: "source,target,status\n",
+		},
+		{
+			name:     "no status column",
+			manifest: "source,target\n/p/a.heic,/p/a.jpg\n",
+			err:      "no source and status columns",
+		},
+		{
+			name: "empty file",
+			err:  "failed to read manifest header",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			completed, err := readCompletedFromManifest(writeFile(t, t.TempDir(), "manifest.csv", tt.manifest))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("readCompletedFromManifest() error = %v, want it to mention %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for source := range completed {
+				got = append(got, source)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("readCompletedFromManifest() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := readCompletedFromManifest(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("readCompletedFromManifest() of a missing file succeeded")
+	}
+}
+
+func TestExcludeCompleted(t *testing.T) {
+	files := []string{"/p/a.heic", "/p/b.heic", "/p/c.heic"}
+	completed := map[string]bool{"/p/b.heic": true, "/p/other.heic": true}
+	if got := excludeCompleted(files, completed); !slices.Equal(got, []string{"/p/a.heic", "/p/c.heic"}) {
+		t.Errorf("excludeCompleted() = %q", got)
+	}
+	if got := excludeCompleted(files, nil); !slices.Equal(got, files) {
+		t.Errorf("excludeCompleted() without a manifest = %q", got)
+	}
+}
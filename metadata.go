@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// imageMetadata is the structured metadata written to <output>.meta.json.
+type imageMetadata struct {
+	Source     string            `json:"source"`
+	Format     string            `json:"format,omitempty"`
+	Geometry   string            `json:"geometry,omitempty"`
+	Colorspace string            `json:"colorspace,omitempty"`
+	EXIF       map[string]string `json:"exif,omitempty"`
+	XMP        map[string]string `json:"xmp,omitempty"`
+}
+
+// addTag files a "group:name" tag under the EXIF or XMP map, ignoring other groups.
+func (m *imageMetadata) addTag(key, value string) {
+	group, name, ok := strings.Cut(key, ":")
+	if !ok || name == "" {
+		return
+	}
+	switch strings.ToLower(group) {
+	case "exif":
+		if m.EXIF == nil {
+			m.EXIF = make(map[string]string)
+		}
+		m.EXIF[name] = value
+	case "xmp":
+		if m.XMP == nil {
+			m.XMP = make(map[string]string)
+		}
+		m.XMP[name] = value
+	}
+}
+
+// parseIdentifyVerbose extracts the image summary and exif:/xmp: properties from `identify -verbose` output.
+func parseIdentifyVerbose(output string) imageMetadata {
+	var m imageMetadata
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Format":
+			if m.Format == "" {
+				m.Format = value
+			}
+		case "Geometry":
+			if m.Geometry == "" {
+				m.Geometry = value
+			}
+		case "Colorspace":
+			if m.Colorspace == "" {
+				m.Colorspace = value
+			}
+		default:
+			m.addTag(key, value)
+		}
+	}
+	return m
+}
+
+// parseExiftoolJSON extracts metadata from `exiftool -json -G` output, whose keys are "Group:Tag".
+func parseExiftoolJSON(data []byte) (imageMetadata, error) {
+	var records []map[string]any
+	if err := json.Unmarshal(data, &records); err != nil {
+		return imageMetadata{}, fmt.Errorf("failed to parse exiftool output: %v", err)
+	}
+	var m imageMetadata
+	if len(records) == 0 {
+		return m, nil
+	}
+	for key, value := range records[0] {
+		v := fmt.Sprint(value)
+		switch key {
+		case "File:FileType":
+			m.Format = v
+		case "Composite:ImageSize":
+			m.Geometry = v
+		default:
+			m.addTag(key, v)
+		}
+	}
+	return m, nil
+}
+
+// extractMetadata reads the metadata of inFile with exiftool when installed, or ImageMagick's identify otherwise.
+func extractMetadata(inFile string) (imageMetadata, error) {
+	var m imageMetadata
+	if _, err := exec.LookPath("exiftool"); err == nil {
+		output, err := exec.Command("exiftool", "-json", "-G", inFile).Output()
+		if err != nil {
+			return m, fmt.Errorf("failed to run exiftool on %s: %v", inFile, err)
+		}
+		if m, err = parseExiftoolJSON(output); err != nil {
+			return m, err
+		}
+	} else {
+		output, err := magickCommand("identify", "-verbose", inFile+"[0]").Output()
+		if err != nil {
+			return m, fmt.Errorf("failed to identify %s: %v", inFile, err)
+		}
+		m = parseIdentifyVerbose(string(output))
+	}
+	m.Source = inFile
+	return m, nil
+}
+
+// writeMetadataJSON writes the metadata of inFile to <outFile>.meta.json and returns that path.
+func writeMetadataJSON(inFile, outFile string) (string, error) {
+	m, err := extractMetadata(inFile)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode metadata of %s: %v", inFile, err)
+	}
+	path := outFile + ".meta.json"
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return path, nil
+}
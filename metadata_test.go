@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// identifyVerbose is a trimmed `identify -verbose` listing of a HEIC.
+const identifyVerbose = `Image:
+  Filename: IMG_1.heic
+  Format: HEIC (High Efficiency Image Container)
+  Geometry: 4032x3024+0+0
+  Colorspace: sRGB
+  Properties:
+    exif:DateTimeOriginal: 2024:06:01 12:30:00
+    exif:Make: Apple
+    icc:description: Display P3
+    xmp:CreatorTool: 17.5
+  Artifacts:
+    verbose: true
+`
+
+func TestWriteMetadataJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		exiftool string
+		want     string
+	}{
+		{
+			name: "identify",
+			want: `{
+  "source": "%s",
+  "format": "HEIC (High Efficiency Image Container)",
+  "geometry": "4032x3024+0+0",
+  "colorspace": "sRGB",
+  "exif": {
+    "DateTimeOriginal": "2024:06:01 12:30:00",
+    "Make": "Apple"
+  },
+  "xmp": {
+    "CreatorTool": "17.5"
+  }
+}
+`,
+		},
+		{
+			name:     "exiftool",
+			exiftool: `[{"SourceFile": "IMG_1.heic", "File:FileType": "HEIC", "Composite:ImageSize": "4032x3024", "EXIF:Make": "Apple", "EXIF:ISO": 50, "XMP:CreatorTool": "17.5", "ICC_Profile:ProfileDescription": "Display P3"}]`,
+			want: `{
+  "source": "%s",
+  "format": "HEIC",
+  "geometry": "4032x3024",
+  "exif": {
+    "ISO": "50",
+    "Make": "Apple"
+  },
+  "xmp": {
+    "CreatorTool": "17.5"
+  }
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := stubMagick(t)
+			t.Setenv("STUB_IDENTIFY", identifyVerbose)
+			dir := t.TempDir()
+			if tt.exiftool != "" {
+				writeFile(t, dir, "exiftool.json", tt.exiftool)
+				script := "#!/bin/sh\ncat " + filepath.Join(dir, "exiftool.json") + "\n"
+				if err := os.WriteFile(filepath.Join(filepath.Dir(log), "exiftool"), []byte(script), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			} else if _, err := exec.LookPath("exiftool"); err == nil {
+				t.Skip("exiftool is installed and takes precedence over identify")
+			}
+			in := writeFile(t, dir, "IMG_1.heic", "source")
+			out := filepath.Join(dir, "IMG_1.jpg")
+
+			path, err := writeMetadataJSON(in, out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path != out+".meta.json" {
+				t.Errorf("writeMetadataJSON() path = %s, want %s.meta.json", path, out)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf(tt.want, in); string(data) != want {
+				t.Errorf("metadata JSON =\n%s\nwant\n%s", data, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// frameCount returns the number of images stored in inFile.
+func frameCount(inFile string) (int, error) {
+	output, err := magickCommand("identify", "-format", "%n\n", inFile).Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to identify %s: %v", inFile, err)
+	}
+	return parseFrameCount(string(output))
+}
+
+// parseFrameCount reads the frame count from identify's "%n" output, which repeats the count once per frame.
+func parseFrameCount(output string) (int, error) {
+	first, _, _ := strings.Cut(strings.TrimSpace(output), "\n")
+	n, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse frame count from %q: %v", output, err)
+	}
+	return n, nil
+}
+
+// buildMontageArgs assembles the montage arguments that tile every frame of inFile into outFile.
+func buildMontageArgs(inFile, outFile string) []string {
+	var args []string
+	if *montageLabel != "" {
+		args = append(args, "-label", *montageLabel)
+	}
+	args = append(args, inFile)
+	if *montageTile != "" {
+		args = append(args, "-tile", *montageTile)
+	}
+	return append(args, "-geometry", "+2+2", outFile)
+}
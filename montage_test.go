@@ -0,0 +1,75 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestBuildMontageArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		label string
+		tile  string
+		want  []string
+	}{
+		{name: "defaults", label: "%p", want: []string{"-label", "%p", "in.heic", "-geometry", "+2+2", "out.jpg"}},
+		{name: "tile geometry", label: "%p", tile: "3x2", want: []string{"-label", "%p", "in.heic", "-tile", "3x2", "-geometry", "+2+2", "out.jpg"}},
+		{name: "no labels", tile: "4x", want: []string{"in.heic", "-tile", "4x", "-geometry", "+2+2", "out.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, montageLabel, tt.label)
+			setFlag(t, montageTile, tt.tile)
+			if got := buildMontageArgs("in.heic", "out.jpg"); !slices.Equal(got, tt.want) {
+				t.Errorf("buildMontageArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFrameCount(t *testing.T) {
+	tests := []struct {
+		output string
+		want   int
+		err    bool
+	}{
+		{"1\n", 1, false},
+		{"3\n3\n3\n", 3, false},
+		{"  12 \n12\n", 12, false},
+		{"", 0, true},
+		{"n/a\n", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseFrameCount(tt.output)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseFrameCount(%q) = %d, %v, want %d, error %v", tt.output, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestBuildCommandMontage(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames string
+		want   []string
+	}{
+		{name: "burst", frames: "4\n4\n4\n4\n", want: []string{"montage", "-label", "%p", "in.heic", "-tile", "2x2", "-geometry", "+2+2", "out.jpg"}},
+		{name: "single image", frames: "1\n", want: []string{"convert", "in.heic[0]", "-auto-orient", "out.jpg"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubMagick(t)
+			t.Setenv("STUB_IDENTIFY", tt.frames)
+			setFlag(t, outType, "jpg")
+			setFlag(t, montageMode, true)
+			setFlag(t, montageTile, "2x2")
+			name, args, err := buildCommand("in.heic", "out.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != "magick" || !slices.Equal(args, tt.want) {
+				t.Errorf("buildCommand() = %s %q, want magick %q", name, args, tt.want)
+			}
+		})
+	}
+}
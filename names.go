@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// plannedOutputs maps sources whose output would collide with another source's to the output they get
+// instead. It is filled by planOutputNames before workers start and only read while they run.
+var plannedOutputs map[string]string
+
+// claimedNames maps every output planOutputNames has handed out, lowercased, to its source. It is kept
+// across the cycles of -watch, so a source arriving later cannot take an output an earlier one was given.
+var claimedNames map[string]string
+
+// numberedPath returns the nth alternative to the output <base><ext>, <base>_n<ext>, as used by both
+// planOutputNames and -rename-on-conflict.
+func numberedPath(out string, n int) string {
+	ext := filepath.Ext(out)
+	return strings.TrimSuffix(out, ext) + "_" + strconv.Itoa(n) + ext
+}
+
+// planOutputNames finds sources in files that would be converted to the same output, such as
+// IMG_001.heic and IMG_001.HEIC, or the same output as a source of an earlier -watch cycle, and gives
+// all but the first a "<base>_N<ext>" output. Outputs are compared case-insensitively, since they collide
+// once copied to a case-insensitive filesystem. Sources are considered in sorted order, so the same files
+// always get the same names across runs.
+func planOutputNames(files []string) {
+	sorted := append([]string(nil), files...)
+	sort.Strings(sorted)
+	if claimedNames == nil {
+		claimedNames = make(map[string]string, len(sorted))
+	}
+	var collided []string
+	for _, file := range sorted {
+		if _, planned := plannedOutputs[file]; planned {
+			continue
+		}
+		out, err := outputPath(file)
+		if err != nil {
+			// The source's own conversion reports this error.
+			continue
+		}
+		key := strings.ToLower(out)
+		if owner, taken := claimedNames[key]; !taken || owner == file {
+			claimedNames[key] = file
+			continue
+		}
+		collided = append(collided, file)
+	}
+	// Suffixes are handed out only after every unsuffixed name is claimed, so a source literally named
+	// "photo_1.heic" keeps its own output.
+	for _, file := range collided {
+		out, _ := outputPath(file)
+		for n := 1; ; n++ {
+			candidate := numberedPath(out, n)
+			if _, taken := claimedNames[strings.ToLower(candidate)]; taken {
+				continue
+			}
+			claimedNames[strings.ToLower(candidate)] = file
+			if plannedOutputs == nil {
+				plannedOutputs = make(map[string]string)
+			}
+			plannedOutputs[file] = candidate
+			fmt.Fprintf(os.Stdout, "WARN: %s and %s would both be converted to %s, writing %s for %s.\n", claimedNames[strings.ToLower(out)], file, out, candidate, file)
+			break
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+)
+
+// defaultNativeQuality is the JPEG quality the native backend uses without -quality, matching
+// ImageMagick's default.
+const defaultNativeQuality = 92
+
+// inProcessConverter is implemented by backends that convert a file themselves instead of running the
+// program returned by Command, which then only describes the conversion for logs and cache keys.
+type inProcessConverter interface {
+	// Convert writes inFile to outFile, reporting warnings that did not stop the conversion to stderr.
+	Convert(ctx context.Context, inFile, outFile string, stderr io.Writer) error
+}
+
+// nativeConverter decodes HEIC in-process through libheif's C library and encodes with Go's image
+// packages, so no external program is spawned. It is only available in builds with the libheif tag.
+type nativeConverter struct{}
+
+// Verify checks that the native decoder was compiled in.
+func (nativeConverter) Verify() error {
+	return nativeSupport()
+}
+
+// Command describes the in-process conversion of one file.
+func (nativeConverter) Command(inFile, outFile string) (string, []string, error) {
+	return "native", []string{inFile, "-quality", strconv.Itoa(nativeQuality()), outFile}, nil
+}
+
+// Convert decodes inFile and writes it to outFile as PNG or JPEG, following -output. libheif's decoding
+// warnings go to stderr.
+func (nativeConverter) Convert(ctx context.Context, inFile, outFile string, stderr io.Writer) error {
+	img, err := decodeHEIC(inFile, *autoOrient, stderr)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %v", inFile, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return encodeImage(img, outFile)
+}
+
+// nativeQuality returns the JPEG quality for native encoding.
+func nativeQuality() int {
+	if *jpegQuality > 0 {
+		return *jpegQuality
+	}
+	return defaultNativeQuality
+}
+
+// encodeImage writes img to outFile in the -output format, removing the file again if encoding fails.
+func encodeImage(img image.Image, outFile string) error {
+	f, err := os.Create(outFile)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", outFile, err)
+	}
+	if isJpegType(*outType) {
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: nativeQuality()})
+	} else {
+		err = png.Encode(f, img)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(outFile)
+		return fmt.Errorf("failed to encode %s: %v", outFile, err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+//go:build cgo && libheif
+
+package main
+
+/*
+#cgo pkg-config: libheif
+#include <stdlib.h>
+#include <libheif/heif.h>
+
+// decoding_warnings copies up to max warnings libheif recorded while decoding img into out. Versions
+// before 1.13 do not record them.
+static int decoding_warnings(const struct heif_image* img, struct heif_error* out, int max) {
+#if LIBHEIF_HAVE_VERSION(1, 13, 0)
+	return heif_image_get_decoding_warnings((struct heif_image*)img, 0, out, max);
+#else
+	return 0;
+#endif
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+	"unsafe"
+)
+
+// heifInit loads libheif's decoder plugins once per process.
+var heifInit = sync.OnceValue(func() error {
+	return heifError(C.heif_init(nil))
+})
+
+// nativeSupport initializes libheif.
+func nativeSupport() error {
+	if err := heifInit(); err != nil {
+		return fmt.Errorf("failed to initialize libheif: %v", err)
+	}
+	return nil
+}
+
+// heifError converts a libheif status into a Go error, or nil on success.
+func heifError(err C.struct_heif_error) error {
+	if err.code == C.heif_error_Ok {
+		return nil
+	}
+	return errors.New(C.GoString(err.message))
+}
+
+// maxDecodingWarnings bounds how many of libheif's decoding warnings are reported per image.
+const maxDecodingWarnings = 16
+
+// decodeHEIC decodes the primary image of inFile into 8-bit RGBA, writing libheif's decoding warnings to
+// warnings one per line. With orient, libheif applies the container's rotation and mirroring; otherwise
+// the pixels are returned as stored.
+func decodeHEIC(inFile string, orient bool, warnings io.Writer) (image.Image, error) {
+	ctx := C.heif_context_alloc()
+	if ctx == nil {
+		return nil, errors.New("failed to allocate a libheif context")
+	}
+	defer C.heif_context_free(ctx)
+
+	path := C.CString(inFile)
+	defer C.free(unsafe.Pointer(path))
+	if err := heifError(C.heif_context_read_from_file(ctx, path, nil)); err != nil {
+		return nil, err
+	}
+
+	var handle *C.struct_heif_image_handle
+	if err := heifError(C.heif_context_get_primary_image_handle(ctx, &handle)); err != nil {
+		return nil, err
+	}
+	defer C.heif_image_handle_release(handle)
+
+	var options *C.struct_heif_decoding_options
+	if !orient {
+		options = C.heif_decoding_options_alloc()
+		options.ignore_transformations = 1
+		defer C.heif_decoding_options_free(options)
+	}
+	var img *C.struct_heif_image
+	if err := heifError(C.heif_decode_image(handle, &img, C.heif_colorspace_RGB, C.heif_chroma_interleaved_RGBA, options)); err != nil {
+		return nil, err
+	}
+	defer C.heif_image_release(img)
+	var found [maxDecodingWarnings]C.struct_heif_error
+	n := int(C.decoding_warnings(img, &found[0], maxDecodingWarnings))
+	for _, w := range found[:n] {
+		fmt.Fprintln(warnings, C.GoString(w.message))
+	}
+
+	var stride C.int
+	plane := C.heif_image_get_plane_readonly(img, C.heif_channel_interleaved, &stride)
+	if plane == nil {
+		return nil, errors.New("decoded image has no interleaved RGBA plane")
+	}
+	width := int(C.heif_image_get_width(img, C.heif_channel_interleaved))
+	height := int(C.heif_image_get_height(img, C.heif_channel_interleaved))
+
+	out := image.NewNRGBA(image.Rect(0, 0, width, height))
+	src := unsafe.Slice((*byte)(unsafe.Pointer(plane)), int(stride)*height)
+	for y := 0; y < height; y++ {
+		copy(out.Pix[y*out.Stride:y*out.Stride+width*4], src[y*int(stride):])
+	}
+	return out, nil
+}
@@ -0,0 +1,19 @@
+//go:build !(cgo && libheif)
+
+package main
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// nativeSupport reports that this build has no native decoder.
+func nativeSupport() error {
+	return errors.New("this build has no native HEIC decoder, rebuild with CGO_ENABLED=1 and -tags libheif (requires the libheif development package)")
+}
+
+// decodeHEIC is unavailable without the libheif build tag.
+func decodeHEIC(string, bool, io.Writer) (image.Image, error) {
+	return nil, nativeSupport()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// validErrorPolicies are the accepted -on-error values.
+var validErrorPolicies = map[string]bool{
+	"fail":     true,
+	"continue": true,
+	"prompt":   true,
+}
+
+// validateErrorPolicy checks -on-error and folds -fail-fast into it.
+func validateErrorPolicy() error {
+	if !validErrorPolicies[*onError] {
+		return fmt.Errorf("invalid -on-error %q. Use 'fail', 'continue' or 'prompt'", *onError)
+	}
+	if *failFast {
+		if flagSet("on-error") && *onError != "fail" {
+			return fmt.Errorf("-fail-fast cannot be combined with -on-error %s", *onError)
+		}
+		*onError = "fail"
+	}
+	if *onError == "prompt" && (*filesFrom == "-" || streams.stdin) {
+		return errors.New("-on-error prompt reads answers from stdin, so it cannot be combined with -files-from - or -input -")
+	}
+	return nil
+}
+
+// errorPrompt serializes -on-error prompt questions across workers and remembers the answers that
+// apply to the rest of the run.
+var errorPrompt struct {
+	sync.Mutex
+	reader      *bufio.Reader
+	continueAll bool
+	stopped     bool
+}
+
+// stopOnError reports whether the run should stop after file failed with err, as -on-error decides.
+func stopOnError(file string, err error) bool {
+	switch *onError {
+	case "fail":
+		return true
+	case "prompt":
+		return !askToContinue(file, err)
+	}
+	return false
+}
+
+// askToContinue asks on the terminal whether to go on after a failure. Answering "all" continues
+// without asking again; anything but yes stops the run, as does stdin running out.
+func askToContinue(file string, err error) bool {
+	errorPrompt.Lock()
+	defer errorPrompt.Unlock()
+	if errorPrompt.continueAll {
+		return true
+	}
+	if errorPrompt.stopped {
+		return false
+	}
+	if errorPrompt.reader == nil {
+		errorPrompt.reader = bufio.NewReader(os.Stdin)
+	}
+	fmt.Fprintf(os.Stderr, "Failed to convert %s: %v\nContinue with the remaining files? [y/N/a(ll)] ", file, err)
+	answer, _ := errorPrompt.reader.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	case "a", "all":
+		errorPrompt.continueAll = true
+		return true
+	}
+	errorPrompt.stopped = true
+	return false
+}
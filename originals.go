@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// outputSignatures are the leading bytes every valid output of a format starts with. For formats in an
+// ISO media or RIFF container, offset says where the signature sits.
+var outputSignatures = map[string]struct {
+	offset int
+	magic  []byte
+}{
+	"png":  {0, []byte("\x89PNG\r\n\x1a\n")},
+	"jpg":  {0, []byte{0xFF, 0xD8, 0xFF}},
+	"jpeg": {0, []byte{0xFF, 0xD8, 0xFF}},
+	"webp": {8, []byte("WEBP")},
+	"avif": {4, []byte("ftyp")},
+	"bmp":  {0, []byte("BM")},
+	"heic": {4, []byte("ftyp")},
+}
+
+// validateOriginalsFlags checks -delete-source, -delete-originals and -move-originals, resolving the
+// move directory and creating it unless this is a dry run.
+func validateOriginalsFlags() error {
+	if *deleteOriginals {
+		*deleteSource = true
+	}
+	if *deleteSource && *moveSources != "" {
+		return errors.New("-delete-source and -move-originals cannot be used together")
+	}
+	if *dryRun && (*deleteSource || *moveSources != "") {
+		return errors.New("-dry-run cannot be used with -delete-source or -move-originals")
+	}
+	if *moveSources == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(*moveSources)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute -move-originals path: %v", err)
+	}
+	*moveSources = abs
+	if err := os.MkdirAll(*moveSources, 0o755); err != nil {
+		return fmt.Errorf("failed to create -move-originals directory: %v", err)
+	}
+	return nil
+}
+
+// verifyOutput checks that outFile is non-empty and starts with the header of the -output format, so a
+// source is never deleted or moved away for an output that could not be opened.
+func verifyOutput(outFile string) error {
+	f, err := os.Open(outFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, 16)
+	n, err := io.ReadFull(f, head)
+	if n == 0 {
+		return errors.New("it is empty")
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+	if *outType == "tiff" {
+		if bytes.HasPrefix(head, []byte("II*\x00")) || bytes.HasPrefix(head, []byte("MM\x00*")) {
+			return nil
+		}
+		return errors.New("it does not start with a TIFF header")
+	}
+	sig, ok := outputSignatures[*outType]
+	if !ok {
+		return nil
+	}
+	if len(head) < sig.offset+len(sig.magic) || !bytes.Equal(head[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+		return fmt.Errorf("it does not start with a %s header", *outType)
+	}
+	return nil
+}
+
+// disposeSource deletes inFile for -delete-source, or moves it into the -move-originals directory, but only
+// once outFile is confirmed to be a complete output.
+func disposeSource(inFile, outFile string) error {
+	if err := verifyOutput(outFile); err != nil {
+		return fmt.Errorf("keeping %s, its output %s could not be verified: %v", inFile, outFile, err)
+	}
+	if *moveSources == "" {
+		if err := os.Remove(inFile); err != nil {
+			return fmt.Errorf("failed to delete source %s: %v", inFile, err)
+		}
+		fmt.Fprintf(infoOut, "INFO: Deleted source %s.\n", inFile)
+		return nil
+	}
+
+	dest := archivePath(*moveSources, inFile)
+	if _, err := os.Lstat(dest); err == nil {
+		return fmt.Errorf("keeping %s, %s already exists", inFile, dest)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %v", dest, err)
+	}
+	if err := moveFile(inFile, dest); err != nil {
+		return fmt.Errorf("failed to move source %s to %s: %v", inFile, dest, err)
+	}
+	fmt.Fprintf(infoOut, "INFO: Moved source %s to %s.\n", inFile, dest)
+	return nil
+}
+
+// archivePath returns where inFile goes inside dir. It keeps the layout below the -relative-to base, or
+// below a directory -input, so same-named sources from different subdirectories don't collide.
+func archivePath(dir, inFile string) string {
+	base := *relativeTo
+	if base == "" && *inPath != inFile {
+		base = *inPath
+	}
+	if base != "" && isWithin(base, inFile) {
+		if rel, err := filepath.Rel(base, inFile); err == nil {
+			return filepath.Join(dir, rel)
+		}
+	}
+	return filepath.Join(dir, filepath.Base(inFile))
+}
+
+// moveFile renames src to dest, copying and then removing src when they are on different filesystems.
+func moveFile(src, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dest)
+		return err
+	}
+	os.Chtimes(dest, info.ModTime(), info.ModTime())
+	return os.Remove(src)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isWithin reports whether path is base itself or lies underneath it.
+func isWithin(base, path string) bool {
+	rel, err := filepath.Rel(base, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// outputPath returns where the output for inFile is written. Without -outdir it sits next to the source.
+// With -outdir it goes directly inside that directory, or, when -relative-to is set, under the source's
+// directory path relative to that base. -output-file overrides all of this for its single source, and
+// planOutputNames for sources whose outputs would collide.
+func outputPath(inFile string) (string, error) {
+	if *outputFile != "" {
+		return outputFileTarget(), nil
+	}
+	if out, ok := plannedOutputs[inFile]; ok {
+		return out, nil
+	}
+	name := buildOutputFilename(inFile, outputExtension())
+	if *outDir == "" {
+		return name, nil
+	}
+	if *relativeTo == "" {
+		return filepath.Join(*outDir, filepath.Base(name)), nil
+	}
+	if !isWithin(*relativeTo, inFile) {
+		return "", fmt.Errorf("source %s is not under -relative-to %s", inFile, *relativeTo)
+	}
+	rel, err := filepath.Rel(*relativeTo, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute output path for %s: %v", inFile, err)
+	}
+	return filepath.Join(*outDir, rel), nil
+}
+
+// prepareOutputPath resolves the output path for inFile and, unless -dry-run is set, creates its parent directory.
+func prepareOutputPath(inFile string) (string, error) {
+	out, err := outputPath(inFile)
+	if err != nil {
+		return "", err
+	}
+	if *outDir != "" && !*dryRun {
+		if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+			return "", fmt.Errorf("failed to create output directory for %s: %v", inFile, err)
+		}
+	}
+	return out, nil
+}
+
+// validateOutputDir resolves -outdir and -relative-to to absolute paths, creates the output directory,
+// and checks that the input lies under the -relative-to base.
+func validateOutputDir() error {
+	if *outDir == "" {
+		if *relativeTo != "" {
+			return errors.New("-relative-to requires -outdir")
+		}
+		return nil
+	}
+	abs, err := filepath.Abs(*outDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute output directory: %v", err)
+	}
+	*outDir = abs
+	if !*dryRun {
+		if err := os.MkdirAll(*outDir, 0o755); err != nil {
+			return fmt.Errorf("failed to create output directory: %v", err)
+		}
+	}
+	fmt.Fprintln(infoOut, "INFO: Output Directory:", *outDir)
+
+	if *relativeTo != "" {
+		if *relativeTo, err = filepath.Abs(*relativeTo); err != nil {
+			return fmt.Errorf("failed to get absolute -relative-to path: %v", err)
+		}
+		for _, input := range *inputs {
+			if hasGlobMeta(input) {
+				input = globRoot(input)
+			}
+			if !isWithin(*relativeTo, input) {
+				return fmt.Errorf("-relative-to %s is not an ancestor of the input %s", *relativeTo, input)
+			}
+		}
+	}
+	return nil
+}
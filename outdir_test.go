@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputPath(t *testing.T) {
+	root := t.TempDir()
+	photos := filepath.Join(root, "photos")
+	out := filepath.Join(root, "out")
+	nested := filepath.Join(photos, "2024", "06", "IMG_1.heic")
+	tests := []struct {
+		name       string
+		outDir     string
+		relativeTo string
+		in         string
+		want       string
+		wantErr    string
+	}{
+		{name: "next to the source", in: nested, want: filepath.Join(photos, "2024", "06", "IMG_1.jpg")},
+		{name: "flat in -outdir", outDir: out, in: nested, want: filepath.Join(out, "IMG_1.jpg")},
+		{name: "mirrored from the scanned root", outDir: out, relativeTo: photos, in: nested, want: filepath.Join(out, "2024", "06", "IMG_1.jpg")},
+		{name: "mirrored from a deeper base", outDir: out, relativeTo: filepath.Join(photos, "2024"), in: nested, want: filepath.Join(out, "06", "IMG_1.jpg")},
+		{name: "mirrored from a shallower base", outDir: out, relativeTo: root, in: nested, want: filepath.Join(out, "photos", "2024", "06", "IMG_1.jpg")},
+		{name: "source directly in the base", outDir: out, relativeTo: photos, in: filepath.Join(photos, "IMG_2.heic"), want: filepath.Join(out, "IMG_2.jpg")},
+		{name: "source outside the base", outDir: out, relativeTo: filepath.Join(photos, "2023"), in: nested, wantErr: "is not under -relative-to"},
+		{name: "sibling sharing the base's prefix", outDir: out, relativeTo: photos, in: filepath.Join(root, "photos-old", "IMG_3.heic"), wantErr: "is not under -relative-to"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, outType, "jpg")
+			setFlag(t, outDir, tt.outDir)
+			setFlag(t, relativeTo, tt.relativeTo)
+			got, err := outputPath(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("outputPath(%s) = %s, %v, want an error containing %q", tt.in, got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("outputPath(%s) = %s, %v, want %s", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateOutputDirRelativeTo(t *testing.T) {
+	root := t.TempDir()
+	photos := filepath.Join(root, "photos")
+	tests := []struct {
+		name       string
+		outDir     string
+		relativeTo string
+		inputs     stringList
+		wantErr    string
+	}{
+		{name: "base above the input", outDir: filepath.Join(root, "out"), relativeTo: root, inputs: stringList{filepath.Join(photos, "2024")}},
+		{name: "base equal to the input", outDir: filepath.Join(root, "out"), relativeTo: photos, inputs: stringList{photos}},
+		{name: "glob under the base", outDir: filepath.Join(root, "out"), relativeTo: photos, inputs: stringList{filepath.Join(photos, "*", "*.heic")}},
+		{name: "input outside the base", outDir: filepath.Join(root, "out"), relativeTo: filepath.Join(photos, "2024"), inputs: stringList{photos}, wantErr: "is not an ancestor of the input"},
+		{name: "no -outdir", relativeTo: photos, inputs: stringList{photos}, wantErr: "-relative-to requires -outdir"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, outDir, tt.outDir)
+			setFlag(t, relativeTo, tt.relativeTo)
+			setFlag(t, inputs, tt.inputs)
+			setFlag(t, &infoOut, io.Discard)
+			err := validateOutputDir()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateOutputDir() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateOutputDir() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// peakRSS returns the peak resident set size in bytes of a finished process and of the largest of its
+// own children, such as the ImageMagick processes it ran.
+func peakRSS(state *os.ProcessState) (int64, bool) {
+	ru, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0, false
+	}
+	if runtime.GOOS == "darwin" {
+		// macOS reports bytes where Linux and the BSDs report kilobytes.
+		return int64(ru.Maxrss), true
+	}
+	return int64(ru.Maxrss) * 1024, true
+}
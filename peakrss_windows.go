@@ -0,0 +1,10 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// peakRSS reports no peak memory on Windows, whose process accounting os/exec does not expose.
+func peakRSS(state *os.ProcessState) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// writableDirs caches the result of checkWritableDir per directory, since a dry run over a large library
+// asks about the same few output directories for thousands of files.
+var writableDirs struct {
+	sync.Mutex
+	results map[string]error
+}
+
+// checkWritableDir reports whether outputs could be written to dir. A directory that does not exist yet
+// is judged by its nearest existing ancestor, where it would be created.
+func checkWritableDir(dir string) error {
+	writableDirs.Lock()
+	defer writableDirs.Unlock()
+	if writableDirs.results == nil {
+		writableDirs.results = make(map[string]error)
+	}
+	if err, ok := writableDirs.results[dir]; ok {
+		return err
+	}
+
+	existing := nearestExistingDir(dir)
+	// Probe with a real file, which unlike permission bits also catches read-only mounts and ACLs.
+	var err error
+	if probe, createErr := os.CreateTemp(existing, ".convert_heic_probe_*"); createErr != nil {
+		err = fmt.Errorf("output directory %s is not writable: %v", dir, createErr)
+	} else {
+		probe.Close()
+		os.Remove(probe.Name())
+	}
+	writableDirs.results[dir] = err
+	return err
+}
+
+// nearestExistingDir returns dir or, when it does not exist yet, its nearest ancestor that does.
+func nearestExistingDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// planConversion prints the dry-run line for one source, flagging an output it would replace and failing
+// when the output directory is not writable.
+func planConversion(inFile, outFile string) error {
+	if err := checkWritableDir(filepath.Dir(outFile)); err != nil {
+		return err
+	}
+	if _, err := statOutput(outFile); err == nil {
+		fmt.Fprintf(infoOut, "WOULD convert %s to %s, replacing the existing output\n", inFile, outFile)
+		return nil
+	}
+	fmt.Fprintf(infoOut, "WOULD convert %s to %s\n", inFile, outFile)
+	return nil
+}
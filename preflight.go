@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// preflightSampleSize is how many files are test-converted to estimate the output/source size ratio.
+	preflightSampleSize = 3
+	// preflightMargin pads the extrapolated estimate to absorb variance between files.
+	preflightMargin = 1.1
+	// preflightListLimit is how many unwritable directories are listed before the rest are counted.
+	preflightListLimit = 10
+)
+
+// estimateOutputRatio test-converts a few files into a temporary directory, with the command lines and
+// backend of the real run, and returns the observed output/source size ratio padded by preflightMargin,
+// or 0 when no sample could be read. Renditions, thumbnails and frames count towards the output.
+func estimateOutputRatio(ctx context.Context, files []string) (float64, error) {
+	tmpDir, err := os.MkdirTemp("", "convert_heic_preflight")
+	if err != nil {
+		return 0, withExitCode(exitEnvironment, fmt.Errorf("failed to create preflight directory: %v", err))
+	}
+	defer os.RemoveAll(tmpDir)
+	if *thumbDir != "" {
+		// Keep the sample thumbnails with the rest of the sample, rather than in the real -thumb-dir.
+		defer func(dir string) { *thumbDir = dir }(*thumbDir)
+		*thumbDir = tmpDir
+	}
+
+	var sampledIn, sampledOut int64
+	for _, file := range files[:min(len(files), preflightSampleSize)] {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		target, err := outputPath(file)
+		if err != nil {
+			continue
+		}
+		out := filepath.Join(tmpDir, filepath.Base(target))
+		name, args, err := converter.Command(file, out)
+		if err != nil {
+			return 0, fmt.Errorf("preflight conversion of %s failed: %v", file, err)
+		}
+		res := fileResult{Source: file, Target: out, SourceSize: info.Size()}
+		if err := runConversion(ctx, &res, name, args); err != nil {
+			return 0, fmt.Errorf("preflight conversion failed: %v", err)
+		}
+		written, err := sampleBytes(tmpDir)
+		if err != nil {
+			return 0, fmt.Errorf("preflight output for %s missing: %v", file, err)
+		}
+		sampledIn += info.Size()
+		sampledOut += written
+	}
+	if sampledIn == 0 {
+		return 0, nil
+	}
+	return float64(sampledOut) / float64(sampledIn) * preflightMargin, nil
+}
+
+// sampleBytes returns the total size of the files a sample conversion wrote to dir and removes them.
+func sampleBytes(dir string) (int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			total += info.Size()
+		}
+		os.RemoveAll(path)
+	}
+	if total == 0 {
+		return 0, errors.New("the conversion wrote nothing")
+	}
+	return total, nil
+}
+
+// outputDirs returns the distinct directories the outputs of files are written to, sorted.
+func outputDirs(files []string) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, file := range files {
+		out, err := outputPath(file)
+		if err != nil {
+			// The source's own conversion reports this error.
+			continue
+		}
+		if dir := filepath.Dir(out); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// preflightFailure aborts the run with msg or, with -force, only reports it as a warning.
+func preflightFailure(msg string) error {
+	if *force {
+		fmt.Fprintln(os.Stdout, "WARN:", msg)
+		return nil
+	}
+	return withExitCode(exitEnvironment, fmt.Errorf("%s (use -force to continue anyway)", msg))
+}
+
+// preflightPermissions checks that every directory outputs will be written to is writable, so a
+// read-only destination is reported once up front rather than once per file.
+func preflightPermissions(files []string) error {
+	dirs := outputDirs(files)
+	var failed []string
+	for _, dir := range dirs {
+		if err := checkWritableDir(dir); err != nil {
+			failed = append(failed, "  "+err.Error())
+		}
+	}
+	if len(failed) == 0 {
+		fmt.Fprintf(infoOut, "INFO: Output directories are writable (%d checked).\n", len(dirs))
+		return nil
+	}
+	msg := fmt.Sprintf("%d of %d output directories are not writable:", len(failed), len(dirs))
+	if len(failed) > preflightListLimit {
+		failed = append(failed[:preflightListLimit], fmt.Sprintf("  and %d more", len(failed)-preflightListLimit))
+	}
+	return preflightFailure(msg + "\n" + strings.Join(failed, "\n"))
+}
+
+// diskFree returns the free space on the filesystem holding a path. It is a variable so tests can stub it.
+var diskFree = volumeFree
+
+// preflightDiskSpace aborts the run when the estimated output size exceeds the free space where outputs
+// are written. Outputs are totaled per filesystem, so a batch spread over several disks is checked
+// against each one. With -force a shortfall is only reported as a warning.
+func preflightDiskSpace(ctx context.Context, files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+	ratio, err := estimateOutputRatio(ctx, files)
+	if err != nil {
+		return err
+	}
+
+	type destination struct {
+		dir    string
+		needed int64
+	}
+	var order []string
+	byFS := make(map[string]*destination)
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		out, err := outputPath(file)
+		if err != nil {
+			continue
+		}
+		dir := nearestExistingDir(filepath.Dir(out))
+		key := fileSystemKey(dir)
+		d, ok := byFS[key]
+		if !ok {
+			d = &destination{dir: dir}
+			byFS[key] = d
+			order = append(order, key)
+		}
+		d.needed += int64(float64(info.Size()) * ratio)
+	}
+
+	var short []string
+	for _, key := range order {
+		d := byFS[key]
+		free, err := diskFree(d.dir)
+		if errors.Is(err, errors.ErrUnsupported) {
+			fmt.Fprintln(infoOut, "INFO: Free disk space cannot be read on this system; skipping the disk space check.")
+			return nil
+		}
+		if err != nil {
+			return withExitCode(exitEnvironment, fmt.Errorf("failed to read free space of %s: %v", d.dir, err))
+		}
+		fmt.Fprintf(infoOut, "INFO: Estimated output size %s, %s free in %s.\n", formatBytes(d.needed), formatBytes(int64(free)), d.dir)
+		if uint64(d.needed) > free {
+			short = append(short, fmt.Sprintf("in %s: need about %s, only %s free", d.dir, formatBytes(d.needed), formatBytes(int64(free))))
+		}
+	}
+	if len(short) == 0 {
+		return nil
+	}
+	return preflightFailure("insufficient disk space " + strings.Join(short, "; "))
+}
+
+// formatBytes renders a byte count using binary units, e.g. "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
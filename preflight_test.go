@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEstimateOutputRatio(t *testing.T) {
+	tests := []struct {
+		name      string
+		thumbnail bool
+		sizes     []int
+		want      float64
+	}{
+		{name: "output only", want: 0.5},
+		{name: "with a thumbnail", thumbnail: true, want: 1},
+		{name: "with renditions and a thumbnail", thumbnail: true, sizes: []int{800, 400}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := stubMagick(t)
+			t.Setenv("STUB_BYTES", "500")
+			dir := t.TempDir()
+			setFlag(t, outType, "jpg")
+			setFlag(t, withThumbnail, tt.thumbnail)
+			setFlag(t, thumbDir, filepath.Join(dir, "thumbs"))
+			setFlag(t, &renditionSizes, tt.sizes)
+			files := []string{
+				writeFile(t, dir, "a.heic", strings.Repeat("x", 1000)),
+				writeFile(t, dir, "b.heic", strings.Repeat("x", 1000)),
+			}
+
+			ratio, err := estimateOutputRatio(context.Background(), files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.want * preflightMargin; math.Abs(ratio-want) > 1e-9 {
+				t.Errorf("estimateOutputRatio() = %v, want %v", ratio, want)
+			}
+			// Samples are written to a scratch directory, never next to the sources or into -thumb-dir.
+			for _, path := range []string{filepath.Join(dir, "a.jpg"), filepath.Join(dir, "thumbs")} {
+				if _, err := os.Stat(path); err == nil {
+					t.Errorf("a sample left %s behind", path)
+				}
+			}
+			if *thumbDir != filepath.Join(dir, "thumbs") {
+				t.Errorf("-thumb-dir is %q after sampling", *thumbDir)
+			}
+			if n := len(stubCommands(t, log)); n != len(files) {
+				t.Errorf("ran %d commands, want one per sample", n)
+			}
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestPreflightPermissions(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to read-only directories")
+	}
+	dir := t.TempDir()
+	readOnly := filepath.Join(dir, "ro")
+	if err := os.Mkdir(readOnly, 0o555); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, outType, "jpg")
+	setFlag(t, force, false)
+	files := []string{writeFile(t, dir, "a.heic", "x")}
+	if err := preflightPermissions(files); err != nil {
+		t.Errorf("preflightPermissions() = %v for a writable directory", err)
+	}
+	setFlag(t, outDir, readOnly)
+	err := preflightPermissions(files)
+	if err == nil || exitCodeOf(err, 0) != exitEnvironment {
+		t.Errorf("preflightPermissions() = %v, want an environment error for %s", err, readOnly)
+	}
+	setFlag(t, force, true)
+	if err := preflightPermissions(files); err != nil {
+		t.Errorf("preflightPermissions() with -force = %v, want only a warning", err)
+	}
+}
+
+func TestPreflightDiskSpace(t *testing.T) {
+	stubMagick(t)
+	t.Setenv("STUB_BYTES", "500")
+	dir := t.TempDir()
+	setFlag(t, outType, "jpg")
+	files := []string{
+		writeFile(t, dir, "a.heic", strings.Repeat("x", 1000)),
+		writeFile(t, dir, "b.heic", strings.Repeat("x", 1000)),
+	}
+	// Samples encode to half their source, so the batch needs about 1000 bytes plus the margin.
+	needed := uint64(2 * 1000 * 0.5 * preflightMargin)
+
+	tests := []struct {
+		name  string
+		free  uint64
+		force bool
+		fails bool
+	}{
+		{name: "enough space", free: needed + 100},
+		{name: "short of space", free: needed - 100, fails: true},
+		{name: "short of space with -force", free: needed - 100, force: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, force, tt.force)
+			var checked []string
+			setFlag(t, &diskFree, func(path string) (uint64, error) {
+				checked = append(checked, path)
+				return tt.free, nil
+			})
+			var err error
+			warning := captureStdout(t, func() { err = preflightDiskSpace(context.Background(), files) })
+			if len(checked) != 1 || checked[0] != dir {
+				t.Errorf("checked free space of %q, want only %s", checked, dir)
+			}
+			if tt.fails {
+				if err == nil || exitCodeOf(err, 0) != exitEnvironment || !strings.Contains(err.Error(), "insufficient disk space") {
+					t.Errorf("preflightDiskSpace() = %v, want an insufficient disk space error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("preflightDiskSpace() = %v", err)
+			}
+			if got := strings.Contains(warning, "WARN: insufficient disk space"); got != tt.force {
+				t.Errorf("preflightDiskSpace() printed %q, want a warning = %v", warning, tt.force)
+			}
+		})
+	}
+
+	setFlag(t, &diskFree, func(string) (uint64, error) { return 0, errors.ErrUnsupported })
+	if err := preflightDiskSpace(context.Background(), files); err != nil {
+		t.Errorf("preflightDiskSpace() = %v where free space cannot be read, want the check skipped", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// profileRecord is the -json shape of one -probe-profile result; Profile is empty when none is embedded.
+type profileRecord struct {
+	Type    string `json:"type"`
+	Source  string `json:"source"`
+	Profile string `json:"profile"`
+}
+
+// probeColorProfile returns the description of the ICC profile embedded in inFile, or "" if it has none.
+func probeColorProfile(inFile string) (string, error) {
+	output, err := magickCommand("identify", "-format", "%[icc:description]", inFile+"[0]").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to identify %s: %v", inFile, err)
+	}
+	return parseProfileDescription(string(output)), nil
+}
+
+// parseProfileDescription normalizes identify's %[icc:description] output, which is empty when no profile is embedded.
+func parseProfileDescription(output string) string {
+	return strings.TrimSpace(strings.Trim(strings.TrimSpace(output), `"`))
+}
+
+// runProfileProbe reports the embedded color profile of every input file, flagging files without one.
+func runProfileProbe(inPathInfo os.FileInfo) error {
+	files, err := inputFiles(inPathInfo)
+	if err != nil {
+		return err
+	}
+
+	var missing int
+	for _, file := range files {
+		desc, err := probeColorProfile(file)
+		if err != nil {
+			return err
+		}
+		if err := writeJSON(profileRecord{Type: "profile", Source: file, Profile: desc}); err != nil {
+			return fmt.Errorf("failed to write JSON record for %s: %v", file, err)
+		}
+		if desc == "" {
+			missing++
+			fmt.Fprintf(os.Stdout, "WARN: %s has no embedded color profile.\n", file)
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "INFO: %s: %s\n", file, desc)
+	}
+	fmt.Fprintf(os.Stdout, "INFO: Probed %d files, %d without a color profile.\n", len(files), missing)
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseProfileDescription(t *testing.T) {
+	tests := []struct {
+		output string
+		want   string
+	}{
+		{"Display P3", "Display P3"},
+		{"\"sRGB IEC61966-2.1\"\n", "sRGB IEC61966-2.1"},
+		{"  \" Adobe RGB (1998) \" ", "Adobe RGB (1998)"},
+		{"\n", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := parseProfileDescription(tt.output); got != tt.want {
+			t.Errorf("parseProfileDescription(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestRunProfileProbe(t *testing.T) {
+	log := stubMagick(t)
+	dir := t.TempDir()
+	p3 := writeFile(t, dir, "a.heic", "x")
+	writeFile(t, dir, "a.heic.icc", "\"Display P3\"\n")
+	plain := writeFile(t, dir, "b.heic", "x")
+	setFlag(t, inPath, dir)
+	var records bytes.Buffer
+	setFlag(t, &jsonOut, json.NewEncoder(&records))
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := captureStdout(t, func() { err = runProfileProbe(info) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"INFO: " + p3 + ": Display P3", "WARN: " + plain + " has no embedded color profile.", "Probed 2 files, 1 without a color profile."} {
+		if !strings.Contains(output, want) {
+			t.Errorf("runProfileProbe() printed %q, want %q", output, want)
+		}
+	}
+	if commands := stubCommands(t, log); len(commands) != 2 || !strings.Contains(commands[0], "%[icc:description] "+p3+"[0]") {
+		t.Errorf("commands = %q, want one profile identify per file", commands)
+	}
+
+	dec := json.NewDecoder(&records)
+	for _, want := range []profileRecord{{Type: "profile", Source: p3, Profile: "Display P3"}, {Type: "profile", Source: plain}} {
+		var rec profileRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec != want {
+			t.Errorf("JSON record = %+v, want %+v", rec, want)
+		}
+	}
+}
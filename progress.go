@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressInterval is the minimum time between redraws of the progress line.
+const progressInterval = 100 * time.Millisecond
+
+// progress draws a live "converted N/M, F failed, R files/s, ETA D" line on stderr as workers finish files.
+type progress struct {
+	mu       sync.Mutex
+	total    int
+	done     int
+	failed   int
+	start    time.Time
+	lastDraw time.Time
+	drawn    bool
+	prevInfo io.Writer
+}
+
+// newProgress starts a progress line for total files, or returns nil when stderr is not a terminal or
+// other output would garble it. While it runs, INFO lines are routed through it so they don't overwrite the line.
+func newProgress(total int) *progress {
+	if *noProgress || *quiet || *verbose || *debugSerial || total < 2 || !isTerminal(os.Stderr) {
+		return nil
+	}
+	p := &progress{total: total, start: time.Now(), prevInfo: infoOut}
+	infoOut = progressWriter{p}
+	return p
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// record counts one finished file and redraws the line when it is due.
+func (p *progress) record(failed bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if failed {
+		p.failed++
+	}
+	if time.Since(p.lastDraw) >= progressInterval || p.done == p.total {
+		p.draw()
+	}
+}
+
+// draw writes the progress line; the caller holds p.mu.
+func (p *progress) draw() {
+	line := fmt.Sprintf("converted %d/%d, %d failed", p.done, p.total, p.failed)
+	elapsed := time.Since(p.start)
+	if p.done > 0 {
+		line += ", " + formatRate(p.done, elapsed)
+	}
+	if p.done > 0 && p.done < p.total {
+		eta := elapsed / time.Duration(p.done) * time.Duration(p.total-p.done)
+		line += ", ETA " + formatETA(eta)
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[K%s", line)
+	p.lastDraw = time.Now()
+	p.drawn = true
+}
+
+// clear erases the progress line; the caller holds p.mu.
+func (p *progress) clear() {
+	if p.drawn {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+// finish leaves the final progress line on screen and restores direct INFO output.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.draw()
+	fmt.Fprintln(os.Stderr)
+	infoOut = p.prevInfo
+}
+
+// formatETA rounds an estimate to what is worth showing: seconds under a minute, minutes otherwise.
+func formatETA(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return fmt.Sprintf("%dm", int(d.Round(time.Minute)/time.Minute))
+}
+
+// formatRate formats how many files per second were finished in elapsed.
+func formatRate(files int, elapsed time.Duration) string {
+	if elapsed <= 0 {
+		return "- files/s"
+	}
+	return fmt.Sprintf("%.1f files/s", float64(files)/elapsed.Seconds())
+}
+
+// progressWriter writes INFO lines above the progress line, redrawing it afterwards.
+type progressWriter struct {
+	p *progress
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	w.p.mu.Lock()
+	defer w.p.mu.Unlock()
+	w.p.clear()
+	n, err := w.p.prevInfo.Write(b)
+	w.p.draw()
+	return n, err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// qualityScaleBaseMP is the source size, in megapixels, at or below which the ceiling quality is used.
+	qualityScaleBaseMP = 12
+	// qualityScaleStep is how much quality is removed for every megapixel above qualityScaleBaseMP.
+	qualityScaleStep = 1
+)
+
+// Parsed -quality-scale bounds; both are zero when the policy is disabled.
+var qualityFloor, qualityCeil int
+
+// parseQualityScale parses a -quality-scale value of the form FLOOR-CEILING, e.g. "70-92".
+func parseQualityScale(value string) (floor, ceil int, err error) {
+	lo, hi, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid -quality-scale %q, expected FLOOR-CEILING such as 70-92", value)
+	}
+	if floor, err = strconv.Atoi(strings.TrimSpace(lo)); err != nil {
+		return 0, 0, fmt.Errorf("invalid -quality-scale floor %q: %v", lo, err)
+	}
+	if ceil, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil {
+		return 0, 0, fmt.Errorf("invalid -quality-scale ceiling %q: %v", hi, err)
+	}
+	if floor < 1 || ceil > 100 || floor > ceil {
+		return 0, 0, fmt.Errorf("invalid -quality-scale %q, bounds must satisfy 1 <= FLOOR <= CEILING <= 100", value)
+	}
+	return floor, ceil, nil
+}
+
+// scaledQuality returns the quality to use for a source of the given size. Sources up to qualityScaleBaseMP
+// get the ceiling, and each additional megapixel lowers the quality by qualityScaleStep down to the floor.
+func scaledQuality(megapixels float64, floor, ceil int) int {
+	q := ceil
+	if megapixels > qualityScaleBaseMP {
+		q = ceil - int((megapixels-qualityScaleBaseMP)*qualityScaleStep)
+	}
+	if q < floor {
+		q = floor
+	}
+	return q
+}
+
+// imageDimensions reads the width and height of the primary image in inFile using ImageMagick's identify.
+func imageDimensions(inFile string) (width, height int, err error) {
+	output, err := magickCommand("identify", "-format", "%w %h", inFile+"[0]").Output()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to identify %s: %v", inFile, err)
+	}
+	if _, err := fmt.Sscanf(string(output), "%d %d", &width, &height); err != nil {
+		return 0, 0, fmt.Errorf("failed to parse dimensions of %s from %q: %v", inFile, output, err)
+	}
+	return width, height, nil
+}
+
+// fileQuality works out the -quality-scale quality for inFile from its pixel count.
+func fileQuality(inFile string) (int, error) {
+	width, height, err := imageDimensions(inFile)
+	if err != nil {
+		return 0, err
+	}
+	return scaledQuality(float64(width)*float64(height)/1e6, qualityFloor, qualityCeil), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestScaledQuality(t *testing.T) {
+	tests := []struct {
+		megapixels float64
+		floor      int
+		ceil       int
+		want       int
+	}{
+		{1, 70, 92, 92},
+		{12, 70, 92, 92},
+		{12.5, 70, 92, 92},
+		{13, 70, 92, 91},
+		{24, 70, 92, 80},
+		{48, 70, 92, 70},
+		{200, 70, 92, 70},
+		{50, 90, 90, 90},
+	}
+	for _, tt := range tests {
+		if got := scaledQuality(tt.megapixels, tt.floor, tt.ceil); got != tt.want {
+			t.Errorf("scaledQuality(%v, %d, %d) = %d, want %d", tt.megapixels, tt.floor, tt.ceil, got, tt.want)
+		}
+	}
+}
+
+func TestParseQualityScale(t *testing.T) {
+	tests := []struct {
+		value     string
+		floor     int
+		ceil      int
+		wantError bool
+	}{
+		{value: "70-92", floor: 70, ceil: 92},
+		{value: " 80 - 80 ", floor: 80, ceil: 80},
+		{value: "1-100", floor: 1, ceil: 100},
+		{value: "92", wantError: true},
+		{value: "92-70", wantError: true},
+		{value: "0-50", wantError: true},
+		{value: "50-101", wantError: true},
+		{value: "a-90", wantError: true},
+	}
+	for _, tt := range tests {
+		floor, ceil, err := parseQualityScale(tt.value)
+		if tt.wantError {
+			if err == nil {
+				t.Errorf("parseQualityScale(%q) = %d, %d, want an error", tt.value, floor, ceil)
+			}
+			continue
+		}
+		if err != nil || floor != tt.floor || ceil != tt.ceil {
+			t.Errorf("parseQualityScale(%q) = %d, %d, %v, want %d, %d", tt.value, floor, ceil, err, tt.floor, tt.ceil)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// registryEntry records which source an output was written from.
+type registryEntry struct {
+	Source string `json:"source"`
+	SHA256 string `json:"sha256"`
+}
+
+// outputRegistry maps output paths to the source that produced them, shared across runs through the
+// -output-registry file so conflicting outputs from separate invocations can be detected.
+var outputRegistry struct {
+	sync.Mutex
+	entries map[string]registryEntry
+	// written are the outputs this run registered, which saveOutputRegistry merges into the file.
+	written map[string]bool
+}
+
+// registryLockWait is how long saveOutputRegistry waits for another run to release the registry lock,
+// and registryLockStale how old a lock file must be to be taken as left behind by a crashed run.
+const (
+	registryLockWait  = 30 * time.Second
+	registryLockStale = 2 * time.Minute
+)
+
+// loadOutputRegistry reads the registry file; a missing file starts an empty registry.
+func loadOutputRegistry(path string) error {
+	entries, err := readOutputRegistry(path)
+	if err != nil {
+		return err
+	}
+	outputRegistry.entries = entries
+	outputRegistry.written = make(map[string]bool)
+	return nil
+}
+
+// readOutputRegistry returns the entries of the registry file, or none when it does not exist.
+func readOutputRegistry(path string) (map[string]registryEntry, error) {
+	entries := make(map[string]registryEntry)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read output registry: %v", err)
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse output registry %s: %v", path, err)
+	}
+	return entries, nil
+}
+
+// saveOutputRegistry merges the outputs this run registered into the registry file and replaces it
+// atomically. The file is re-read under a lock, so runs sharing a registry keep each other's entries
+// instead of the last one to finish overwriting the rest.
+func saveOutputRegistry(path string) error {
+	unlock, err := lockOutputRegistry(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	entries, err := readOutputRegistry(path)
+	if err != nil {
+		return err
+	}
+	outputRegistry.Lock()
+	for out := range outputRegistry.written {
+		entries[out] = outputRegistry.entries[out]
+	}
+	outputRegistry.Unlock()
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode output registry: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write output registry: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write output registry: %v", err)
+	}
+	return nil
+}
+
+// lockOutputRegistry takes the registry's lock file, <path>.lock, waiting up to registryLockWait for
+// another run to finish saving, and returns the function that releases it. A lock older than
+// registryLockStale is removed, since no save holds it that long.
+func lockOutputRegistry(path string) (func(), error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(registryLockWait)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("failed to lock output registry: %v", err)
+		}
+		if info, err := os.Stat(lock); err == nil && time.Since(info.ModTime()) > registryLockStale {
+			fmt.Fprintf(os.Stdout, "WARN: Removing stale output registry lock %s.\n", lock)
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("failed to lock output registry: %s is held by another run", lock)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// hashFile returns the hex SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// checkOutputConflict warns when outFile still exists and is registered as written from different source content, and
+// returns the hash of inFile for registering the new conversion.
+func checkOutputConflict(inFile, outFile string) (string, error) {
+	sum, err := hashFile(inFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %v", inFile, err)
+	}
+	outputRegistry.Lock()
+	prev, ok := outputRegistry.entries[targetName(outFile)]
+	outputRegistry.Unlock()
+	if !ok || prev.SHA256 == sum {
+		return sum, nil
+	}
+	if _, err := statOutput(outFile); err == nil {
+		fmt.Fprintf(os.Stdout, "WARN: Output %s was previously written from a different source (%s), it will be replaced by %s.\n", outFile, prev.Source, inFile)
+	}
+	return sum, nil
+}
+
+// registerOutput records that outFile was written from inFile with the given content hash.
+func registerOutput(inFile, outFile, sum string) {
+	outputRegistry.Lock()
+	defer outputRegistry.Unlock()
+	outputRegistry.entries[targetName(outFile)] = registryEntry{Source: sourceName(inFile), SHA256: sum}
+	outputRegistry.written[targetName(outFile)] = true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// resetOutputRegistry drops the registry loaded by a test once it ends, as runs without
+// -output-registry have none.
+func resetOutputRegistry(t *testing.T) {
+	t.Cleanup(func() { outputRegistry.entries, outputRegistry.written = nil, nil })
+}
+
+// captureStdout returns what fn writes to os.Stdout, where warnings go.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestCheckOutputConflict(t *testing.T) {
+	dir := t.TempDir()
+	registry := filepath.Join(dir, "registry.json")
+	resetOutputRegistry(t)
+	first := writeFile(t, dir, "a/IMG_1.heic", "first")
+	second := writeFile(t, dir, "b/IMG_1.heic", "second")
+	same := writeFile(t, dir, "c/IMG_1.heic", "first")
+	out := writeFile(t, dir, "out/IMG_1.jpg", "encoded")
+
+	// An earlier run wrote out from first.
+	if err := loadOutputRegistry(registry); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := checkOutputConflict(first, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerOutput(first, out, sum)
+	if err := saveOutputRegistry(registry); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		remove bool
+		warns  bool
+	}{
+		{name: "same content from another path", source: same},
+		{name: "different content", source: second, warns: true},
+		{name: "different content, output gone", source: second, remove: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := loadOutputRegistry(registry); err != nil {
+				t.Fatal(err)
+			}
+			if tt.remove {
+				os.Remove(out)
+			}
+			var err error
+			warning := captureStdout(t, func() { _, err = checkOutputConflict(tt.source, out) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(warning, "previously written from a different source ("+first+")"); got != tt.warns {
+				t.Errorf("checkOutputConflict() printed %q, want a warning = %v", warning, tt.warns)
+			}
+		})
+	}
+}
+
+func TestSaveOutputRegistryMerges(t *testing.T) {
+	dir := t.TempDir()
+	registry := filepath.Join(dir, "registry.json")
+	resetOutputRegistry(t)
+	if err := loadOutputRegistry(registry); err != nil {
+		t.Fatal(err)
+	}
+	registerOutput("/p/a.heic", "/out/a.jpg", "aaa")
+
+	// Another run saves its own output while this one is still converting.
+	other := outputRegistry.entries
+	outputRegistry.entries = map[string]registryEntry{"/out/b.jpg": {Source: "/p/b.heic", SHA256: "bbb"}}
+	written := outputRegistry.written
+	outputRegistry.written = map[string]bool{"/out/b.jpg": true}
+	if err := saveOutputRegistry(registry); err != nil {
+		t.Fatal(err)
+	}
+	outputRegistry.entries, outputRegistry.written = other, written
+
+	// A lock left behind by a crashed run does not block the save.
+	lock := registry + ".lock"
+	writeFile(t, dir, filepath.Base(lock), "")
+	old := time.Now().Add(-2 * registryLockStale)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	var saveErr error
+	if warning := captureStdout(t, func() { saveErr = saveOutputRegistry(registry) }); saveErr != nil || !strings.Contains(warning, "stale") {
+		t.Fatalf("saveOutputRegistry() = %v with a stale lock, printed %q", saveErr, warning)
+	}
+
+	entries, err := readOutputRegistry(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries["/out/a.jpg"].SHA256 != "aaa" || entries["/out/b.jpg"].SHA256 != "bbb" {
+		t.Errorf("registry = %+v, want the entries of both runs", entries)
+	}
+	if _, err := os.Stat(lock); err == nil {
+		t.Error("the save left its lock file behind")
+	}
+}
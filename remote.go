@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remoteSchemes are the URL schemes accepted for -input and -outdir.
+var remoteSchemes = []string{"s3://", "http://", "https://", "webdav://", "webdav+http://"}
+
+// remoteStore is an object store or file server that -input can read from and -outdir upload to. Keys
+// are slash-separated paths within it.
+type remoteStore interface {
+	// List returns the objects under prefix, with keys relative to it, including nested ones when
+	// recursive is set.
+	List(ctx context.Context, prefix string, recursive bool) ([]remoteObject, error)
+	// Get writes the object at key to w.
+	Get(ctx context.Context, key string, w io.Writer) error
+	// Put uploads size bytes from r to key.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+}
+
+// remoteObject is an object in a listing. It is an fs.FileInfo so outputs already uploaded to a remote
+// -outdir can stand in for local ones when deciding what to skip.
+type remoteObject struct {
+	key     string
+	size    int64
+	modTime time.Time
+}
+
+func (o remoteObject) Name() string       { return path.Base(o.key) }
+func (o remoteObject) Size() int64        { return o.size }
+func (o remoteObject) Mode() fs.FileMode  { return 0o644 }
+func (o remoteObject) ModTime() time.Time { return o.modTime }
+func (o remoteObject) IsDir() bool        { return false }
+func (o remoteObject) Sys() any           { return nil }
+
+// remoteTimeout bounds every request to a remote store, transfer included, so a stalled server fails
+// the run instead of hanging it.
+const remoteTimeout = 10 * time.Minute
+
+// remoteClient is the HTTP client of the remote stores. Connecting and the response headers must come
+// quickly; the body may take up to remoteTimeout.
+var remoteClient = &http.Client{
+	Timeout: remoteTimeout,
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   16,
+	},
+}
+
+// remote holds the spool behind remote -input and -outdir URLs.
+var remote struct {
+	// spool holds downloaded sources and, for a remote -outdir, the outputs waiting for upload.
+	spool string
+	// outdir is the -outdir URL, with *outDir pointed at a staging directory in the spool meanwhile.
+	outdir string
+	store  remoteStore
+	prefix string
+	// sources maps every downloaded source to its URL, which results, manifests, the state file and the
+	// registry record instead of the spool path, so they match across runs.
+	sources map[string]string
+	// target is the -outdir URL without credentials, standing in for the staging directory in records.
+	target string
+	// existing holds the objects already under the -outdir prefix by key relative to it, so
+	// -skip-existing, -resume and -rename-on-conflict see earlier uploads.
+	existing map[string]remoteObject
+}
+
+// isRemote reports whether an -input or -outdir value is a URL rather than a local path.
+func isRemote(value string) bool {
+	for _, scheme := range remoteSchemes {
+		if strings.HasPrefix(value, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// openRemote returns the store a URL points into and the key it names there.
+func openRemote(raw string) (remoteStore, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid URL %q: %v", raw, err)
+	}
+	switch u.Scheme {
+	case "s3":
+		if u.Host == "" {
+			return nil, "", fmt.Errorf("invalid URL %q, expected s3://bucket/prefix", raw)
+		}
+		return newS3Store(u.Host), strings.TrimPrefix(u.Path, "/"), nil
+	case "webdav", "webdav+http":
+		return newWebDAVStore(u, u.Scheme == "webdav"), u.Path, nil
+	case "http", "https":
+		return httpStore{u: u}, "", nil
+	}
+	return nil, "", fmt.Errorf("unsupported URL scheme in %q", raw)
+}
+
+// setupRemote downloads every remote -input into a spool directory and points the input at its local
+// copy, and for a remote -outdir points *outDir at a staging directory that finishRemote uploads. A URL
+// whose path has a source extension is one file; any other is listed like a directory, honoring
+// -recursive. It runs after the output type is known, so the right source extensions are downloaded.
+// Under -dry-run sources are listed but not downloaded: empty files stand in for them, so the dry run can
+// report what each would be converted to.
+func setupRemote() error {
+	remoteInput := false
+	for _, in := range *inputs {
+		remoteInput = remoteInput || isRemote(in)
+	}
+	remoteOutput := isRemote(*outDir)
+	if !remoteInput && !remoteOutput {
+		return nil
+	}
+	if remoteInput && *outDir == "" {
+		return errors.New("a remote -input needs -outdir, local or remote, as the downloaded sources are removed after the run")
+	}
+	if *watch || *diffMode {
+		return errors.New("-watch and -diff cannot be used with remote -input or -outdir")
+	}
+	if remoteInput && (*deleteSource || *deleteOriginals || *moveSources != "") {
+		return errors.New("-delete-source and -move-originals cannot be used with a remote -input, they would only dispose of the downloaded copy")
+	}
+	if remoteInput && *dryRun && (*sniff || *dedupe) {
+		return errors.New("-sniff and -dedupe cannot be used with -dry-run and a remote -input, as the sources are not downloaded")
+	}
+
+	var store remoteStore
+	var prefix string
+	if remoteOutput {
+		var err error
+		if store, prefix, err = openRemote(*outDir); err != nil {
+			return err
+		}
+		if _, ok := store.(httpStore); ok {
+			return errors.New("a remote -outdir must be an s3:// or webdav:// URL, plain HTTP cannot be uploaded to")
+		}
+	}
+
+	spool, err := os.MkdirTemp("", "convert_heic_remote_")
+	if err != nil {
+		return withExitCode(exitEnvironment, fmt.Errorf("failed to create spool directory: %v", err))
+	}
+	remote.spool = spool
+	remote.sources = make(map[string]string)
+	ctx := interruptContext()
+	for i, in := range *inputs {
+		if !isRemote(in) {
+			continue
+		}
+		local, err := downloadRemote(ctx, in, filepath.Join(spool, "in", strconv.Itoa(i)))
+		if ctx.Err() != nil {
+			return withExitCode(exitInterrupted, errInterrupted)
+		}
+		if err != nil {
+			return withExitCode(exitEnvironment, err)
+		}
+		(*inputs)[i] = local
+	}
+	if len(*inputs) == 1 {
+		*inPath = (*inputs)[0]
+	}
+	if remoteOutput {
+		remote.outdir, remote.store, remote.prefix = *outDir, store, strings.Trim(prefix, "/")
+		remote.target = strings.TrimSuffix(plainURL(*outDir), "/")
+		listPrefix := remote.prefix
+		if listPrefix != "" {
+			listPrefix += "/"
+		}
+		existing, err := store.List(ctx, listPrefix, true)
+		if ctx.Err() != nil {
+			return withExitCode(exitInterrupted, errInterrupted)
+		}
+		if err != nil {
+			// A prefix or collection that doesn't exist yet fails to list on some servers; it has no outputs either.
+			fmt.Fprintf(os.Stdout, "WARN: Could not list %s, treating it as empty: %v\n", redactURL(*outDir), err)
+		}
+		remote.existing = make(map[string]remoteObject, len(existing))
+		for _, obj := range existing {
+			remote.existing[obj.key] = obj
+		}
+		*outDir = filepath.Join(spool, "out")
+	}
+	return nil
+}
+
+// plainURL returns raw without user info, for recording as a source or target.
+func plainURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
+
+// sourceName returns the name results and state record for a source: its URL when it was downloaded
+// from a remote -input, its path otherwise.
+func sourceName(file string) string {
+	if u, ok := remote.sources[file]; ok {
+		return u
+	}
+	return file
+}
+
+// targetName returns the name results and state record for an output: its URL when it is staged for a
+// remote -outdir, its path otherwise.
+func targetName(out string) string {
+	if key, ok := stagedKey(out); ok {
+		return remote.target + "/" + key
+	}
+	return out
+}
+
+// stagedKey returns the key below the -outdir prefix of a path in the staging directory of a remote -outdir.
+func stagedKey(out string) (string, bool) {
+	if remote.store == nil || out == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(*outDir, out)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// statOutput is os.Stat for outputs: for a remote -outdir, an output uploaded by an earlier run counts
+// as existing.
+func statOutput(out string) (os.FileInfo, error) {
+	info, err := os.Stat(out)
+	if err != nil {
+		if obj, ok := uploadedOutput(out); ok {
+			return obj, nil
+		}
+	}
+	return info, err
+}
+
+// uploadedOutput returns the object already in a remote -outdir for a path in its staging directory.
+func uploadedOutput(out string) (remoteObject, bool) {
+	key, ok := stagedKey(out)
+	if !ok {
+		return remoteObject{}, false
+	}
+	obj, ok := remote.existing[key]
+	return obj, ok
+}
+
+// recordedOutputExists reports whether an output recorded by targetName, a path or a remote
+// -outdir URL, still exists.
+func recordedOutputExists(out string) bool {
+	if remote.target != "" {
+		if key, ok := strings.CutPrefix(out, remote.target+"/"); ok {
+			_, found := remote.existing[key]
+			return found
+		}
+	}
+	_, err := os.Stat(out)
+	return err == nil
+}
+
+// downloadRemote copies the sources a URL names into dir, returning the local file for a single source
+// or dir itself for a listing.
+func downloadRemote(ctx context.Context, raw, dir string) (string, error) {
+	store, key, err := openRemote(raw)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := store.(httpStore); ok || isHeicFile(key) {
+		u, _ := url.Parse(raw)
+		name, err := localKey(path.Base(u.Path))
+		if err != nil {
+			return "", fmt.Errorf("failed to download %s: %v", redactURL(raw), err)
+		}
+		local := filepath.Join(dir, name)
+		if err := fetchRemote(ctx, store, remoteObject{key: key}, local); err != nil {
+			return "", fmt.Errorf("failed to download %s: %v", redactURL(raw), err)
+		}
+		remote.sources[local] = plainURL(raw)
+		if !*dryRun {
+			fmt.Fprintf(infoOut, "INFO: Downloaded %s.\n", redactURL(raw))
+		}
+		return local, nil
+	}
+
+	if key = strings.TrimPrefix(key, "/"); key != "" && !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	listed, err := store.List(ctx, key, *recursive)
+	if err != nil {
+		return "", fmt.Errorf("failed to list %s: %v", redactURL(raw), err)
+	}
+	u, _ := url.Parse(plainURL(raw))
+	var objects []remoteObject
+	for _, obj := range listed {
+		// Without -sniff only names mark sources, so other objects are not worth downloading.
+		if !*sniff && !isHeicFile(obj.key) {
+			continue
+		}
+		name, err := localKey(obj.key)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: Skipping remote file: %v.\n", err)
+			continue
+		}
+		objURL := *u
+		objURL.Path = "/" + key + obj.key
+		remote.sources[filepath.Join(dir, name)] = objURL.String()
+		objects = append(objects, obj)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create spool directory: %v", err)
+	}
+	err = forEachParallel(objects, func(obj remoteObject) error {
+		name, _ := localKey(obj.key)
+		if err := fetchRemote(ctx, store, remoteObject{key: key + obj.key, modTime: obj.modTime}, filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to download %s: %v", obj.key, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if *dryRun {
+		fmt.Fprintf(infoOut, "INFO: Dry run: listed %d files in %s without downloading them.\n", len(objects), redactURL(raw))
+	} else {
+		fmt.Fprintf(infoOut, "INFO: Downloaded %d files from %s.\n", len(objects), redactURL(raw))
+	}
+	return dir, nil
+}
+
+// localKey returns the local relative path for a key from a listing or URL, refusing keys that are
+// absolute or have ".." elements, which a hostile bucket or server could use to write outside the spool.
+func localKey(k string) (string, error) {
+	name := filepath.FromSlash(k)
+	elems := strings.FieldsFunc(k, func(r rune) bool { return r == '/' || r == '\\' })
+	if slices.Contains(elems, "..") || name == "." || !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%q is not a relative path, it could be written outside the spool directory", k)
+	}
+	return name, nil
+}
+
+// fetchRemote downloads obj from store into the local file path, or under -dry-run creates it empty.
+// The file takes the object's modification time, when the listing had one, so -skip-existing mtime
+// compares it with the output's rather than with the time of the download.
+func fetchRemote(ctx context.Context, store remoteStore, obj remoteObject, local string) error {
+	if err := os.MkdirAll(filepath.Dir(local), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	if !*dryRun {
+		err = store.Get(ctx, obj.key, f)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && !obj.modTime.IsZero() {
+		err = os.Chtimes(local, obj.modTime, obj.modTime)
+	}
+	return err
+}
+
+// finishRemote uploads everything written to the staging directory of a remote -outdir, keeping its
+// layout under the URL's prefix.
+func finishRemote() error {
+	if remote.store == nil || *dryRun {
+		return nil
+	}
+	var files []string
+	err := filepath.WalkDir(*outDir, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == *outDir {
+			return filepath.SkipDir
+		}
+		if err == nil && !d.IsDir() {
+			files = append(files, p)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list outputs to upload: %v", err)
+	}
+	ctx := interruptContext()
+	err = forEachParallel(files, func(file string) error {
+		rel, err := filepath.Rel(*outDir, file)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if remote.prefix != "" {
+			key = remote.prefix + "/" + key
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if err := remote.store.Put(ctx, key, f, info.Size()); err != nil {
+			return fmt.Errorf("failed to upload %s: %v", rel, err)
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return errInterrupted
+	}
+	if err != nil {
+		return withExitCode(exitEnvironment, err)
+	}
+	fmt.Fprintf(infoOut, "INFO: Uploaded %d files to %s.\n", len(files), redactURL(remote.outdir))
+	return nil
+}
+
+// cleanupRemote removes the spool directory, if any.
+func cleanupRemote() {
+	if remote.spool != "" {
+		os.RemoveAll(remote.spool)
+		remote.spool = ""
+	}
+}
+
+// forEachParallel runs fn on every item across -workers goroutines and returns the first error.
+func forEachParallel[T any](items []T, fn func(T) error) error {
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	itemCh := make(chan T)
+	for i := 0; i < max(*workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range itemCh {
+				if err := fn(item); err != nil {
+					once.Do(func() { firstErr = err })
+				}
+			}
+		}()
+	}
+	for _, item := range items {
+		itemCh <- item
+	}
+	close(itemCh)
+	wg.Wait()
+	return firstErr
+}
+
+// redactURL returns raw with any password hidden, for messages.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// httpStore reads the single file a plain HTTP(S) URL points at. It cannot list or upload.
+type httpStore struct {
+	u *url.URL
+}
+
+// List fails, as plain HTTP has no directory listing.
+func (httpStore) List(ctx context.Context, prefix string, recursive bool) ([]remoteObject, error) {
+	return nil, errors.New("plain HTTP URLs cannot be listed, name a single file")
+}
+
+// Get downloads the URL, ignoring key.
+func (s httpStore) Get(ctx context.Context, key string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("GET %s: %s", s.u.Redacted(), resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Put fails, as there is no standard way to upload over plain HTTP.
+func (httpStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	return errors.New("plain HTTP URLs cannot be uploaded to")
+}
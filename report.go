@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// summaryRecord is the JSON shape of the run summary that ends -json output and -report files.
+type summaryRecord struct {
+	Type        string         `json:"type"`
+	Converted   int            `json:"converted"`
+	Skipped     int            `json:"skipped"`
+	Failed      int            `json:"failed"`
+	Statuses    map[string]int `json:"statuses"`
+	DurationMS  int64          `json:"duration_ms"`
+	SourceBytes int64          `json:"source_bytes"`
+	TargetBytes int64          `json:"target_bytes"`
+	Magick      string         `json:"magick_version,omitempty"`
+}
+
+// jsonOut is the real standard output under -json, which then carries only JSON records while
+// os.Stdout is pointed at stderr for everything human-readable. It is nil without -json.
+var jsonOut *json.Encoder
+
+// report collects what -json and -report need across workers.
+var report struct {
+	sync.Mutex
+	records     []resultRecord
+	sourceBytes int64
+	targetBytes int64
+}
+
+// enableJSONOutput moves human-readable output to stderr so standard output holds only JSON records.
+func enableJSONOutput() {
+	jsonOut = json.NewEncoder(os.Stdout)
+	os.Stdout = os.Stderr
+	if !*quiet {
+		infoOut = os.Stderr
+	}
+}
+
+// writeJSON writes one record to standard output under -json. It is safe for concurrent use.
+func writeJSON(v any) error {
+	if jsonOut == nil {
+		return nil
+	}
+	report.Lock()
+	defer report.Unlock()
+	return jsonOut.Encode(v)
+}
+
+// reportResult emits res under -json and keeps it for -report.
+func reportResult(res fileResult) error {
+	if jsonOut == nil && *reportPath == "" {
+		return nil
+	}
+	rec := res.record()
+	report.Lock()
+	defer report.Unlock()
+	report.sourceBytes += rec.SourceBytes
+	report.targetBytes += rec.TargetBytes
+	if *reportPath != "" {
+		report.records = append(report.records, rec)
+	}
+	if jsonOut != nil {
+		if err := jsonOut.Encode(rec); err != nil {
+			return fmt.Errorf("failed to write JSON record for %s: %v", res.Source, err)
+		}
+	}
+	return nil
+}
+
+// buildSummaryRecord returns the run summary from the per-status tally.
+func buildSummaryRecord(elapsed time.Duration) summaryRecord {
+	statusCounts.Lock()
+	statuses := make(map[string]int, len(statusCounts.counts))
+	for status, n := range statusCounts.counts {
+		statuses[status] = n
+	}
+	statusCounts.Unlock()
+	report.Lock()
+	defer report.Unlock()
+	return summaryRecord{
+		Type:        "summary",
+		Converted:   statuses[statusConverted],
+		Skipped:     statuses[statusExists] + statuses[statusUpToDate],
+		Failed:      statuses[statusFailed],
+		Statuses:    statuses,
+		DurationMS:  elapsed.Milliseconds(),
+		SourceBytes: report.sourceBytes,
+		TargetBytes: report.targetBytes,
+		Magick:      magickVersion,
+	}
+}
+
+// finishReport writes the summary record under -json and the -report file with every result.
+func finishReport(elapsed time.Duration) error {
+	if jsonOut == nil && *reportPath == "" {
+		return nil
+	}
+	sum := buildSummaryRecord(elapsed)
+	if err := writeJSON(sum); err != nil {
+		return fmt.Errorf("failed to write JSON summary: %v", err)
+	}
+	if *reportPath == "" {
+		return nil
+	}
+	report.Lock()
+	files := report.records
+	report.Unlock()
+	if files == nil {
+		files = []resultRecord{}
+	}
+	data, err := json.MarshalIndent(struct {
+		Files   []resultRecord `json:"files"`
+		Summary summaryRecord  `json:"summary"`
+	}{files, sum}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %v", err)
+	}
+	if err := os.WriteFile(*reportPath, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write report %s: %v", *reportPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// parseResize checks a -resize value of the form WxH, where either side may be left out to scale by the
+// other, e.g. "1920x1080", "1920x" or "x1080".
+func parseResize(value string) error {
+	w, h, ok := strings.Cut(value, "x")
+	if !ok || (w == "" && h == "") {
+		return fmt.Errorf("invalid -resize %q, expected WxH such as 1920x1080", value)
+	}
+	for _, side := range []string{w, h} {
+		if side == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(side); err != nil || n < 1 {
+			return fmt.Errorf("invalid -resize %q, width and height must be positive integers", value)
+		}
+	}
+	return nil
+}
+
+// renditionSizes holds the longest-edge sizes parsed from -sizes, excluding full, in the order given.
+var renditionSizes []int
+
+// validateSizes parses -sizes into renditionSizes. The list must name full, the output every other
+// rendition is written beside; the others are positive pixel sizes for the longer side.
+func validateSizes() error {
+	if *sizesList == "" {
+		return nil
+	}
+	full := false
+	seen := make(map[int]bool)
+	for _, item := range strings.Split(*sizesList, ",") {
+		item = strings.TrimSpace(item)
+		if item == "full" {
+			full = true
+			continue
+		}
+		n, err := strconv.Atoi(item)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid -sizes entry %q, expected full or a positive number of pixels", item)
+		}
+		if seen[n] {
+			return fmt.Errorf("-sizes lists %d more than once", n)
+		}
+		seen[n] = true
+		renditionSizes = append(renditionSizes, n)
+	}
+	if !full {
+		return errors.New("-sizes must include full; use -max-dimension for a single smaller size")
+	}
+	switch {
+	case *allFrames:
+		return errors.New("-sizes cannot be combined with -all-frames")
+	case *montageMode:
+		return errors.New("-sizes cannot be combined with -montage")
+	case *cacheDir != "":
+		return errors.New("-sizes cannot be combined with -cache-dir")
+	case *outputFile == "-":
+		return errors.New("-sizes cannot be combined with -output-file -")
+	}
+	return nil
+}
+
+// renditionArgs returns the ImageMagick options that write the -sizes renditions of outFile. Each is
+// scaled from a clone of the full output, so the source is decoded once and every rendition shares its
+// orientation, color and quality settings. Renditions only ever shrink.
+func renditionArgs(outFile string) []string {
+	var args []string
+	for _, n := range renditionSizes {
+		size := fmt.Sprintf("%dx%d>", n, n)
+		args = append(args, "(", "+clone", "-resize", size, "-write", renditionFilename(outFile, n), "+delete", ")")
+	}
+	return args
+}
+
+// renditionFilename returns the <base>_<N><ext> path of the size N rendition of outFile.
+func renditionFilename(outFile string, n int) string {
+	ext := filepath.Ext(outFile)
+	return strings.TrimSuffix(outFile, ext) + "_" + strconv.Itoa(n) + ext
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Statuses reported for a processed file.
+const (
+	statusConverted = "converted"
+	statusDiscarded = "discarded"
+	statusUpToDate  = "up-to-date"
+	statusExists    = "exists"
+	statusDumped    = "dumped"
+	statusDryRun    = "would-convert"
+	statusMetadata  = "metadata"
+	statusMissing   = "missing"
+	statusDuplicate = "duplicate"
+	statusFailed    = "failed"
+)
+
+// fileResult describes the outcome of processing one source file.
+type fileResult struct {
+	Source     string
+	Target     string
+	Status     string
+	Duration   time.Duration
+	DecodeTime time.Duration
+	EncodeTime time.Duration
+	// Detail is extra human-readable context for the conversion, such as the quality chosen for -target-size.
+	Detail     string
+	SourceSize int64
+	TargetSize int64
+	// Sidecar is the Live Photo video placed next to the output with -live-photos.
+	Sidecar string
+	// DuplicateOf is the source whose output stands in for this one when -dedupe skipped it.
+	DuplicateOf string
+	// Stderr is the converter's error output when the conversion command failed.
+	Stderr string
+	Err    error
+	// transient is set for failures of the conversion command itself, which -retries may retry, as
+	// opposed to unreadable sources and setup errors, which fail the same way every time.
+	transient bool
+}
+
+// fail marks the result as failed with err.
+func (r fileResult) fail(err error) fileResult {
+	r.Status = statusFailed
+	r.Err = err
+	return r
+}
+
+// statusCounts tallies processed files by status across all workers.
+var statusCounts struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+// countResult adds res to the per-status tally.
+func countResult(res fileResult) {
+	statusCounts.Lock()
+	defer statusCounts.Unlock()
+	if statusCounts.counts == nil {
+		statusCounts.counts = make(map[string]int)
+	}
+	statusCounts.counts[res.Status]++
+}
+
+// statusCount returns how many files finished with the given status.
+func statusCount(status string) int {
+	statusCounts.Lock()
+	defer statusCounts.Unlock()
+	return statusCounts.counts[status]
+}
+
+// summaryExtras are the statuses listed in the run summary only when some files ended with them.
+var summaryExtras = []struct{ status, label string }{
+	{statusDiscarded, "discarded"},
+	{statusMissing, "missing"},
+	{statusDuplicate, "duplicates"},
+	{statusDumped, "dumped"},
+	{statusMetadata, "metadata only"},
+	{statusDryRun, "would convert"},
+}
+
+// printSummary prints how many files were converted, skipped and failed, and how long the run took.
+func printSummary(elapsed time.Duration) {
+	statusCounts.Lock()
+	counts := statusCounts.counts
+	parts := []string{
+		fmt.Sprintf("%d converted", counts[statusConverted]),
+		fmt.Sprintf("%d skipped", counts[statusExists]+counts[statusUpToDate]),
+		fmt.Sprintf("%d failed", counts[statusFailed]),
+	}
+	for _, extra := range summaryExtras {
+		if n := counts[extra.status]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, extra.label))
+		}
+	}
+	converted := counts[statusConverted]
+	statusCounts.Unlock()
+	rate := ""
+	if converted > 0 {
+		rate = " (" + formatRate(converted, elapsed) + ")"
+	}
+	fmt.Fprintf(os.Stdout, "INFO: Summary: %s in %s%s.\n", strings.Join(parts, ", "), elapsed.Round(time.Millisecond), rate)
+}
+
+// checkSuccessRate fails when the share of attempted conversions that did not fail is below
+// -min-success-rate. Files skipped as up-to-date, existing or duplicate, dry-run and dumped commands,
+// metadata-only files and missing inputs are not counted, as no conversion was attempted for them.
+func checkSuccessRate() error {
+	if *minSuccessRate <= 0 {
+		return nil
+	}
+	statusCounts.Lock()
+	failed := statusCounts.counts[statusFailed]
+	attempted := statusCounts.counts[statusConverted] + statusCounts.counts[statusDiscarded] + failed
+	statusCounts.Unlock()
+
+	if attempted == 0 {
+		fmt.Fprintln(infoOut, "INFO: No conversions were attempted, so -min-success-rate was not checked.")
+		return nil
+	}
+	rate := float64(attempted-failed) / float64(attempted)
+	if rate < *minSuccessRate {
+		return fmt.Errorf("success rate %.1f%% (%d of %d conversions) is below -min-success-rate %.1f%%", 100*rate, attempted-failed, attempted, 100*(*minSuccessRate))
+	}
+	return nil
+}
+
+// resultRecord is the JSON shape of a fileResult.
+type resultRecord struct {
+	Type        string `json:"type"`
+	Source      string `json:"source"`
+	Target      string `json:"target,omitempty"`
+	Status      string `json:"status"`
+	DurationMS  int64  `json:"duration_ms"`
+	DecodeMS    int64  `json:"decode_ms,omitempty"`
+	EncodeMS    int64  `json:"encode_ms,omitempty"`
+	SourceBytes int64  `json:"source_bytes"`
+	TargetBytes int64  `json:"target_bytes"`
+	Sidecar     string `json:"sidecar,omitempty"`
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Magick      string `json:"magick_version,omitempty"`
+}
+
+// record converts the result to its JSON shape.
+func (r fileResult) record() resultRecord {
+	rec := resultRecord{
+		Type:        "file",
+		Source:      sourceName(r.Source),
+		Target:      targetName(r.Target),
+		Status:      r.Status,
+		DurationMS:  r.Duration.Milliseconds(),
+		DecodeMS:    r.DecodeTime.Milliseconds(),
+		EncodeMS:    r.EncodeTime.Milliseconds(),
+		SourceBytes: r.SourceSize,
+		TargetBytes: r.TargetSize,
+		Sidecar:     r.Sidecar,
+		DuplicateOf: sourceName(r.DuplicateOf),
+		Magick:      magickVersion,
+	}
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+	}
+	return rec
+}
+
+// resultLog is the open -jsonl file, or nil when per-file records are not requested.
+var resultLog struct {
+	sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+// openResultLog opens the -jsonl file for appending.
+func openResultLog(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open JSONL log: %v", err)
+	}
+	resultLog.file = f
+	resultLog.enc = json.NewEncoder(f)
+	return nil
+}
+
+// closeResultLog closes the -jsonl file, if one is open.
+func closeResultLog() {
+	if resultLog.file != nil {
+		resultLog.file.Close()
+	}
+}
+
+// recordResult writes res to the -jsonl file as one line, to the -manifest CSV, to -json output and the
+// -report and, for unreadable sources, to the -collect-corrupt list. It is safe for concurrent use by workers.
+func recordResult(res fileResult) error {
+	if err := writeManifestRow(res); err != nil {
+		return err
+	}
+	if err := reportResult(res); err != nil {
+		return err
+	}
+	if err := recordState(res); err != nil {
+		return err
+	}
+	if errors.Is(res.Err, errCorruptInput) {
+		if err := writeCorrupt(res.Source); err != nil {
+			return err
+		}
+	}
+	if resultLog.file == nil {
+		return nil
+	}
+	resultLog.Lock()
+	defer resultLog.Unlock()
+	if err := resultLog.enc.Encode(res.record()); err != nil {
+		return fmt.Errorf("failed to write JSONL record for %s: %v", res.Source, err)
+	}
+	return nil
+}
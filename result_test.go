@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResultsRecordMagickVersion(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, &magickVersion, "7.1.1-29")
+	jsonlPath := filepath.Join(dir, "results.jsonl")
+	manifestPath := filepath.Join(dir, "manifest.csv")
+	if err := openResultLog(jsonlPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := openManifest(manifestPath, false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		closeResultLog()
+		closeManifest()
+		resultLog.file, manifest.file = nil, nil
+	})
+
+	results := []fileResult{
+		{Source: "/p/a.heic", Target: "/p/a.jpg", Status: statusConverted, Duration: 1500 * time.Millisecond, SourceSize: 100, TargetSize: 50},
+		{Source: "/p/b.heic", Status: statusFailed, Err: errors.New("convert: improper image header")},
+	}
+	for _, res := range results {
+		if err := recordResult(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(jsonlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(results) {
+		t.Fatalf("JSONL has %d lines, want %d", len(lines), len(results))
+	}
+	for i, line := range lines {
+		var rec resultRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Magick != "7.1.1-29" || rec.Source != results[i].Source || rec.Status != results[i].Status {
+			t.Errorf("JSONL record %d = %+v", i, rec)
+		}
+	}
+	if !strings.Contains(lines[0], `"magick_version":"7.1.1-29"`) || !strings.Contains(lines[0], `"duration_ms":1500`) {
+		t.Errorf("JSONL record %s lacks the version or duration fields", lines[0])
+	}
+
+	closeManifest()
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(manifestHeader, ",") {
+		t.Fatalf("manifest = %q, want the header and one row per result", rows)
+	}
+	for _, row := range rows[1:] {
+		if row[len(row)-1] != "7.1.1-29" {
+			t.Errorf("manifest row %q lacks the ImageMagick version", row)
+		}
+	}
+	if rows[2][6] != "convert: improper image header" {
+		t.Errorf("manifest row %q lacks the error", rows[2])
+	}
+}
+
+func TestResultLogRecords(t *testing.T) {
+	stubMagick(t)
+	t.Setenv("STUB_BYTES", "40")
+	dir := t.TempDir()
+	setFlag(t, outType, "jpg")
+	setFlag(t, &infoOut, io.Discard)
+	good := writeFile(t, dir, "IMG_1.heic", strings.Repeat("x", 100))
+	bad := writeFile(t, dir, "IMG_2.heic", "bad")
+	writeFile(t, dir, "IMG_2.heic.fail", "convert: improper image header")
+	jsonlPath := filepath.Join(dir, "results.jsonl")
+	if err := openResultLog(jsonlPath); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		closeResultLog()
+		resultLog.file = nil
+	})
+
+	captureStdout(t, func() {
+		for _, in := range []string{good, bad} {
+			if err := recordResult(convertFile(context.Background(), in)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	data, err := os.ReadFile(jsonlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("JSONL has %d lines, want 2:\n%s", len(lines), data)
+	}
+	var ok, failed resultRecord
+	if err := json.Unmarshal([]byte(lines[0]), &ok); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &failed); err != nil {
+		t.Fatal(err)
+	}
+	wantOK := resultRecord{Type: "file", Source: good, Target: filepath.Join(dir, "IMG_1.jpg"), Status: statusConverted, SourceBytes: 100, TargetBytes: 40}
+	ok.DurationMS, ok.DecodeMS, ok.EncodeMS = 0, 0, 0
+	if ok != wantOK {
+		t.Errorf("success record = %+v, want %+v", ok, wantOK)
+	}
+	if failed.Source != bad || failed.Status != statusFailed || failed.TargetBytes != 0 {
+		t.Errorf("failure record = %+v, want a failed result for %s", failed, bad)
+	}
+	if !strings.Contains(failed.Error, "improper image header") {
+		t.Errorf("failure record error = %q, want the converter's error", failed.Error)
+	}
+	if strings.Contains(lines[0], `"error"`) {
+		t.Errorf("success record %s has an error field", lines[0])
+	}
+}
+
+func TestCheckSuccessRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		counts  map[string]int
+		wantErr bool
+	}{
+		{name: "above the threshold", counts: map[string]int{statusConverted: 19, statusFailed: 1}},
+		{name: "below the threshold", counts: map[string]int{statusConverted: 18, statusFailed: 2}, wantErr: true},
+		{name: "discarded outputs succeeded", counts: map[string]int{statusConverted: 9, statusDiscarded: 10, statusFailed: 1}},
+		// 90 skipped files would lift 18 of 20 conversions to 98% if they counted.
+		{name: "skipped files left out", counts: map[string]int{statusConverted: 18, statusFailed: 2, statusUpToDate: 40, statusExists: 30, statusDuplicate: 20}, wantErr: true},
+		{name: "dry-run and missing files left out", counts: map[string]int{statusConverted: 1, statusFailed: 1, statusDryRun: 100, statusMissing: 3, statusDumped: 5, statusMetadata: 5}, wantErr: true},
+		{name: "nothing attempted", counts: map[string]int{statusUpToDate: 12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, minSuccessRate, 0.95)
+			setFlag(t, &infoOut, io.Discard)
+			setFlag(t, &statusCounts.counts, tt.counts)
+			err := checkSuccessRate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkSuccessRate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// validateRetryFlags checks -retries and -failed-dir, resolving the quarantine directory and creating it
+// unless this is a dry run.
+func validateRetryFlags() error {
+	if *retries < 0 {
+		return errors.New("-retries must not be negative")
+	}
+	if *retryBackoff < 0 {
+		return errors.New("-retry-backoff must not be negative")
+	}
+	if *failedDir == "" {
+		if *failedLink {
+			return errors.New("-failed-symlink requires -failed-dir")
+		}
+		return nil
+	}
+	abs, err := filepath.Abs(*failedDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute -failed-dir path: %v", err)
+	}
+	*failedDir = abs
+	if !*dryRun {
+		if err := os.MkdirAll(*failedDir, 0o755); err != nil {
+			return fmt.Errorf("failed to create -failed-dir directory: %v", err)
+		}
+	}
+	return nil
+}
+
+// convertWithRetries converts inFile, retrying transient failures up to -retries times with an
+// exponential backoff. The wait is cut short when ctx is done.
+func convertWithRetries(ctx context.Context, inFile string) fileResult {
+	res := convertFile(ctx, inFile)
+	wait := *retryBackoff
+	for attempt := 1; attempt <= *retries && res.transient && ctx.Err() == nil; attempt++ {
+		fmt.Fprintf(infoOut, "INFO: Retrying %s in %s (attempt %d of %d): %v\n", inFile, wait, attempt, *retries, res.Err)
+		select {
+		case <-ctx.Done():
+			return res
+		case <-time.After(wait):
+		}
+		wait *= 2
+		res = convertFile(ctx, inFile)
+	}
+	return res
+}
+
+// quarantine moves a failed source into -failed-dir, or symlinks it there with -failed-symlink, and writes
+// the error and the converter's output next to it as <name>.err.txt.
+func quarantine(res fileResult) error {
+	if *dryRun || errors.Is(res.Err, errMissingInput) {
+		return nil
+	}
+	dest := archivePath(*failedDir, res.Source)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory for %s: %v", res.Source, err)
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		return fmt.Errorf("not quarantining %s, %s already exists", res.Source, dest)
+	}
+
+	report := fmt.Sprintf("source: %s\nerror: %v\n", res.Source, res.Err)
+	if stderr := strings.TrimSpace(res.Stderr); stderr != "" {
+		report += "\nconverter output:\n" + stderr + "\n"
+	}
+	if err := os.WriteFile(dest+".err.txt", []byte(report), 0o644); err != nil {
+		return fmt.Errorf("failed to write %s.err.txt: %v", dest, err)
+	}
+
+	if *failedLink {
+		if err := os.Symlink(res.Source, dest); err != nil {
+			return fmt.Errorf("failed to link %s into %s: %v", res.Source, *failedDir, err)
+		}
+		fmt.Fprintf(infoOut, "INFO: Linked failed source %s to %s.\n", res.Source, dest)
+		return nil
+	}
+	if err := moveFile(res.Source, dest); err != nil {
+		return fmt.Errorf("failed to move %s into %s: %v", res.Source, *failedDir, err)
+	}
+	fmt.Fprintf(infoOut, "INFO: Moved failed source %s to %s.\n", res.Source, dest)
+	return nil
+}
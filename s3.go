@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3EmptyHash is the SHA-256 of an empty body, sent with every request that has none.
+const s3EmptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Store reads and writes objects of one S3 bucket. Credentials and region come from the usual
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN and AWS_REGION variables; without
+// credentials requests are sent unsigned, which works for public buckets. AWS_ENDPOINT_URL points it at
+// an S3-compatible service such as MinIO, addressed path-style.
+type s3Store struct {
+	bucket    string
+	region    string
+	endpoint  string
+	pathStyle bool
+	accessKey string
+	secretKey string
+	token     string
+}
+
+// newS3Store returns the store for bucket, configured from the environment.
+func newS3Store(bucket string) *s3Store {
+	s := &s3Store{
+		bucket:    bucket,
+		region:    firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		s.endpoint = strings.TrimSuffix(endpoint, "/")
+		s.pathStyle = true
+	} else {
+		s.endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, s.region)
+	}
+	return s
+}
+
+// firstEnv returns the first of the named environment variables that is set.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// s3ListResult is the part of a ListObjectsV2 response the store reads.
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the objects under prefix, relative to it. Without recursive, objects in deeper
+// "directories" are left out.
+func (s *s3Store) List(ctx context.Context, prefix string, recursive bool) ([]remoteObject, error) {
+	var objects []remoteObject
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if !recursive {
+			query.Set("delimiter", "/")
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the listing of s3://%s/%s: %v", s.bucket, prefix, err)
+		}
+		for _, c := range result.Contents {
+			if rel := strings.TrimPrefix(c.Key, prefix); rel != "" && !strings.HasSuffix(rel, "/") {
+				objects = append(objects, remoteObject{key: rel, size: c.Size, modTime: c.LastModified})
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Get writes the object at key to w.
+func (s *s3Store) Get(ctx context.Context, key string, w io.Writer) error {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Put uploads size bytes from r to key.
+func (s *s3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, r, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a request for key, or for the bucket when key is empty, and returns the response when it
+// succeeded.
+func (s *s3Store) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	path := "/" + s3Escape(key, false)
+	if s.pathStyle {
+		path = "/" + s3Escape(s.bucket, false) + path
+	}
+	rawQuery := s3CanonicalQuery(query)
+	target := s.endpoint + path
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if s.accessKey != "" {
+		s.sign(req, path, rawQuery, body != nil)
+	}
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s s3://%s/%s: %s: %s", method, s.bucket, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req. Upload bodies are streamed, so
+// their hash is declared unsigned rather than read twice.
+func (s *s3Store) sign(req *http.Request, path, rawQuery string, hasBody bool) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := s3EmptyHash
+	if hasBody {
+		payloadHash = "UNSIGNED-PAYLOAD"
+	}
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("x-amz-security-token", s.token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, path, rawQuery, canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{day, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data under key.
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3CanonicalQuery encodes query the way Signature Version 4 expects: sorted by name, every value
+// escaped.
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and slashes too when escapeSlash is
+// set, as S3 signatures require.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// runRand is the PRNG shared by every random selection in a run, created on first use by randSource.
+var (
+	runRand     *rand.Rand
+	runRandOnce sync.Once
+)
+
+// randSource returns the run's PRNG. A zero -seed picks a fresh seed, which is reported so the run can
+// be reproduced.
+func randSource() *rand.Rand {
+	runRandOnce.Do(func() {
+		seed := uint64(*seedFlag)
+		if seed == 0 {
+			seed = uint64(time.Now().UnixNano())
+			fmt.Fprintln(infoOut, "INFO: Random seed:", seed)
+		}
+		runRand = rand.New(rand.NewPCG(seed, seed))
+	})
+	return runRand
+}
+
+// shuffleFiles returns a copy of files in random order.
+func shuffleFiles(files []string, r *rand.Rand) []string {
+	shuffled := append([]string(nil), files...)
+	r.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	return shuffled
+}
+
+// sampleFiles returns n randomly chosen files, in their original relative order.
+func sampleFiles(files []string, n int, r *rand.Rand) []string {
+	if n >= len(files) {
+		return files
+	}
+	picked := r.Perm(len(files))[:n]
+	sort.Ints(picked)
+	sampled := make([]string, 0, n)
+	for _, i := range picked {
+		sampled = append(sampled, files[i])
+	}
+	return sampled
+}
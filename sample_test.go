@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// resetRandSource makes the next randSource call seed a new PRNG from -seed, as at the start of a run.
+func resetRandSource(t *testing.T) {
+	runRand, runRandOnce = nil, sync.Once{}
+	t.Cleanup(func() { runRand, runRandOnce = nil, sync.Once{} })
+}
+
+func TestSampleFilesSeeded(t *testing.T) {
+	var files []string
+	for i := range 50 {
+		files = append(files, fmt.Sprintf("/p/IMG_%04d.heic", i))
+	}
+	// run repeats what a run with -seed does: seed the shared PRNG, then sample.
+	run := func(seed int64) []string {
+		resetRandSource(t)
+		setFlag(t, seedFlag, seed)
+		return sampleFiles(files, 10, randSource())
+	}
+
+	first, second := run(42), run(42)
+	if !slices.Equal(first, second) {
+		t.Errorf("-seed 42 sampled %q, then %q", first, second)
+	}
+	if len(first) != 10 || !slices.IsSorted(first) {
+		t.Errorf("sampleFiles() = %q, want 10 files in their original order", first)
+	}
+	if other := run(7); slices.Equal(first, other) {
+		t.Errorf("-seed 7 sampled the same files as -seed 42: %q", other)
+	}
+	if got := sampleFiles(files[:5], 10, randSource()); !slices.Equal(got, files[:5]) {
+		t.Errorf("sampleFiles() of fewer files than asked = %q, want them all", got)
+	}
+}
+
+func TestShuffleFilesSeeded(t *testing.T) {
+	var files []string
+	for i := range 50 {
+		files = append(files, fmt.Sprintf("/p/IMG_%04d.heic", i))
+	}
+	original := slices.Clone(files)
+	run := func(seed int64) []string {
+		resetRandSource(t)
+		setFlag(t, seedFlag, seed)
+		return shuffleFiles(files, randSource())
+	}
+
+	first, second := run(42), run(42)
+	if !slices.Equal(first, second) {
+		t.Errorf("-seed 42 shuffled to %q, then %q", first, second)
+	}
+	if slices.Equal(first, files) {
+		t.Errorf("shuffleFiles() kept the original order")
+	}
+	if sorted := slices.Sorted(slices.Values(first)); !slices.Equal(sorted, files) {
+		t.Errorf("shuffleFiles() = %q, want a permutation of the input", first)
+	}
+	if other := run(7); slices.Equal(first, other) {
+		t.Errorf("-seed 7 shuffled to the same order as -seed 42: %q", other)
+	}
+	if !slices.Equal(files, original) {
+		t.Errorf("shuffleFiles() reordered its input: %q", files)
+	}
+}
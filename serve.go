@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nomadicGopher/Convert_HEIC/convert"
+)
+
+// serveShutdownTimeout is how long the server waits for requests in flight after an interrupt.
+const serveShutdownTimeout = 30 * time.Second
+
+// server is the serve subcommand's HTTP API, converting one uploaded HEIC per request.
+type server struct {
+	// slots bounds the conversions running at once.
+	slots chan struct{}
+	// uploads bounds the requests holding an upload on disk, whether it is waiting for a slot or converting.
+	uploads chan struct{}
+
+	timeout       time.Duration
+	maxUpload     int64
+	defaultFormat string
+
+	mu         sync.Mutex
+	converters map[convert.Options]*convert.Converter
+}
+
+// runServe runs the serve subcommand: POST /convert?format=jpg&quality=85 with a HEIC body responds
+// with the converted image. At most -workers conversions run at once; other requests wait for a slot
+// until their -timeout runs out.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "Address to listen on")
+	workers := new(int)
+	fs.Var(workerCount{n: workers}, "workers", "Number of parallel conversions, or auto for one per CPU")
+	timeout := fs.Duration("timeout", time.Minute, "Time limit for each request, including receiving the upload and waiting for a free worker")
+	maxUpload := fs.String("max-upload", "100MB", "Largest accepted upload")
+	maxUploads := fs.Int("max-uploads", 0, "Most uploads received or held on disk at once, including those converting; others wait within their -timeout (0 = twice -workers)")
+	format := fs.String("format", "jpg", "Output format for requests without a format parameter")
+	config := fs.String("config", "", "Read flag defaults from the serve: section of this YAML file (default: "+defaultConfigName+" in the working directory, if present)")
+	if err := fs.Parse(args); err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	configFile, err := applySettings(fs, "serve", *config)
+	if err != nil {
+		return withExitCode(exitUsage, err)
+	}
+	if configFile != "" {
+		fmt.Fprintln(infoOut, "INFO: Config:", configFile)
+	}
+	if *workers == 0 {
+		*workers = runtime.NumCPU()
+	}
+	limit, err := parseByteSize(*maxUpload)
+	if err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("invalid -max-upload: %v", err))
+	}
+	if *maxUploads < 0 {
+		return withExitCode(exitUsage, errors.New("-max-uploads must not be negative"))
+	}
+	if *maxUploads == 0 {
+		*maxUploads = 2 * *workers
+	}
+	s := &server{
+		slots:         make(chan struct{}, *workers),
+		uploads:       make(chan struct{}, *maxUploads),
+		timeout:       *timeout,
+		maxUpload:     limit,
+		defaultFormat: *format,
+		converters:    make(map[convert.Options]*convert.Converter),
+	}
+	// Check ImageMagick once up front rather than failing the first request.
+	if _, err := s.converter(convert.Options{Format: *format, AutoOrient: true}); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/convert", s.handleConvert)
+	srv := &http.Server{Addr: *addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second, IdleTimeout: time.Minute}
+	ctx := interruptContext()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	fmt.Fprintf(infoOut, "INFO: Serving POST /convert on %s with %d workers.\n", *addr, *workers)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server failed: %v", err)
+	}
+	return nil
+}
+
+// converter returns the Converter for opts, creating it on first use.
+func (s *server) converter(opts convert.Options) (*convert.Converter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.converters[opts]; ok {
+		return c, nil
+	}
+	c, err := convert.New(opts)
+	if err != nil {
+		return nil, err
+	}
+	s.converters[opts] = c
+	return c, nil
+}
+
+// handleConvert converts the HEIC in the request body and writes the result.
+func (s *server) handleConvert(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST with a HEIC body", http.StatusMethodNotAllowed)
+		return
+	}
+	// Uploads are rotated upright like the command's outputs, which auto-orient by default.
+	opts := convert.Options{Format: r.URL.Query().Get("format"), AutoOrient: true}
+	if opts.Format == "" {
+		opts.Format = s.defaultFormat
+	}
+	if q := r.URL.Query().Get("quality"); q != "" {
+		var err error
+		if opts.Quality, err = strconv.Atoi(q); err != nil || opts.Quality < 1 || opts.Quality > 100 {
+			http.Error(w, "quality must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+	c, err := s.converter(opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	// The body is read and the response written under the same deadline, so a slow client cannot hold
+	// an upload past its -timeout either.
+	deadline, _ := ctx.Deadline()
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(deadline)
+	rc.SetWriteDeadline(deadline)
+	select {
+	case s.uploads <- struct{}{}:
+		defer func() { <-s.uploads }()
+	case <-ctx.Done():
+		http.Error(w, "too many uploads in progress", http.StatusServiceUnavailable)
+		return
+	}
+	dir, err := os.MkdirTemp("", "convert_heic_serve_")
+	if err != nil {
+		log.Printf("ERROR: failed to create upload directory: %v\n", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+	inFile := filepath.Join(dir, "upload.heic")
+	if status, err := saveUpload(w, r, s.maxUpload, inFile); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		http.Error(w, "no worker became free in time", http.StatusServiceUnavailable)
+		return
+	}
+	res := c.Convert(ctx, inFile, c.OutputPath(inFile))
+	if res.Err != nil {
+		switch {
+		case errors.Is(res.Err, context.DeadlineExceeded):
+			http.Error(w, "conversion timed out", http.StatusGatewayTimeout)
+		case ctx.Err() != nil:
+			// The client went away; there is nobody left to answer.
+		default:
+			fmt.Fprintf(os.Stdout, "WARN: Failed to convert an upload from %s: %v\n", r.RemoteAddr, res.Err)
+			http.Error(w, "the upload could not be converted", http.StatusUnprocessableEntity)
+		}
+		return
+	}
+
+	out, err := os.Open(res.Target)
+	if err != nil {
+		log.Printf("ERROR: failed to open converted upload: %v\n", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer out.Close()
+	contentType := mime.TypeByExtension(filepath.Ext(res.Target))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(res.TargetSize, 10))
+	io.Copy(w, out)
+	fmt.Fprintf(infoOut, "INFO: Converted an upload of %d bytes from %s to %s in %s.\n", res.SourceSize, r.RemoteAddr, opts.Format, res.Duration.Round(time.Millisecond))
+}
+
+// saveUpload writes the request body to path, rejecting bodies over limit and content that isn't HEIC. On
+// failure it returns the HTTP status to answer with.
+func saveUpload(w http.ResponseWriter, r *http.Request, limit int64, path string) (int, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Printf("ERROR: failed to save upload: %v\n", err)
+		return http.StatusInternalServerError, errors.New("internal error")
+	}
+	_, err = io.Copy(f, http.MaxBytesReader(w, r.Body, limit))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("uploads are limited to %d bytes", limit)
+	}
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("failed to read upload: %v", err)
+	}
+	brands, err := ftypBrands(path)
+	if err == nil {
+		for _, brand := range brands {
+			if _, ok := heifBrands[brand]; ok {
+				return 0, nil
+			}
+		}
+	}
+	return http.StatusUnsupportedMediaType, errors.New("the upload is not a HEIC image")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+)
+
+// sipsConverter converts with sips, the image tool built into macOS, which reads HEIC natively.
+type sipsConverter struct{}
+
+// Verify checks that sips is available.
+func (sipsConverter) Verify() error {
+	if _, err := exec.LookPath("sips"); err != nil {
+		return errors.New("the 'sips' command does not exist, it ships with macOS")
+	}
+	return nil
+}
+
+// Command builds the sips invocation for one file.
+func (sipsConverter) Command(inFile, outFile string) (string, []string, error) {
+	return "sips", buildSipsArgs(inFile, outFile), nil
+}
+
+// buildSipsArgs assembles the sips arguments for one file. sips names the JPEG format "jpeg" whatever
+// extension the output uses.
+func buildSipsArgs(inFile, outFile string) []string {
+	format := *outType
+	if isJpegType(format) {
+		format = "jpeg"
+	}
+	args := []string{"-s", "format", format}
+	if *jpegQuality > 0 && isJpegType(*outType) {
+		args = append(args, "-s", "formatOptions", strconv.Itoa(*jpegQuality))
+	}
+	return append(args, inFile, "--out", outFile)
+}
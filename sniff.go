@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// heifBrands are the ftyp brands of HEIF-based stills and sequences that ImageMagick can decode.
+var heifBrands = map[string]struct{}{
+	"heic": {},
+	"heix": {},
+	"heim": {},
+	"heis": {},
+	"hevc": {},
+	"hevx": {},
+	"mif1": {},
+	"msf1": {},
+}
+
+// ftypBrands reads the ftyp box at the start of path and returns its major and compatible brands, or
+// nil when the file is not an ISO media file.
+func ftypBrands(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The ftyp box is small: size, type, major brand, minor version, then compatible brands.
+	buf := make([]byte, 64)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	buf = buf[:n]
+	if len(buf) < 16 || string(buf[4:8]) != "ftyp" {
+		return nil, nil
+	}
+	if size := int(binary.BigEndian.Uint32(buf[:4])); size >= 16 && size < len(buf) {
+		buf = buf[:size]
+	}
+	brands := []string{string(buf[8:12])}
+	for i := 16; i+4 <= len(buf); i += 4 {
+		brands = append(brands, string(buf[i:i+4]))
+	}
+	return brands, nil
+}
+
+// checkHeicSource returns nil when path is a HEIC source, or a JPEG or PNG source with -output heic. By default that is decided by the extension; with
+// -sniff it is decided by the ftyp box, so misnamed HEIC files are accepted and mislabeled ones are not.
+func checkHeicSource(path string) error {
+	if !*sniff {
+		if !isHeicFile(path) {
+			return fmt.Errorf("%s does not have a %s extension", path, sourceExtensions())
+		}
+		return nil
+	}
+	if encodingHEIC() {
+		return checkEncodeSource(path)
+	}
+	brands, err := ftypBrands(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if brands == nil {
+		return fmt.Errorf("%s is not a HEIC file, it has no ftyp box", path)
+	}
+	for _, brand := range brands {
+		if _, ok := heifBrands[brand]; ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a HEIC file, its ftyp brand is %q", path, brands[0])
+}
+
+// isHeicSource reports whether a file found while scanning a directory is a HEIC source. Files that are
+// rejected even though their extension claims HEIC are reported with a warning; other files are skipped silently.
+func isHeicSource(path string) bool {
+	if !*sniff {
+		return isHeicFile(path)
+	}
+	err := checkHeicSource(path)
+	if err != nil && isHeicFile(path) {
+		fmt.Fprintf(os.Stdout, "WARN: Skipping source: %v.\n", err)
+	}
+	return err == nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultStateName is the state file name used when -state is not provided.
+const defaultStateName = ".convert_heic_state.json"
+
+// stateSaveInterval is the minimum time between rewrites of the state file during a run, bounding both the
+// cost of saving on large batches and the work lost to a crash.
+const stateSaveInterval = time.Second
+
+// Statuses recorded in the state file.
+const (
+	stateDone   = "done"
+	stateFailed = "failed"
+)
+
+// stateEntry records the last outcome for one source.
+type stateEntry struct {
+	Source string `json:"source"`
+	SHA256 string `json:"sha256,omitempty"`
+	Output string `json:"output,omitempty"`
+	Status string `json:"status"`
+}
+
+// runState is the -state file: every source's last outcome, saved as the run goes so an interrupted batch
+// can continue with -resume.
+var runState struct {
+	sync.Mutex
+	path    string
+	entries map[string]stateEntry
+	saved   time.Time
+}
+
+// resolveStatePath returns the -state path, defaulting to a file inside the input directory, or the
+// working directory when sources come from a list, a pattern or a remote -input.
+func resolveStatePath(inPathInfo os.FileInfo) string {
+	if *statePath != "" {
+		return *statePath
+	}
+	switch {
+	case inPathInfo == nil, remote.spool != "" && strings.HasPrefix(*inPath, remote.spool):
+		return defaultStateName
+	case inPathInfo.IsDir():
+		return filepath.Join(*inPath, defaultStateName)
+	default:
+		return filepath.Join(filepath.Dir(*inPath), defaultStateName)
+	}
+}
+
+// loadState reads the state file for -state or -resume; a missing file starts an empty state.
+func loadState(inPathInfo os.FileInfo) error {
+	runState.path = resolveStatePath(inPathInfo)
+	runState.entries = make(map[string]stateEntry)
+	data, err := os.ReadFile(runState.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %v", err)
+	}
+	var state struct {
+		Entries []stateEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse state file %s: %v", runState.path, err)
+	}
+	for _, e := range state.Entries {
+		runState.entries[e.Source] = e
+	}
+	return nil
+}
+
+// excludeResumed drops the sources that -resume finds already done: marked done in the state file, with
+// their output still present and their content unchanged since.
+func excludeResumed(files []string) []string {
+	if !*resume || len(runState.entries) == 0 {
+		return files
+	}
+	remaining := files[:0:0]
+	for _, file := range files {
+		if !resumeDone(file) {
+			remaining = append(remaining, file)
+		}
+	}
+	if skipped := len(files) - len(remaining); skipped > 0 {
+		fmt.Fprintf(infoOut, "INFO: Resuming: skipping %d files already done according to %s.\n", skipped, runState.path)
+	}
+	return remaining
+}
+
+// resumeDone reports whether the state file shows file as done.
+func resumeDone(file string) bool {
+	runState.Lock()
+	e, ok := runState.entries[sourceName(file)]
+	runState.Unlock()
+	if !ok || e.Status != stateDone || !recordedOutputExists(e.Output) {
+		return false
+	}
+	sum, err := hashFile(file)
+	return err == nil && sum == e.SHA256
+}
+
+// recordState stores the outcome of res in the state file, saving it when stateSaveInterval has passed.
+// Outcomes that say nothing about the source, such as dry runs, missing files and interruptions, are not recorded.
+func recordState(res fileResult) error {
+	if runState.entries == nil || errors.Is(res.Err, errInterrupted) {
+		return nil
+	}
+	e := stateEntry{Source: sourceName(res.Source), Output: targetName(res.Target)}
+	switch res.Status {
+	case statusConverted, statusExists, statusUpToDate:
+		e.Status = stateDone
+	case statusFailed:
+		e.Status = stateFailed
+	default:
+		return nil
+	}
+	// A source moved or deleted after conversion has no content left to hash; it won't be listed again anyway.
+	e.SHA256, _ = hashFile(res.Source)
+
+	runState.Lock()
+	defer runState.Unlock()
+	runState.entries[e.Source] = e
+	if time.Since(runState.saved) < stateSaveInterval {
+		return nil
+	}
+	return saveStateLocked()
+}
+
+// saveState writes the state file, replacing it atomically.
+func saveState() error {
+	if runState.entries == nil {
+		return nil
+	}
+	runState.Lock()
+	defer runState.Unlock()
+	return saveStateLocked()
+}
+
+// saveStateLocked writes the state file sorted by source for stable diffs; the caller holds runState.
+func saveStateLocked() error {
+	keys := make([]string, 0, len(runState.entries))
+	for k := range runState.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	entries := make([]stateEntry, 0, len(keys))
+	for _, k := range keys {
+		entries = append(entries, runState.entries[k])
+	}
+	data, err := json.MarshalIndent(struct {
+		Entries []stateEntry `json:"entries"`
+	}{entries}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state file: %v", err)
+	}
+	tmp := runState.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	if err := os.Rename(tmp, runState.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write state file: %v", err)
+	}
+	runState.saved = time.Now()
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// streams holds the temporary files behind -input - and -output-file -.
+var streams struct {
+	// dir holds the buffered stdin source and the output written to stdout; empty when not streaming.
+	dir string
+	// stdout is the real standard output for the converted image; os.Stdout is pointed at stderr meanwhile.
+	stdout *os.File
+	// stdin is set when the source was read from stdin, which is then drained.
+	stdin bool
+}
+
+// setupStreams buffers stdin into a temporary source for -input - and, for -output-file -, keeps the real
+// stdout for the image while INFO lines are dropped and other output moves to stderr.
+func setupStreams() error {
+	stdinInput := len(*inputs) > 0 && *inPath == "-"
+	if !stdinInput && *outputFile != "-" {
+		return nil
+	}
+	if stdinInput && len(*inputs) > 1 {
+		return withExitCode(exitUsage, errors.New("-input - cannot be combined with other -input values"))
+	}
+	if *outputFile == "-" && *jsonOutput {
+		return withExitCode(exitUsage, errors.New("-output-file - and -json cannot be used together, as both write to stdout"))
+	}
+	dir, err := os.MkdirTemp("", "convert_heic_stream_")
+	if err != nil {
+		return fmt.Errorf("failed to create stream directory: %v", err)
+	}
+	streams.dir = dir
+
+	if *outputFile == "-" {
+		streams.stdout = os.Stdout
+		os.Stdout = os.Stderr
+		infoOut = io.Discard
+	}
+	if stdinInput {
+		source := filepath.Join(dir, "stdin.heic")
+		f, err := os.Create(source)
+		if err != nil {
+			return fmt.Errorf("failed to buffer stdin: %v", err)
+		}
+		_, err = io.Copy(f, os.Stdin)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to buffer stdin: %v", err)
+		}
+		*inPath = source
+		(*inputs)[0] = source
+		streams.stdin = true
+	}
+	return nil
+}
+
+// validateOutputFile checks that -output-file names the output of exactly one source file, and that a
+// source read from stdin has one or an -outdir, as the output would otherwise land next to the buffered
+// source and be removed with it.
+func validateOutputFile(inPathInfo os.FileInfo) error {
+	if streams.stdin && *outputFile == "" && *outDir == "" {
+		return errors.New("-input - needs -output-file or -outdir, as the buffered source is removed after the run")
+	}
+	if *outputFile == "" {
+		return nil
+	}
+	if inPathInfo == nil || inPathInfo.IsDir() {
+		return errors.New("-output-file requires a single file -input")
+	}
+	if *outDir != "" {
+		return errors.New("-output-file and -outdir cannot be used together")
+	}
+	if *outputFile == "-" && (*allFrames || *livePhotos) {
+		return errors.New("-output-file - writes one image and cannot be combined with -all-frames or -live-photos")
+	}
+	return nil
+}
+
+// outputFileTarget returns the -output-file path, or the temporary file streamed to stdout for -output-file -.
+func outputFileTarget() string {
+	if *outputFile == "-" {
+		return filepath.Join(streams.dir, "stdout."+outputExtension())
+	}
+	return *outputFile
+}
+
+// finishStreams writes the converted image to stdout for -output-file - and removes the temporary files.
+func finishStreams() error {
+	defer cleanupStreams()
+	if streams.stdout == nil || *dryRun {
+		return nil
+	}
+	f, err := os.Open(outputFileTarget())
+	if err != nil {
+		return fmt.Errorf("no output was produced for stdout: %v", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(streams.stdout, f); err != nil {
+		return fmt.Errorf("failed to write output to stdout: %v", err)
+	}
+	return nil
+}
+
+// cleanupStreams removes the temporary stdin source and stdout output, if any.
+func cleanupStreams() {
+	if streams.dir != "" {
+		os.RemoveAll(streams.dir)
+		streams.dir = ""
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// splitSymlinks separates relative symlinks whose target is also in files from the files that need
+// converting. The returned map goes from each such link to its target; every other file, including
+// links pointing outside the batch, is converted normally.
+func splitSymlinks(files []string) (regular []string, links map[string]string) {
+	inBatch := make(map[string]bool, len(files))
+	for _, file := range files {
+		inBatch[file] = true
+	}
+	links = make(map[string]string)
+	for _, file := range files {
+		info, err := os.Lstat(file)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			regular = append(regular, file)
+			continue
+		}
+		dest, err := os.Readlink(file)
+		if err != nil || filepath.IsAbs(dest) {
+			regular = append(regular, file)
+			continue
+		}
+		target := filepath.Join(filepath.Dir(file), dest)
+		if !inBatch[target] || target == file {
+			regular = append(regular, file)
+			continue
+		}
+		links[file] = target
+	}
+	return regular, links
+}
+
+// recreateSymlinks links the output of each symlinked source to the output of its target, using a
+// relative link like the original. Links whose target produced no output are skipped.
+func recreateSymlinks(links map[string]string) {
+	for link, target := range links {
+		targetOut, err := outputPath(target)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: Skipping symlink %s: %v\n", link, err)
+			continue
+		}
+		if _, err := os.Stat(targetOut); err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: Skipping symlink %s, its target %s was not converted.\n", link, target)
+			continue
+		}
+		linkOut, err := prepareOutputPath(link)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: Skipping symlink %s: %v\n", link, err)
+			continue
+		}
+		rel, err := filepath.Rel(filepath.Dir(linkOut), targetOut)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: Skipping symlink %s: %v\n", link, err)
+			continue
+		}
+		os.Remove(linkOut)
+		if err := os.Symlink(rel, linkOut); err != nil {
+			fmt.Fprintf(os.Stdout, "WARN: Failed to link %s: %v\n", linkOut, err)
+			continue
+		}
+		fmt.Fprintf(infoOut, "INFO: Linked %s to %s.\n", linkOut, rel)
+	}
+}
+
+// walkFollowingSymlinks calls fn for every file under dir, descending into symlinked directories as well
+// as real ones. visited holds the directories already walked, so a link back up the tree, or a second
+// link to the same album, is walked once rather than forever.
+func walkFollowingSymlinks(root, dir string, visited map[string]bool, fn func(path string)) error {
+	key, err := directoryKey(dir)
+	if err != nil {
+		return err
+	}
+	if visited[key] {
+		fmt.Fprintf(infoOut, "INFO: Skipping %s, it leads to a directory already walked.\n", dir)
+		return nil
+	}
+	visited[key] = true
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			// A broken link is passed on as a file, so its conversion reports it.
+			if info, err := os.Stat(path); err == nil {
+				isDir = info.IsDir()
+			}
+		}
+		if !isDir {
+			fn(path)
+			continue
+		}
+		if excludedDir(root, path) {
+			continue
+		}
+		if err := walkFollowingSymlinks(root, path, visited, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// directoryKey identifies the directory dir resolves to, by file identity where the platform has one
+// and by its resolved path otherwise.
+func directoryKey(dir string) (string, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if key, ok := fileIdentity(info); ok {
+		return key, nil
+	}
+	return filepath.EvalSymlinks(dir)
+}
+
+// linkDuplicates are the sources skipLinkedDuplicates left out, for processFileList to report.
+var linkDuplicates []duplicate
+
+// skipLinkedDuplicates leaves out sources that are the same file as an earlier source: hard links and,
+// with -follow-symlinks, symlinks and files reached through symlinked directories. Without
+// -follow-symlinks, and with -preserve-symlinks, symlinked sources are kept as before.
+func skipLinkedDuplicates(files []string) []string {
+	seen := make(map[string]string, len(files))
+	kept := files[:0:0]
+	for _, file := range files {
+		info, err := os.Lstat(file)
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if !*followSymlinks || *preserveLinks {
+				kept = append(kept, file)
+				continue
+			}
+			info, err = os.Stat(file)
+		}
+		var key string
+		ok := false
+		if err == nil {
+			key, ok = fileIdentity(info)
+		}
+		if !ok {
+			kept = append(kept, file)
+			continue
+		}
+		if first, dup := seen[key]; dup {
+			linkDuplicates = append(linkDuplicates, duplicate{source: file, canonical: first, match: "the same file as"})
+			continue
+		}
+		seen[key] = file
+		kept = append(kept, file)
+	}
+	if n := len(files) - len(kept); n > 0 {
+		fmt.Fprintf(infoOut, "INFO: Skipping %d sources that are links to other sources.\n", n)
+	}
+	return kept
+}
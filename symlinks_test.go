@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// symlinkBatch creates a source directory holding a.heic, an in-batch relative link to it, an absolute
+// link to it, a relative link from a subdirectory and a link to a HEIC outside the batch, and returns
+// the directory and the batch in scan order.
+func symlinkBatch(t *testing.T) (string, []string) {
+	t.Helper()
+	root := t.TempDir()
+	src := filepath.Join(root, "src")
+	a := writeFile(t, src, "a.heic", "source")
+	writeFile(t, root, filepath.Join("outside", "x.heic"), "source")
+	link := func(name, dest string) string {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(dest, path); err != nil {
+			t.Skipf("symlinks are unavailable: %v", err)
+		}
+		return path
+	}
+	files := []string{
+		a,
+		link("b.heic", "a.heic"),
+		link("c.heic", a),
+		link(filepath.Join("album", "d.heic"), filepath.Join("..", "a.heic")),
+		link("e.heic", filepath.Join("..", "outside", "x.heic")),
+	}
+	return src, files
+}
+
+func TestSplitSymlinks(t *testing.T) {
+	src, files := symlinkBatch(t)
+	regular, links := splitSymlinks(files)
+
+	a := filepath.Join(src, "a.heic")
+	// The absolute link and the link outside the batch are converted like any other file.
+	if want := []string{a, filepath.Join(src, "c.heic"), filepath.Join(src, "e.heic")}; !slices.Equal(regular, want) {
+		t.Errorf("splitSymlinks() regular = %q, want %q", regular, want)
+	}
+	wantLinks := map[string]string{
+		filepath.Join(src, "b.heic"):          a,
+		filepath.Join(src, "album", "d.heic"): a,
+	}
+	if !maps.Equal(links, wantLinks) {
+		t.Errorf("splitSymlinks() links = %q, want %q", links, wantLinks)
+	}
+}
+
+func TestRecreateSymlinks(t *testing.T) {
+	src, files := symlinkBatch(t)
+	out := filepath.Join(filepath.Dir(src), "out")
+	setFlag(t, outType, "jpg")
+	setFlag(t, outDir, out)
+	setFlag(t, relativeTo, src)
+	setFlag(t, &infoOut, io.Discard)
+	_, links := splitSymlinks(files)
+	writeFile(t, out, "a.jpg", "output")
+	// A link whose target produced no output is left alone.
+	links[filepath.Join(src, "f.heic")] = filepath.Join(src, "missing.heic")
+
+	captureStdout(t, func() { recreateSymlinks(links) })
+
+	for link, want := range map[string]string{
+		filepath.Join(out, "b.jpg"):          "a.jpg",
+		filepath.Join(out, "album", "d.jpg"): filepath.Join("..", "a.jpg"),
+	} {
+		if dest, err := os.Readlink(link); err != nil || dest != want {
+			t.Errorf("output link %s = %q, %v, want %q", link, dest, err, want)
+		}
+		if data, err := os.ReadFile(link); err != nil || string(data) != "output" {
+			t.Errorf("output link %s does not resolve to a.jpg: %q, %v", link, data, err)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(out, "f.jpg")); !os.IsNotExist(err) {
+		t.Errorf("a link was made for a target without output: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// targetBytes is the parsed -target-size, or zero when outputs are not size-constrained.
+var targetBytes int64
+
+// shrinkStep is how much, in percent, each -shrink-to-fit round reduces the dimensions by.
+const shrinkStep = 10
+
+// parseByteSize parses sizes such as "500KB", "1.5M" or "2048". Units are binary (1 KB = 1024 bytes).
+func parseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, multiplier = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a positive value such as 500KB or 2MB", value)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// encodeJPEG runs convert with the given base arguments (input and options, no output) at the
+// requested quality and scale, returning the encoded bytes and what convert wrote to stderr.
+func encodeJPEG(ctx context.Context, baseArgs, env []string, quality, scale int) ([]byte, string, error) {
+	args := append([]string{}, baseArgs...)
+	if scale < 100 {
+		args = append(args, "-resize", strconv.Itoa(scale)+"%")
+	}
+	args = append(args, "-quality", strconv.Itoa(quality), "jpg:-")
+	var out, stderr bytes.Buffer
+	cmd := magickCommandContext(ctx, "convert", args...)
+	cmd.Env = env
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if output := strings.TrimSpace(stderr.String()); output != "" {
+			err = fmt.Errorf("%v: %s", err, output)
+		}
+		return nil, "", err
+	}
+	return out.Bytes(), stderr.String(), nil
+}
+
+// bestQualityUnder binary searches for the highest quality whose encoding fits in limit bytes.
+// It returns ok=false when even quality 1 is too large.
+func bestQualityUnder(limit int64, encode func(quality int) ([]byte, error)) (data []byte, quality int, ok bool, err error) {
+	lo, hi := 1, 100
+	if qualityCeil > 0 {
+		hi = qualityCeil
+	}
+	if *jpegQuality > 0 {
+		hi = *jpegQuality
+	}
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		enc, err := encode(mid)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if int64(len(enc)) <= limit {
+			data, quality, ok = enc, mid, true
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+	return data, quality, ok, nil
+}
+
+// fitTargetSize encodes the conversion described by args (input, options and output path), run with env, into at most
+// targetBytes, lowering the quality first and then, with -shrink-to-fit, the dimensions as well.
+// It returns the encoded bytes with the quality and scale percentage that achieved the target, and
+// the warnings convert wrote while producing them. Side outputs such as renditions and thumbnails are
+// left out of the search and written once, by a final encode at the chosen quality and scale.
+func fitTargetSize(ctx context.Context, args, env []string) (data []byte, quality, scale int, warnings string, err error) {
+	baseArgs := args[:len(args)-1]
+	searchArgs := withoutSideOutputs(baseArgs)
+	for scale = 100; scale > 0; scale -= shrinkStep {
+		stderrs := make(map[int]string)
+		data, quality, ok, err := bestQualityUnder(targetBytes, func(q int) ([]byte, error) {
+			enc, stderr, err := encodeJPEG(ctx, searchArgs, env, q, scale)
+			stderrs[q] = stderr
+			return enc, err
+		})
+		if err != nil {
+			return nil, 0, 0, "", err
+		}
+		warnings = stderrs[quality]
+		if ok && len(searchArgs) < len(baseArgs) {
+			data, warnings, err = encodeJPEG(ctx, baseArgs, env, quality, scale)
+			if err != nil {
+				return nil, 0, 0, "", err
+			}
+		}
+		if ok {
+			return data, quality, scale, warnings, nil
+		}
+		if !*shrinkToFit {
+			break
+		}
+	}
+	if *shrinkToFit {
+		return nil, 0, 0, "", errors.New("cannot reach -target-size even at quality 1 and the smallest scale")
+	}
+	return nil, 0, 0, "", errors.New("cannot reach -target-size even at quality 1, consider -shrink-to-fit")
+}
+
+// withoutSideOutputs returns args without the parenthesized "+clone ... -write file" groups that write
+// renditions and thumbnails next to the main output.
+func withoutSideOutputs(args []string) []string {
+	var kept, group []string
+	inGroup := false
+	for _, arg := range args {
+		switch {
+		case arg == "(" && !inGroup:
+			inGroup, group = true, []string{arg}
+		case inGroup:
+			group = append(group, arg)
+			if arg == ")" {
+				inGroup = false
+				if !slices.Contains(group, "-write") {
+					kept = append(kept, group...)
+				}
+			}
+		default:
+			kept = append(kept, arg)
+		}
+	}
+	if inGroup {
+		kept = append(kept, group...)
+	}
+	return kept
+}
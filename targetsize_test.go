@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		value     string
+		want      int64
+		wantError bool
+	}{
+		{value: "2048", want: 2048},
+		{value: "500KB", want: 500 << 10},
+		{value: "1.5M", want: 3 << 19},
+		{value: " 2 gb ", want: 2 << 30},
+		{value: "10B", want: 10},
+		{value: "0", wantError: true},
+		{value: "-1MB", wantError: true},
+		{value: "MB", wantError: true},
+		{value: "lots", wantError: true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.value)
+		if tt.wantError {
+			if err == nil {
+				t.Errorf("parseByteSize(%q) = %d, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestBestQualityUnder(t *testing.T) {
+	// Each quality point costs 10 bytes.
+	encode := func(q int) ([]byte, error) { return make([]byte, q*10), nil }
+	tests := []struct {
+		limit   int64
+		ceiling int
+		quality int
+		ok      bool
+	}{
+		{limit: 2000, quality: 100, ok: true},
+		{limit: 855, quality: 85, ok: true},
+		{limit: 10, quality: 1, ok: true},
+		{limit: 9, ok: false},
+		{limit: 2000, ceiling: 80, quality: 80, ok: true},
+	}
+	for _, tt := range tests {
+		setFlag(t, &qualityCeil, tt.ceiling)
+		data, quality, ok, err := bestQualityUnder(tt.limit, encode)
+		if err != nil || ok != tt.ok || quality != tt.quality {
+			t.Errorf("bestQualityUnder(%d) = quality %d, %v, %v, want %d, %v", tt.limit, quality, ok, err, tt.quality, tt.ok)
+		}
+		if ok && int64(len(data)) > tt.limit {
+			t.Errorf("bestQualityUnder(%d) returned %d bytes", tt.limit, len(data))
+		}
+	}
+}
+
+func TestWithoutSideOutputs(t *testing.T) {
+	args := []string{"in.heic[0]", "-auto-orient",
+		"(", "+clone", "-resize", "800x800>", "-write", "in_800.jpg", "+delete", ")",
+		"(", "-clone", "0", "-colorspace", "gray", ")",
+		"(", "+clone", "-thumbnail", "256x256>", "-write", "in_thumb.jpg", "+delete", ")",
+		"-quality", "80"}
+	want := []string{"in.heic[0]", "-auto-orient", "(", "-clone", "0", "-colorspace", "gray", ")", "-quality", "80"}
+	if got := withoutSideOutputs(args); !slices.Equal(got, want) {
+		t.Errorf("withoutSideOutputs() = %q, want %q", got, want)
+	}
+}
+
+func TestFitTargetSizeWritesSideOutputsOnce(t *testing.T) {
+	log := stubMagick(t)
+	t.Setenv("STUB_BYTES_PER_QUALITY", "10")
+	setFlag(t, &targetBytes, 555)
+	setFlag(t, shrinkToFit, false)
+	args := []string{"in.heic[0]",
+		"(", "+clone", "-thumbnail", "256x256>", "-write", t.TempDir() + "/in_thumb.jpg", "+delete", ")",
+		"out.jpg"}
+
+	data, quality, scale, _, err := fitTargetSize(context.Background(), args, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quality != 55 || scale != 100 || len(data) != 550 {
+		t.Errorf("fitTargetSize() = %d bytes at quality %d, %d%% scale, want 550 bytes at quality 55, 100%%", len(data), quality, scale)
+	}
+	commands := stubCommands(t, log)
+	var writes int
+	for _, cmd := range commands[:len(commands)-1] {
+		if strings.Contains(cmd, "-write") {
+			t.Errorf("search run %q writes side outputs", cmd)
+		}
+	}
+	for _, cmd := range commands {
+		if strings.Contains(cmd, "-write") {
+			writes++
+		}
+	}
+	if last := commands[len(commands)-1]; writes != 1 || !strings.Contains(last, "-quality 55 jpg:-") {
+		t.Errorf("final run %q, %d runs writing side outputs, want one at quality 55", last, writes)
+	}
+
+	// A target even quality 1 misses fails, pointing at -shrink-to-fit.
+	setFlag(t, &targetBytes, 5)
+	if _, _, _, _, err := fitTargetSize(context.Background(), []string{"in.heic[0]", "out.jpg"}, nil); err == nil || !strings.Contains(err.Error(), "-shrink-to-fit") {
+		t.Errorf("fitTargetSize() = %v, want a hint to use -shrink-to-fit", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// verifyTileAssembly checks that outFile has the full dimensions the container declares for inFile's
+// primary image in its ispe property, scaled as -resize or -max-dimension ask. Grid-encoded HEICs store the
+// image as tiles that libheif assembles into the primary image; builds that mishandle the grid produce a
+// single tile or a cropped image instead, and identify of the source would decode it the same broken way.
+// A 90 degree rotation is accepted, since orientation handling may swap width and height, and so is a pixel
+// of rounding on scaled outputs.
+func verifyTileAssembly(inFile, outFile string) error {
+	info, err := parseHEIF(inFile)
+	if err != nil {
+		return fmt.Errorf("failed to read the declared dimensions of %s: %v", inFile, err)
+	}
+	inW, inH := info.Width, info.Height
+	if inW < 1 || inH < 1 {
+		return fmt.Errorf("failed to read the declared dimensions of %s: the primary image has no ispe property", inFile)
+	}
+	outW, outH, err := imageDimensions(outFile)
+	if err != nil {
+		return err
+	}
+	w, h := expectedDimensions(inW, inH)
+	rw, rh := expectedDimensions(inH, inW)
+	if (near(outW, w) && near(outH, h)) || (near(outW, rw) && near(outH, rh)) {
+		return nil
+	}
+	return fmt.Errorf("tile assembly failed for %s: output is %dx%d but the source declares %dx%d, expected %dx%d", inFile, outW, outH, inW, inH, w, h)
+}
+
+// expectedDimensions returns the size of the output of a w by h image under -resize, which fits it
+// within the box keeping the aspect ratio, or -max-dimension, which only ever shrinks.
+func expectedDimensions(w, h int) (int, int) {
+	box, enlarge := *resizeGeom, true
+	if box == "" && *maxDimension > 0 {
+		box, enlarge = fmt.Sprintf("%dx%d", *maxDimension, *maxDimension), false
+	}
+	if box == "" || w < 1 || h < 1 {
+		return w, h
+	}
+	boxW, boxH, _ := strings.Cut(box, "x")
+	scale := math.Inf(1)
+	if n, err := strconv.Atoi(boxW); err == nil && n > 0 {
+		scale = min(scale, float64(n)/float64(w))
+	}
+	if n, err := strconv.Atoi(boxH); err == nil && n > 0 {
+		scale = min(scale, float64(n)/float64(h))
+	}
+	if math.IsInf(scale, 1) || (!enlarge && scale >= 1) {
+		return w, h
+	}
+	return max(int(math.Round(float64(w)*scale)), 1), max(int(math.Round(float64(h)*scale)), 1)
+}
+
+// near reports whether two pixel sizes differ by at most one, the rounding ImageMagick may apply.
+func near(a, b int) bool {
+	return a-b <= 1 && b-a <= 1
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpectedDimensions(t *testing.T) {
+	tests := []struct {
+		resize string
+		maxDim int
+		w, h   int
+		wantW  int
+		wantH  int
+	}{
+		{w: 4000, h: 3000, wantW: 4000, wantH: 3000},
+		{resize: "1920x1080", w: 4000, h: 3000, wantW: 1440, wantH: 1080},
+		{resize: "1920x", w: 4000, h: 3000, wantW: 1920, wantH: 1440},
+		{resize: "x1080", w: 3000, h: 4000, wantW: 810, wantH: 1080},
+		{resize: "8000x8000", w: 4000, h: 3000, wantW: 8000, wantH: 6000},
+		{maxDim: 2048, w: 4000, h: 3000, wantW: 2048, wantH: 1536},
+		{maxDim: 8000, w: 4000, h: 3000, wantW: 4000, wantH: 3000},
+		{resize: "1000x1000", maxDim: 2048, w: 4000, h: 3000, wantW: 1000, wantH: 750},
+	}
+	for _, tt := range tests {
+		setFlag(t, resizeGeom, tt.resize)
+		setFlag(t, maxDimension, tt.maxDim)
+		if w, h := expectedDimensions(tt.w, tt.h); w != tt.wantW || h != tt.wantH {
+			t.Errorf("expectedDimensions(%d, %d) with -resize %q -max-dimension %d = %dx%d, want %dx%d",
+				tt.w, tt.h, tt.resize, tt.maxDim, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
+
+func TestVerifyTileAssembly(t *testing.T) {
+	stubMagick(t)
+	dir := t.TempDir()
+	in := writeFile(t, dir, "IMG_1.heic", string(buildHEIF(heifFile{
+		primary: 2,
+		items:   []string{"hvc1", "grid", "hvc1", "hvc1"},
+		refs:    [][]byte{box("dimg", u16(2), u16(2), u16(3), u16(4))},
+	})))
+	// identify decodes the source with the same broken tile assembly; only the declared size counts.
+	writeFile(t, dir, "IMG_1.heic.dims", "512 512")
+	out := filepath.Join(dir, "IMG_1.jpg")
+
+	tests := []struct {
+		name   string
+		dims   string
+		resize string
+		err    string
+	}{
+		{name: "full image", dims: "4000 3000"},
+		{name: "rotated", dims: "3000 4000"},
+		{name: "resized with rounding", dims: "1441 1080", resize: "1920x1080"},
+		{name: "single tile", dims: "512 512", err: "tile assembly failed"},
+		{name: "cropped", dims: "4000 2048", err: "expected 4000x3000"},
+		{name: "not resized", dims: "4000 3000", resize: "1920x1080", err: "expected 1440x1080"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, resizeGeom, tt.resize)
+			writeFile(t, dir, "IMG_1.jpg.dims", tt.dims)
+			err := verifyTileAssembly(in, out)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("verifyTileAssembly() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("verifyTileAssembly() = %v, want an error mentioning %q", err, tt.err)
+			}
+		})
+	}
+
+	notHEIF := writeFile(t, dir, "IMG_2.heic", "not a HEIF file")
+	if err := verifyTileAssembly(notHEIF, out); err == nil || !strings.Contains(err.Error(), "declared dimensions") {
+		t.Errorf("verifyTileAssembly() = %v for a source without declared dimensions", err)
+	}
+}
+
+func TestConvertVerifiesTiles(t *testing.T) {
+	log := stubMagick(t)
+	dir := t.TempDir()
+	setFlag(t, outType, "jpg")
+	setFlag(t, verifyTiles, true)
+	in := writeFile(t, dir, "IMG_1.heic", string(buildHEIF(heifFile{
+		primary: 2,
+		items:   []string{"hvc1", "grid", "hvc1", "hvc1"},
+		refs:    [][]byte{box("dimg", u16(2), u16(2), u16(3), u16(4))},
+	})))
+	// The build decoded a single tile rather than the grid.
+	writeFile(t, dir, "IMG_1.jpg.dims", "512 512")
+
+	res := convertFile(context.Background(), in)
+	if res.Err == nil || !strings.Contains(res.Err.Error(), "tile assembly failed for "+in) {
+		t.Errorf("convertFile() = %v, want a tile assembly failure", res.Err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "IMG_1.jpg")); !os.IsNotExist(err) {
+		t.Errorf("the incomplete output was left behind: %v", err)
+	}
+	commands := stubCommands(t, log)
+	if len(commands) == 0 || !strings.HasPrefix(commands[0], "convert "+in+"[1] ") {
+		t.Errorf("commands = %q, want the conversion to read the grid primary %s[1]", commands, in)
+	}
+}
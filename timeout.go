@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// conversionTimeout returns the deadline for converting a source of size bytes: the fixed -timeout, or
+// under -timeout-per-mb a deadline scaled by size but never below -min-timeout. Zero means unbounded.
+func conversionTimeout(size int64) time.Duration {
+	if *timeoutPerMB <= 0 {
+		return *timeout
+	}
+	d := time.Duration(float64(*timeoutPerMB) * float64(size) / (1 << 20))
+	return max(d, *minTimeout)
+}
+
+// conversionContext derives the context bounding the commands run for a source of size bytes from parent.
+func conversionContext(parent context.Context, size int64) (context.Context, context.CancelFunc) {
+	if d := conversionTimeout(size); d > 0 {
+		return context.WithTimeout(parent, d)
+	}
+	return context.WithCancel(parent)
+}
+
+// contextError replaces err with a clearer message when ctx timed out or was interrupted while
+// converting res.Source.
+func contextError(ctx context.Context, res *fileResult, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("conversion of %s timed out after %s", res.Source, conversionTimeout(res.SourceSize))
+	case errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("conversion of %s was %w", res.Source, errInterrupted)
+	}
+	return err
+}
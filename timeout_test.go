@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConversionTimeout(t *testing.T) {
+	const mb = 1 << 20
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		perMB   time.Duration
+		min     time.Duration
+		size    int64
+		want    time.Duration
+	}{
+		{name: "unbounded", size: 100 * mb, want: 0},
+		{name: "fixed", timeout: time.Minute, size: 100 * mb, want: time.Minute},
+		{name: "scaled", perMB: 10 * time.Second, min: 30 * time.Second, size: 12 * mb, want: 2 * time.Minute},
+		{name: "scaled over a fixed -timeout", timeout: time.Minute, perMB: 10 * time.Second, min: 30 * time.Second, size: 12 * mb, want: 2 * time.Minute},
+		{name: "fraction of a MB", perMB: 10 * time.Second, size: mb / 2, want: 5 * time.Second},
+		{name: "small file gets the floor", perMB: 10 * time.Second, min: 30 * time.Second, size: mb, want: 30 * time.Second},
+		{name: "empty file gets the floor", perMB: 10 * time.Second, min: 30 * time.Second, size: 0, want: 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, timeout, tt.timeout)
+			setFlag(t, timeoutPerMB, tt.perMB)
+			setFlag(t, minTimeout, tt.min)
+			if got := conversionTimeout(tt.size); got != tt.want {
+				t.Errorf("conversionTimeout(%d) = %s, want %s", tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContextError(t *testing.T) {
+	setFlag(t, timeout, time.Nanosecond)
+	setFlag(t, timeoutPerMB, 0)
+	res := &fileResult{Source: "/p/a.heic"}
+	cause := errors.New("signal: terminated")
+
+	ctx, cancel := conversionContext(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+	if err := contextError(ctx, res, cause); err == nil || !strings.Contains(err.Error(), "timed out after 1ns") {
+		t.Errorf("contextError() after the deadline = %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := contextError(ctx, res, cause); !errors.Is(err, errInterrupted) {
+		t.Errorf("contextError() after an interrupt = %v, want errInterrupted", err)
+	}
+
+	if err := contextError(context.Background(), res, cause); err != cause {
+		t.Errorf("contextError() without a deadline = %v, want the error unchanged", err)
+	}
+}
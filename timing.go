@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// stageTotals accumulates decode and encode time across all files for -split-timing.
+var stageTotals struct {
+	decode, encode atomic.Int64
+}
+
+// runSplitPipeline runs a convert invocation as two timed stages: decoding the source into a temporary
+// MIFF file, then encoding that file with the original options into the output. args must start with
+// the input path, as built by buildConvertArgs.
+func runSplitPipeline(ctx context.Context, args, env []string, stdout, stderr io.Writer) (decode, encode time.Duration, err error) {
+	tmp, err := os.CreateTemp("", "convert_heic_*.miff")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create intermediate file: %v", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	start := time.Now()
+	cmd := magickCommandContext(ctx, "convert", args[0], "miff:"+tmp.Name())
+	cmd.Env, cmd.Stdout, cmd.Stderr = env, stdout, stderr
+	if err := cmd.Run(); err != nil {
+		return 0, 0, fmt.Errorf("decode stage failed: %v", err)
+	}
+	decode = time.Since(start)
+
+	encodeArgs := append([]string{"miff:" + tmp.Name()}, args[1:]...)
+	start = time.Now()
+	cmd = magickCommandContext(ctx, "convert", encodeArgs...)
+	cmd.Env, cmd.Stdout, cmd.Stderr = env, stdout, stderr
+	if err := cmd.Run(); err != nil {
+		return decode, 0, fmt.Errorf("encode stage failed: %v", err)
+	}
+	encode = time.Since(start)
+
+	stageTotals.decode.Add(int64(decode))
+	stageTotals.encode.Add(int64(encode))
+	return decode, encode, nil
+}
+
+// reportStageTotals prints the aggregate decode and encode time and each stage's share.
+func reportStageTotals() {
+	decode := time.Duration(stageTotals.decode.Load())
+	encode := time.Duration(stageTotals.encode.Load())
+	total := decode + encode
+	if total == 0 {
+		return
+	}
+	fmt.Fprintf(infoOut, "INFO: Decode time %s (%.0f%%), encode time %s (%.0f%%).\n",
+		decode.Round(time.Millisecond), 100*float64(decode)/float64(total),
+		encode.Round(time.Millisecond), 100*float64(encode)/float64(total))
+}
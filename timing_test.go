@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSplitPipeline(t *testing.T) {
+	log := stubMagick(t)
+	t.Setenv("STUB_SLEEP", "0.1")
+	dir := t.TempDir()
+	setFlag(t, outType, "jpg")
+	setFlag(t, splitTiming, true)
+	setFlag(t, &infoOut, io.Discard)
+	stageTotals.decode.Store(0)
+	stageTotals.encode.Store(0)
+	t.Cleanup(func() {
+		stageTotals.decode.Store(0)
+		stageTotals.encode.Store(0)
+	})
+	in := writeFile(t, dir, "IMG_1.heic", "source")
+	out := filepath.Join(dir, "IMG_1.jpg")
+
+	res := convertFile(context.Background(), in)
+	if res.Status != statusConverted || res.Err != nil {
+		t.Fatalf("convertFile() = %s, %v", res.Status, res.Err)
+	}
+	// Each stage is one stubbed convert that sleeps for 0.1s.
+	stage := 100 * time.Millisecond
+	if res.DecodeTime < stage || res.EncodeTime < stage {
+		t.Errorf("decode %s, encode %s, want both at least %s", res.DecodeTime, res.EncodeTime, stage)
+	}
+	if got := time.Duration(stageTotals.decode.Load()); got != res.DecodeTime {
+		t.Errorf("total decode time = %s, want %s", got, res.DecodeTime)
+	}
+	if got := time.Duration(stageTotals.encode.Load()); got != res.EncodeTime {
+		t.Errorf("total encode time = %s, want %s", got, res.EncodeTime)
+	}
+
+	var commands []string
+	for _, c := range stubCommands(t, log) {
+		if strings.HasPrefix(c, "convert ") {
+			commands = append(commands, c)
+		}
+	}
+	if len(commands) != 2 {
+		t.Fatalf("ran %q, want a decode and an encode stage", commands)
+	}
+	decodeArgs, encodeArgs := strings.Fields(commands[0]), strings.Fields(commands[1])
+	intermediate := decodeArgs[len(decodeArgs)-1]
+	if decodeArgs[1] != in+"[0]" || !strings.HasPrefix(intermediate, "miff:") {
+		t.Errorf("decode stage ran %q, want the source decoded to a MIFF file", commands[0])
+	}
+	// The encode stage writes a temporary file beside the output, renamed into place once it succeeds.
+	target := encodeArgs[len(encodeArgs)-1]
+	if encodeArgs[1] != intermediate || filepath.Dir(target) != dir || filepath.Ext(target) != ".jpg" {
+		t.Errorf("encode stage ran %q, want %s encoded next to %s", commands[1], intermediate, out)
+	}
+	if _, err := os.Stat(out); err != nil {
+		t.Errorf("output was not written: %v", err)
+	}
+	if _, err := os.Stat(strings.TrimPrefix(intermediate, "miff:")); !os.IsNotExist(err) {
+		t.Errorf("intermediate file was left behind: %v", err)
+	}
+
+	var buf bytes.Buffer
+	setFlag(t, &infoOut, io.Writer(&buf))
+	reportStageTotals()
+	if !strings.Contains(buf.String(), "Decode time") || !strings.Contains(buf.String(), "encode time") {
+		t.Errorf("reportStageTotals() printed %q", buf.String())
+	}
+}
+
+func TestRunSplitPipelineDecodeFailure(t *testing.T) {
+	stubMagick(t)
+	dir := t.TempDir()
+	in := writeFile(t, dir, "IMG_1.heic", "source")
+	writeFile(t, dir, "IMG_1.heic.fail", "no decode delegate")
+	decode, encode, err := runSplitPipeline(context.Background(), []string{in + "[0]", filepath.Join(dir, "IMG_1.jpg")}, nil, io.Discard, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "decode stage failed") || decode != 0 || encode != 0 {
+		t.Errorf("runSplitPipeline() = %s, %s, %v, want a decode stage failure", decode, encode, err)
+	}
+}
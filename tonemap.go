@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// toneMapArgs compress an HDR source into SDR: work in linear light, roll off highlights with a
+// sigmoidal curve, then return to 8-bit sRGB with out-of-range values clamped.
+var toneMapArgs = []string{"-colorspace", "RGB", "-sigmoidal-contrast", "3,50%", "-colorspace", "sRGB", "-clamp", "-depth", "8"}
+
+// ITU-T H.273 transfer characteristics of HDR content.
+const (
+	transferPQ  = 16 // SMPTE ST 2084
+	transferHLG = 18 // ARIB STD-B67
+)
+
+// isHDR reports whether inFile looks like an HDR image. The container's bit depth and nclx transfer
+// characteristics decide when it declares them; otherwise identify's depth and ICC profile description do.
+func isHDR(inFile string) (bool, error) {
+	if info, err := parseHEIF(inFile); err == nil {
+		if info.BitDepth > 8 || info.Transfer == transferPQ || info.Transfer == transferHLG {
+			return true, nil
+		}
+	}
+	output, err := magickCommand("identify", "-format", "%z|%[icc:description]", inFile+"[0]").Output()
+	if err != nil {
+		return false, fmt.Errorf("failed to identify %s: %v", inFile, err)
+	}
+	return parseHDRInfo(string(output)), nil
+}
+
+// parseHDRInfo decides HDR-ness from identify's "depth|profile description" output. Sources deeper than
+// 8 bits or whose profile names a PQ or HLG transfer, such as "ITU-R BT.2100 PQ", are treated as HDR. The
+// description is compared word by word, so names that merely contain those letters do not count.
+func parseHDRInfo(output string) bool {
+	depthStr, desc, _ := strings.Cut(strings.TrimSpace(output), "|")
+	if depth, err := strconv.Atoi(strings.TrimSpace(depthStr)); err == nil && depth > 8 {
+		return true
+	}
+	words := strings.FieldsFunc(strings.ToLower(desc), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		switch {
+		case word == "pq", word == "hlg":
+			return true
+		case word == "2084" && i > 0 && (words[i-1] == "st" || words[i-1] == "smpte"):
+			return true
+		}
+	}
+	return false
+}
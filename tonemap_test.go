@@ -0,0 +1,71 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseHDRInfo(t *testing.T) {
+	tests := []struct {
+		output string
+		want   bool
+	}{
+		{"8|sRGB IEC61966-2.1", false},
+		{"8|Display P3", false},
+		{"10|Display P3", true},
+		{"16|", true},
+		{"8|ITU-R BT.2100 PQ", true},
+		{"8|Rec. ITU-R BT.2100-2 HLG", true},
+		{"8|SMPTE ST 2084 (PQ) D65", true},
+		{"8|st-2084", true},
+		// Letters or digits that merely appear inside other words are not transfer names.
+		{"8|Epqon Studio RGB", false},
+		{"8|Kodak DCS 2100 profile", false},
+		{"8|hlgx-calibrated 2084 monitor", false},
+		{"8|", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := parseHDRInfo(tt.output); got != tt.want {
+			t.Errorf("parseHDRInfo(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestToneMapArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		heif heifFile
+		icc  string
+		want bool
+	}{
+		{name: "10-bit source", heif: heifFile{primary: 1, items: []string{"hvc1"}}, want: true},
+		{name: "PQ transfer", heif: heifFile{primary: 1, items: []string{"hvc1"}, depth: 8, transfer: transferPQ}, want: true},
+		{name: "HLG transfer", heif: heifFile{primary: 1, items: []string{"hvc1"}, depth: 8, transfer: transferHLG}, want: true},
+		{name: "SDR source", heif: heifFile{primary: 1, items: []string{"hvc1"}, depth: 8, transfer: 13}, icc: "8|sRGB IEC61966-2.1"},
+		{name: "PQ profile", heif: heifFile{primary: 1, items: []string{"hvc1"}, depth: 8}, icc: "8|ITU-R BT.2100 PQ", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubMagick(t)
+			setFlag(t, outType, "jpg")
+			setFlag(t, toneMap, true)
+			dir := t.TempDir()
+			in := writeFile(t, dir, "IMG_1.heic", string(buildHEIF(tt.heif)))
+			if tt.icc != "" {
+				writeFile(t, dir, "IMG_1.heic.icc", tt.icc)
+			}
+			args, err := buildConvertArgs(in, "out.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{in + "[0]", "-auto-orient", "out.jpg"}
+			if tt.want {
+				want = slices.Concat([]string{in + "[0]"}, toneMapArgs, []string{"-auto-orient", "out.jpg"})
+			}
+			if !slices.Equal(args, want) {
+				t.Errorf("buildConvertArgs() = %q, want %q", args, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// vendorFixups maps a lowercased EXIF Make to the arguments that correct its known quirks. They are
+// inserted directly after the input so they run before any other processing.
+var vendorFixups = map[string][]string{
+	// Samsung HEICs carry the rotation in the HEIF container (which libheif already applies) and again
+	// in the EXIF Orientation tag, so honoring the tag rotates the image twice. Reset the tag instead.
+	"samsung": {"-orient", "TopLeft"},
+}
+
+// cameraMake returns the lowercased EXIF Make of inFile, or "" if it has none.
+func cameraMake(inFile string) (string, error) {
+	output, err := magickCommand("identify", "-format", "%[EXIF:Make]", inFile+"[0]").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to identify %s: %v", inFile, err)
+	}
+	return strings.ToLower(strings.TrimSpace(string(output))), nil
+}
+
+// vendorFixupArgs returns the fixup arguments for a camera make, matching on the vendor name prefix so
+// variants such as "SAMSUNG ELECTRONICS" are covered.
+func vendorFixupArgs(cameraMk string) []string {
+	for vendor, args := range vendorFixups {
+		if strings.HasPrefix(cameraMk, vendor) {
+			return args
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestVendorFixupArgs(t *testing.T) {
+	samsung := []string{"-orient", "TopLeft"}
+	tests := []struct {
+		cameraMk string
+		want     []string
+	}{
+		{"samsung", samsung},
+		{"samsung electronics", samsung},
+		{"apple", nil},
+		{"google", nil},
+		{"", nil},
+		// Only a prefix match counts, not a vendor name elsewhere in the make.
+		{"not samsung", nil},
+	}
+	for _, tt := range tests {
+		if got := vendorFixupArgs(tt.cameraMk); !slices.Equal(got, tt.want) {
+			t.Errorf("vendorFixupArgs(%q) = %q, want %q", tt.cameraMk, got, tt.want)
+		}
+	}
+}
+
+func TestVendorFixupsApplied(t *testing.T) {
+	tests := []struct {
+		cameraMk string
+		want     bool
+	}{
+		{"SAMSUNG", true},
+		{"Samsung Electronics\n", true},
+		{"Apple", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cameraMk, func(t *testing.T) {
+			stubMagick(t)
+			t.Setenv("STUB_IDENTIFY", tt.cameraMk)
+			setFlag(t, outType, "jpg")
+			setFlag(t, vendorFix, true)
+			in := writeFile(t, t.TempDir(), "IMG_1.heic", "source")
+			args, err := buildConvertArgs(in, "out.jpg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{in + "[0]", "-auto-orient", "out.jpg"}
+			if tt.want {
+				want = []string{in + "[0]", "-orient", "TopLeft", "-auto-orient", "out.jpg"}
+			}
+			if !slices.Equal(args, want) {
+				t.Errorf("buildConvertArgs() = %q, want %q", args, want)
+			}
+		})
+	}
+}
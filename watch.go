@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// watchedFile is what a -watch scan last saw of one source.
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+	// handled is set once the source was passed to a conversion in its current state.
+	handled bool
+}
+
+// validateWatch checks that -watch has a single directory input and no flags that only make sense for one pass.
+func validateWatch(inPathInfo os.FileInfo) error {
+	if inPathInfo == nil || !inPathInfo.IsDir() {
+		return errors.New("-watch requires a single directory -input")
+	}
+	if *watchInterval <= 0 {
+		return errors.New("-watch-interval must be positive")
+	}
+	if *dryRun || *diffMode || *burstPick != "" || *sample > 0 || *rebuildIdx || *dumpCommands != "" {
+		return errors.New("-watch cannot be used with -dry-run, -diff, -burst-pick, -sample, -rebuild-index or -dump-commands")
+	}
+	return nil
+}
+
+// watchDirectory polls dirPath every -watch-interval until ctx is canceled, converting sources as they
+// appear. A source is only converted once its size and modification time are unchanged between two scans,
+// so files still being copied or uploaded are left alone until they are complete.
+func watchDirectory(ctx context.Context, dirPath string) error {
+	fmt.Fprintf(infoOut, "INFO: Watching %s for new HEIC files every %s, press Ctrl-C to stop.\n", dirPath, *watchInterval)
+	seen := make(map[string]*watchedFile)
+	ticker := time.NewTicker(*watchInterval)
+	defer ticker.Stop()
+	for {
+		files, err := scanHeicFiles(dirPath)
+		if err != nil {
+			return err
+		}
+		if ready := settledFiles(seen, files); len(ready) > 0 {
+			if err := processFileList(ctx, ready); err != nil && !errors.Is(err, errInterrupted) {
+				// Keep watching: the failures are reported, and the sources are retried once they change.
+				log.Printf("ERROR: %v\n", err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			fmt.Fprintln(infoOut, "INFO: Stopped watching.")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// settledFiles updates seen with the current scan and returns the sources that are unchanged since the
+// previous scan and not handled yet, marking them handled. Sources that disappeared are forgotten.
+func settledFiles(seen map[string]*watchedFile, files []string) []string {
+	current := make(map[string]bool, len(files))
+	var ready []string
+	for _, file := range files {
+		current[file] = true
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		prev, ok := seen[file]
+		if !ok || prev.size != info.Size() || !prev.modTime.Equal(info.ModTime()) {
+			seen[file] = &watchedFile{size: info.Size(), modTime: info.ModTime()}
+			continue
+		}
+		if !prev.handled {
+			prev.handled = true
+			ready = append(ready, file)
+		}
+	}
+	for file := range seen {
+		if !current[file] {
+			delete(seen, file)
+		}
+	}
+	return ready
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// webdavStore reads and writes files on a WebDAV server such as Nextcloud. base is the HTTP(S) URL the
+// keys are resolved against; user info in it is sent as basic auth.
+type webdavStore struct {
+	base     *url.URL
+	user     string
+	password string
+	// collections are the collections Put has already created, so each is asked for once.
+	collections sync.Map
+}
+
+// newWebDAVStore returns the store for the server behind u, with its path dropped.
+func newWebDAVStore(u *url.URL, secure bool) *webdavStore {
+	base := &url.URL{Scheme: "http", Host: u.Host, Path: "/"}
+	if secure {
+		base.Scheme = "https"
+	}
+	s := &webdavStore{base: base}
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	return s
+}
+
+// webdavMultistatus is the part of a PROPFIND response the store reads.
+type webdavMultistatus struct {
+	Responses []struct {
+		Href         string    `xml:"href"`
+		Collection   *struct{} `xml:"propstat>prop>resourcetype>collection"`
+		Length       int64     `xml:"propstat>prop>getcontentlength"`
+		LastModified string    `xml:"propstat>prop>getlastmodified"`
+	} `xml:"response"`
+}
+
+// List returns the files under the collection prefix, relative to it, descending into nested
+// collections when recursive is set. Each collection is listed with Depth 1, since many servers refuse
+// Depth infinity.
+func (s *webdavStore) List(ctx context.Context, prefix string, recursive bool) ([]remoteObject, error) {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+	var files []remoteObject
+	pending := []string{prefix}
+	for len(pending) > 0 {
+		dir := pending[0]
+		pending = pending[1:]
+		body := strings.NewReader(`<?xml version="1.0"?><propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/></prop></propfind>`)
+		resp, err := s.do(ctx, "PROPFIND", dir, body, map[string]string{"Depth": "1", "Content-Type": "application/xml"})
+		if err != nil {
+			return nil, err
+		}
+		var ms webdavMultistatus
+		err = xml.NewDecoder(resp.Body).Decode(&ms)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the listing of %s: %v", dir, err)
+		}
+		for _, r := range ms.Responses {
+			href, err := url.Parse(r.Href)
+			if err != nil {
+				continue
+			}
+			p := href.Path
+			if strings.TrimSuffix(p, "/") == strings.TrimSuffix(s.resolve(dir).Path, "/") {
+				// The collection itself.
+				continue
+			}
+			rel := strings.TrimPrefix(strings.TrimSuffix(p, "/"), strings.TrimSuffix(s.resolve(prefix).Path, "/")+"/")
+			if r.Collection != nil {
+				if recursive {
+					pending = append(pending, prefix+rel+"/")
+				}
+				continue
+			}
+			modTime, _ := http.ParseTime(r.LastModified)
+			files = append(files, remoteObject{key: rel, size: r.Length, modTime: modTime})
+		}
+	}
+	return files, nil
+}
+
+// Get writes the file at key to w.
+func (s *webdavStore) Get(ctx context.Context, key string, w io.Writer) error {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Put uploads size bytes from r to key, creating its parent collections first. A collection that
+// can't be created, usually because it exists or lies above the user's own files, is left for the PUT
+// to report.
+func (s *webdavStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	dir := ""
+	for _, part := range strings.Split(path.Dir(key), "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		dir += part + "/"
+		if _, done := s.collections.LoadOrStore(dir, true); done {
+			continue
+		}
+		resp, err := s.send(ctx, "MKCOL", dir, nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	req, err := s.request(ctx, http.MethodPut, key, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := s.check(remoteClient.Do(req))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// resolve returns the URL of key on the server.
+func (s *webdavStore) resolve(key string) *url.URL {
+	u := *s.base
+	u.Path = "/" + strings.TrimPrefix(key, "/")
+	return &u
+}
+
+// request builds a request for key with basic auth when the URL carried credentials.
+func (s *webdavStore) request(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.resolve(key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+	return req, nil
+}
+
+// send sends a request for key and returns the response whatever its status.
+func (s *webdavStore) send(ctx context.Context, method, key string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := s.request(ctx, method, key, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	return remoteClient.Do(req)
+}
+
+// do is send for requests that must succeed.
+func (s *webdavStore) do(ctx context.Context, method, key string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	return s.check(s.send(ctx, method, key, body, headers))
+}
+
+// check turns a response with a non-2xx status into an error naming the request.
+func (s *webdavStore) check(resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", resp.Request.Method, resp.Request.URL.Redacted(), resp.Status)
+	}
+	return resp, nil
+}
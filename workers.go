@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// bytesPerPixel approximates ImageMagick's working memory per source pixel: a 16-bit RGBA pixel cache
+// (8 bytes) plus roughly as much again for the decoded HEIC and the encoder's buffers.
+const bytesPerPixel = 16
+
+// fallbackProcessMemory is charged against -max-mem for sources whose dimensions can't be read.
+const fallbackProcessMemory = 512 << 20
+
+// memSlots limits the summed memory estimate of running conversions when -max-mem is set; nil means no limit.
+var memSlots *memoryBudget
+
+// workerCount is the -workers flag: a positive count, or "auto" (stored as 0) for one worker per CPU.
+type workerCount struct {
+	n *int
+}
+
+func newWorkerCount(name, usage string) *int {
+	w := workerCount{n: new(int)}
+	flag.Var(w, name, usage)
+	return w.n
+}
+
+func (w workerCount) String() string {
+	if w.n == nil || *w.n == 0 {
+		return "auto"
+	}
+	return strconv.Itoa(*w.n)
+}
+
+func (w workerCount) Set(value string) error {
+	if value == "auto" {
+		*w.n = 0
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return fmt.Errorf("must be a positive number or auto")
+	}
+	*w.n = n
+	return nil
+}
+
+// resolveWorkers turns -workers auto into one worker per CPU.
+func resolveWorkers() {
+	if *workers == 0 {
+		*workers = runtime.NumCPU()
+	}
+}
+
+// validateMaxMem parses -max-mem and sets up the memory budget.
+func validateMaxMem() error {
+	if *maxMem == "" {
+		return nil
+	}
+	limit, err := parseByteSize(*maxMem)
+	if err != nil {
+		return fmt.Errorf("invalid -max-mem: %v", err)
+	}
+	memSlots = newMemoryBudget(limit)
+	return nil
+}
+
+// memoryBudget is a weighted semaphore over an estimated number of bytes.
+type memoryBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	used  int64
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	b := &memoryBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until n bytes fit in the budget and returns the amount actually reserved. A conversion
+// larger than the whole budget still runs, but only once nothing else is running.
+func (b *memoryBudget) acquire(ctx context.Context, n int64) (int64, error) {
+	if n > b.limit {
+		n = b.limit
+	}
+	stop := context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.cond.Broadcast()
+	})
+	defer stop()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.used > 0 && b.used+n > b.limit {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		b.cond.Wait()
+	}
+	b.used += n
+	return n, nil
+}
+
+// release returns n bytes reserved by acquire.
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= n
+	b.cond.Broadcast()
+}
+
+// conversionMemory estimates how much memory converting inFile takes from its pixel count.
+func conversionMemory(inFile string) int64 {
+	width, height, err := imageDimensions(inFile)
+	if err != nil || width <= 0 || height <= 0 {
+		return fallbackProcessMemory
+	}
+	return int64(width) * int64(height) * bytesPerPixel
+}
+
+// reserveMemory waits for room in the -max-mem budget for inFile and returns the function that frees it.
+func reserveMemory(ctx context.Context, inFile string) (func(), error) {
+	if memSlots == nil {
+		return func() {}, nil
+	}
+	n, err := memSlots.acquire(ctx, conversionMemory(inFile))
+	if err != nil {
+		return nil, err
+	}
+	return func() { memSlots.release(n) }, nil
+}